@@ -0,0 +1,278 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/GruffDebate/server/gruff"
+	"github.com/labstack/echo"
+)
+
+func requirePermission(ctx *gruff.ServerContext, permission, msg string) gruff.Error {
+	can, err := ctx.UserContext.Can(ctx, permission)
+	if err != nil {
+		return err
+	}
+	if !can {
+		return gruff.NewPermissionError(msg)
+	}
+	return nil
+}
+
+// Keeps users from granting roles with more permissions than they have themselves
+func requireSavableRole(ctx *gruff.ServerContext, role gruff.Role) gruff.Error {
+	can, err := ctx.UserContext.CanSaveRole(ctx, role)
+	if err != nil {
+		return err
+	}
+	if !can {
+		return gruff.NewPermissionError("You can only define roles whose permissions you have yourself")
+	}
+	return nil
+}
+
+func requireGrantableRole(ctx *gruff.ServerContext, name string) gruff.Error {
+	can, err := ctx.UserContext.CanGrantRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !can {
+		return gruff.NewPermissionError("You can only grant roles whose permissions you have yourself")
+	}
+	return nil
+}
+
+func GetScoreQueue(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_SCORE_QUEUE_VIEW, "You do not have permission to view this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	status, err := gruff.GetScoreQueueStatus(ctx)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// Signs the user out everywhere, by revoking all of their access and refresh tokens
+func RevokeUserTokens(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_USER_BAN, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	user := gruff.User{}
+	user.Key = c.Param("id")
+	if err := user.Load(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := user.RevokeTokens(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Bans the user, so that they can't sign in, and signs them out everywhere
+func BanUser(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_USER_BAN, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	user := gruff.User{}
+	user.Key = c.Param("id")
+	if err := user.Load(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := user.Ban(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func UnbanUser(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_USER_BAN, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	user := gruff.User{}
+	user.Key = c.Param("id")
+	if err := user.Load(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := user.Unban(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func ListRoles(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_ROLE_GRANT, "You do not have permission to view this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	roles, err := gruff.ListRoles(ctx)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+// Defines the named role, or replaces its definition
+func SaveRole(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_ROLE_EDIT, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	role := gruff.Role{}
+	if err := c.Bind(&role); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+	role.Key = c.Param("name")
+
+	if err := requireSavableRole(ctx, role); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := role.Create(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, role)
+}
+
+// Removes the named role's saved definition, returning a built-in role to its defaults
+func DeleteRole(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_ROLE_EDIT, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	role := gruff.Role{Key: c.Param("name")}
+	if err := requireSavableRole(ctx, role); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := role.Delete(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func GrantRole(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_ROLE_GRANT, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	user := gruff.User{}
+	user.Key = c.Param("id")
+	if err := user.Load(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := requireGrantableRole(ctx, c.Param("role")); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := user.GrantRole(ctx, c.Param("role")); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"roles": user.RoleNames()})
+}
+
+func RevokeRole(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_ROLE_GRANT, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	user := gruff.User{}
+	user.Key = c.Param("id")
+	if err := user.Load(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := requireGrantableRole(ctx, c.Param("role")); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := user.RevokeRole(ctx, c.Param("role")); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"roles": user.RoleNames()})
+}
+
+func ListClients(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_CLIENT_EDIT, "You do not have permission to view this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	clients, err := gruff.ListClients(ctx)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, clients)
+}
+
+// Registers the client with the given ID in the database, or replaces its registration
+func SaveClient(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_CLIENT_EDIT, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	client := gruff.Client{}
+	if err := c.Bind(&client); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+	client.Key = c.Param("id")
+
+	if err := client.Create(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, client)
+}
+
+func DeleteClient(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requirePermission(ctx, gruff.PERMISSION_CLIENT_EDIT, "You do not have permission to modify this item"); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	client := gruff.Client{Key: c.Param("id")}
+	if err := client.Delete(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
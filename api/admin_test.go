@@ -0,0 +1,489 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GruffDebate/server/gruff"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetScoreQueue(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := gruff.User{
+		Name:     "Queue Watcher",
+		Username: "QueueWatcher",
+		Email:    "queue@gruff.org",
+		Password: "123456",
+		Admin:    true,
+	}
+	err := admin.Create(CTX)
+	assert.NoError(t, err)
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.GET("/api/admin/score-queue")
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	expected, _ := gruff.GetScoreQueueStatus(CTX)
+	expectedJSON, _ := json.Marshal(expected)
+
+	r = New(tokenForTestUser(admin))
+	r.GET("/api/admin/score-queue")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, string(expectedJSON), res.Body.String())
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := gruff.User{
+		Name:     "Token Revoker",
+		Username: "TokenRevoker",
+		Email:    "revoker@gruff.org",
+		Password: "123456",
+		Admin:    true,
+	}
+	err := admin.Create(CTX)
+	assert.NoError(t, err)
+
+	banned := createUser("banned", "banned", "banned@test1.com")
+	token := tokenForTestUser(banned)
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/revoke-tokens", banned.ArangoKey()))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(admin))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/revoke-tokens", banned.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	r = New(token)
+	r.GET("/api/users/me")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestBanUser(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := gruff.User{
+		Name:     "Ban Hammer",
+		Username: "BanHammer",
+		Email:    "banhammer@gruff.org",
+		Password: "123456",
+		Admin:    true,
+	}
+	err := admin.Create(CTX)
+	assert.NoError(t, err)
+
+	banned := createUser("banned", "banned", "banned@test1.com")
+	token := tokenForTestUser(banned)
+	signIn := map[string]interface{}{"email": "banned@test1.com", "password": "123456"}
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/ban", banned.ArangoKey()))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(admin))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/ban", banned.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	r = New(token)
+	r.GET("/api/users/me")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	// Banned users can't just sign in again
+	r = New(nil)
+	r.POST("/api/auth")
+	r.SetBody(signIn)
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "This account has been banned"}`, gruff.ERROR_SUBCODE_USER_BANNED), res.Body.String())
+
+	r = New(tokenForTestUser(admin))
+	r.DELETE(fmt.Sprintf("/api/admin/users/%s/ban", banned.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	r = New(nil)
+	r.POST("/api/auth")
+	r.SetBody(signIn)
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestGrantRole(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := gruff.User{
+		Name:     "Role Granter",
+		Username: "RoleGranter",
+		Email:    "granter@gruff.org",
+		Password: "123456",
+		Admin:    true,
+	}
+	err := admin.Create(CTX)
+	assert.NoError(t, err)
+
+	u := createUser("promoted", "promoted", "promoted@test1.com")
+
+	r := New(tokenForTestUser(u))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/roles/curator", u.ArangoKey()))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(admin))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/roles/nonexistent", u.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	r = New(tokenForTestUser(admin))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/roles/curator", u.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"roles": ["user", "curator"]}`, res.Body.String())
+
+	err = u.Load(CTX)
+	assert.NoError(t, err)
+	assert.True(t, u.Curator)
+	assert.True(t, u.HasRole(gruff.ROLE_CURATOR))
+
+	r = New(tokenForTestUser(admin))
+	r.DELETE(fmt.Sprintf("/api/admin/users/%s/roles/curator", u.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"roles": ["user"]}`, res.Body.String())
+
+	err = u.Load(CTX)
+	assert.NoError(t, err)
+	assert.False(t, u.Curator)
+
+	// Users who can grant roles still can't hand out more than they have
+	granter := gruff.Role{Key: "granter", Permissions: []string{gruff.PERMISSION_ROLE_GRANT}}
+	err = granter.Create(CTX)
+	assert.NoError(t, err)
+	err = u.GrantRole(CTX, "granter")
+	assert.NoError(t, err)
+
+	r = New(tokenForTestUser(u))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/roles/admin", u.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(u))
+	r.POST(fmt.Sprintf("/api/admin/users/%s/roles/curator", u.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(u))
+	r.DELETE(fmt.Sprintf("/api/admin/users/%s/roles/admin", admin.ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	err = u.Load(CTX)
+	assert.NoError(t, err)
+	assert.False(t, u.Admin)
+	assert.False(t, u.Curator)
+}
+
+func TestSaveRole(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := gruff.User{
+		Name:     "Role Maker",
+		Username: "RoleMaker",
+		Email:    "rolemaker@gruff.org",
+		Password: "123456",
+		Admin:    true,
+	}
+	err := admin.Create(CTX)
+	assert.NoError(t, err)
+
+	body := map[string]interface{}{
+		"desc":        "Keeps the peace",
+		"permissions": []string{"user.ban", "argument.move"},
+	}
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.PUT("/api/admin/roles/peacekeeper")
+	r.SetBody(body)
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(admin))
+	r.PUT("/api/admin/roles/peacekeeper")
+	r.SetBody(body)
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"_key": "peacekeeper", "desc": "Keeps the peace", "permissions": ["argument.move", "user.ban"]}`, res.Body.String())
+
+	u := createUser("peacekeeper", "peacekeeper", "peacekeeper@test1.com")
+	err = u.GrantRole(CTX, "peacekeeper")
+	assert.NoError(t, err)
+
+	can, err := u.Can(CTX, gruff.PERMISSION_USER_BAN)
+	assert.NoError(t, err)
+	assert.True(t, can)
+
+	r = New(tokenForTestUser(admin))
+	r.DELETE("/api/admin/roles/peacekeeper")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	can, err = u.Can(CTX, gruff.PERMISSION_USER_BAN)
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	// Editing roles doesn't let anyone give themselves more permissions
+	editor := gruff.Role{Key: "editor", Permissions: []string{gruff.PERMISSION_ROLE_EDIT}}
+	err = editor.Create(CTX)
+	assert.NoError(t, err)
+	err = u.GrantRole(CTX, "editor")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"editor", "peacekeeper", gruff.ROLE_USER} {
+		r = New(tokenForTestUser(u))
+		r.PUT(fmt.Sprintf("/api/admin/roles/%s", name))
+		r.SetBody(map[string]interface{}{"permissions": []string{"*"}})
+		res, _ = r.Run(Router())
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	}
+
+	r = New(tokenForTestUser(u))
+	r.PUT("/api/admin/roles/user")
+	r.SetBody(map[string]interface{}{"permissions": []string{gruff.PERMISSION_ROLE_EDIT}})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+}
+
+func TestSaveClient(t *testing.T) {
+	setup()
+	defer teardown()
+
+	admin := gruff.User{
+		Name:     "Client Keeper",
+		Username: "ClientKeeper",
+		Email:    "clientkeeper@gruff.org",
+		Password: "123456",
+		Admin:    true,
+	}
+	err := admin.Create(CTX)
+	assert.NoError(t, err)
+
+	body := map[string]interface{}{
+		"name":      "Web site",
+		"origins":   []string{"https://www.gruff.org/"},
+		"rateLimit": 3,
+	}
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.PUT("/api/admin/clients/gruff-web")
+	r.SetBody(body)
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(admin))
+	r.PUT("/api/admin/clients/gruff-web")
+	r.SetBody(body)
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"_key": "gruff-web", "name": "Web site", "origins": ["https://www.gruff.org"], "rateLimit": 3}`, res.Body.String())
+	defer func() {
+		client := gruff.Client{Key: "gruff-web"}
+		client.Delete(CTX)
+	}()
+
+	r = New(tokenForTestUser(admin))
+	r.GET("/api/admin/clients")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `[{"_key": "gruff-web", "name": "Web site", "origins": ["https://www.gruff.org"], "rateLimit": 3}]`, res.Body.String())
+
+	r = New(map[string]string{HeaderClient: "nobody"})
+	r.GET("/api/claims")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(map[string]string{HeaderClient: "gruff-web", "Origin": "https://www.evil.com"})
+	r.GET("/api/claims")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	// The client is limited to 3 requests a minute
+	for i := 0; i < 3; i++ {
+		r = New(map[string]string{HeaderClient: "gruff-web", "Origin": "https://www.gruff.org"})
+		r.GET("/api/claims")
+		res, _ = r.Run(Router())
+		assert.Equal(t, http.StatusOK, res.Code)
+	}
+	r = New(map[string]string{HeaderClient: "gruff-web", "Origin": "https://www.gruff.org"})
+	r.GET("/api/claims")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+}
+
+func TestRegisterFirstClient(t *testing.T) {
+	setup()
+	defer teardown()
+
+	pool := ARANGODB_POOL
+	ARANGODB_POOL = TESTDB
+	defer func() { ARANGODB_POOL = pool }()
+	router := SetUpRouter(ProductionMiddlewareConfigurer{})
+
+	admin := gruff.User{
+		Name:     "First Keeper",
+		Username: "FirstKeeper",
+		Email:    "firstkeeper@gruff.org",
+		Password: "123456",
+		Admin:    true,
+	}
+	err := admin.Create(CTX)
+	assert.NoError(t, err)
+
+	// Until there are any clients, the API can be used without one
+	r := New(tokenForTestUser(admin))
+	r.PUT("/api/admin/clients/gruff-web")
+	r.SetBody(map[string]interface{}{"name": "Web site", "origins": []string{"https://www.gruff.org"}})
+	res, _ := r.Run(router)
+	assert.Equal(t, http.StatusOK, res.Code)
+	defer func() {
+		client := gruff.Client{Key: "gruff-web"}
+		client.Delete(CTX)
+	}()
+
+	r = New(nil)
+	r.GET("/api/claims")
+	res, _ = r.Run(router)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(map[string]string{HeaderClient: "gruff-web"})
+	r.GET("/api/claims")
+	res, _ = r.Run(router)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestCORS(t *testing.T) {
+	setup()
+	defer teardown()
+
+	client := gruff.Client{Key: "gruff-web", AllowedOrigins: []string{"https://www.gruff.org"}}
+	err := client.Create(CTX)
+	assert.NoError(t, err)
+	defer client.Delete(CTX)
+
+	e := echo.New()
+	e.Use(DBMiddleware(TESTDB))
+	e.Use(InitializePayload)
+	e.Use(CORS)
+	e.GET("/api/claims", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/claims", nil)
+		req.Header.Set(echo.HeaderOrigin, origin)
+		req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodGet)
+		req.Header.Set(echo.HeaderAccessControlRequestHeaders, HeaderClient)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	res := preflight("https://www.gruff.org")
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Equal(t, "https://www.gruff.org", res.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	assert.Equal(t, HeaderClient, res.Header().Get(echo.HeaderAccessControlAllowHeaders))
+
+	res = preflight("https://www.evil.com")
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Empty(t, res.Header().Get(echo.HeaderAccessControlAllowOrigin))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/claims", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://www.gruff.org")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://www.gruff.org", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+}
+
+func TestRateLimiter(t *testing.T) {
+	setup()
+	defer teardown()
+
+	gruff.RATE_LIMITS = map[string]gruff.RateLimit{
+		gruff.RATE_LIMIT_READ:  {Capacity: 2, Per: time.Minute},
+		gruff.RATE_LIMIT_WRITE: {Capacity: 1, Per: time.Minute},
+		gruff.RATE_LIMIT_SCORE: {Capacity: 1, Per: time.Minute},
+	}
+	gruff.RATE_LIMIT_STORE = gruff.NewMemoryRateLimitStore()
+	defer func() {
+		gruff.RATE_LIMITS = map[string]gruff.RateLimit{}
+		gruff.RATE_LIMIT_STORE = gruff.NewMemoryRateLimitStore()
+	}()
+
+	e := echo.New()
+	e.Use(DBMiddleware(TESTDB))
+	e.Use(InitializePayload)
+	api := e.Group("/api")
+	api.Use(Session)
+	api.Use(RateLimiter)
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	api.GET("/claims", ok)
+	api.POST("/claims", ok)
+	api.POST("/claims/:id/score", ok)
+
+	request := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "203.0.113.7:4321"
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Each kind of request has its own budget
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/claims", nil).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/claims", nil).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/claims", nil).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/claims/abc/score", nil).Code)
+
+	res := request(http.MethodGet, "/api/claims", nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "30", res.Header().Get("Retry-After"))
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "Too many requests. Please try again later."}`, gruff.ERROR_SUBCODE_RATE_LIMITED), res.Body.String())
+	assert.Equal(t, http.StatusTooManyRequests, request(http.MethodPost, "/api/claims", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(http.MethodPost, "/api/claims/abc/score", nil).Code)
+
+	// Claiming to be somewhere else doesn't get anyone a new budget
+	spoofed := map[string]string{echo.HeaderXForwardedFor: "198.51.100.1", echo.HeaderXRealIP: "198.51.100.1"}
+	assert.Equal(t, http.StatusTooManyRequests, request(http.MethodGet, "/api/claims", spoofed).Code)
+
+	// Signed in users have their own budgets, wherever they are
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/claims", tokenForTestUser(DEFAULT_USER)).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/claims", tokenForTestUser(DEFAULT_USER)).Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(http.MethodPost, "/api/claims", tokenForTestUser(DEFAULT_USER)).Code)
+}
@@ -14,17 +14,25 @@ func ListClaims(which string) func(c echo.Context) error {
 
 		var claim gruff.Claim
 		var claims []gruff.Claim
-		var bindVars gruff.BindVars
 		var query string
 
+		queryAt, qerr := GetQueryDateFromRequest(c)
+		if qerr != nil {
+			return AddError(ctx, c, qerr)
+		}
+
 		params := claim.DefaultQueryParameters()
 		params = params.Merge(GetListParametersFromRequest(c))
+		params.QueryAt = queryAt
+		bindVars := params.BindQueryDate(gruff.BindVars{})
 
 		switch which {
 		case "top":
 			query = claim.QueryForTopLevelClaims(params)
 		case "new":
-			query = gruff.DefaultListQuery(&claim, claim.DefaultQueryParameters())
+			query = gruff.DefaultListQuery(&claim, params)
+		case "controversial":
+			query = claim.QueryForControversialClaims(params)
 		default:
 			return AddError(ctx, c, gruff.NewNotFoundError(fmt.Sprintf("Not found")))
 		}
@@ -177,14 +185,19 @@ func RemovePremise(c echo.Context) error {
 func ListParentArguments(c echo.Context) error {
 	ctx := ServerContext(c)
 
-	// TODO: Handle timestamp query
 	id := c.Param("id")
 	if id == "" {
 		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
 	}
 
+	queryAt, err := GetQueryDateFromRequest(c)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
 	claim := gruff.Claim{}
 	claim.ID = id
+	claim.QueryAt = queryAt
 	if err := claim.Load(ctx); err != nil {
 		return AddError(ctx, c, err)
 	}
@@ -196,15 +209,38 @@ func ListParentArguments(c echo.Context) error {
 
 	// Need to return with parent targets loaded
 	for i, a := range parents {
+		a.QueryAt = queryAt
 		if err := a.LoadTarget(ctx); err != nil {
 			return AddError(ctx, c, err)
 		}
+		a.QueryAt = nil
 		parents[i] = a
 	}
 
 	return c.JSON(http.StatusOK, parents)
 }
 
+func GetMyView(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !ctx.UserLoggedIn() {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	claim := gruff.Claim{}
+	claim.ID = id
+	if err := claim.LoadView(ctx, ctx.UserContext); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, claim)
+}
+
 /*
 func SetScore(c echo.Context) error {
 	ctx := ServerContext(c)
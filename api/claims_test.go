@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/GruffDebate/server/gruff"
 	"github.com/stretchr/testify/assert"
@@ -108,6 +109,241 @@ func TestGetClaim(t *testing.T) {
 	assert.JSONEq(t, string(expected), res.Body.String())
 }
 
+func TestGetClaimAt(t *testing.T) {
+	setup()
+	defer teardown()
+
+	claim := gruff.Claim{
+		Title:       "This is the API Get Claim At test claim",
+		Description: "What did it look like yesterday?",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	before := time.Now()
+
+	err = DEFAULT_USER.Score(CTX, &claim, 0.10)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s", claim.ID))
+	r.SetQuery(H{"at": before.Format(time.RFC3339Nano)})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	result := gruff.Claim{}
+	json.Unmarshal(res.Body.Bytes(), &result)
+	assert.Equal(t, claim.ArangoKey(), result.ArangoKey())
+	assert.Equal(t, gruff.DEFAULT_CLAIM_SCORE, result.Truth)
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	result = gruff.Claim{}
+	json.Unmarshal(res.Body.Bytes(), &result)
+	assert.Equal(t, float32(0.10), result.Truth)
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s", claim.ID))
+	r.SetQuery(H{"at": "yesterday"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestListClaimVersions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	claim := gruff.Claim{
+		Title:       "This is the API List Claim Versions test claim",
+		Description: "It will be edited",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = claim.Update(CTX, gruff.Updates{"desc": "It has been edited"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	expected, _ := claim.VersionHistory(CTX)
+	expectedJSON, _ := json.Marshal(expected)
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/versions", claim.ID))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, string(expectedJSON), res.Body.String())
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET("/api/claims/not-a-real-id/versions")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestDiffClaimVersions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	claim := gruff.Claim{
+		Title:       "This is the API Diff Claim Versions test claim",
+		Description: "It will be edited",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	fromKey := claim.ArangoKey()
+
+	err = claim.Update(CTX, gruff.Updates{"desc": "It has been edited"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	toKey := claim.ArangoKey()
+
+	expected, _ := claim.DiffVersions(CTX, fromKey, toKey)
+	expectedJSON, _ := json.Marshal(expected)
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/diff?from=%s&to=%s", claim.ID, fromKey, toKey))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, string(expectedJSON), res.Body.String())
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/diff?from=%s", claim.ID, fromKey))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/not-a-real-id/diff?from=%s&to=%s", fromKey, toKey))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestClaimScoreHistory(t *testing.T) {
+	setup()
+	defer teardown()
+
+	claim := gruff.Claim{Title: "This is the API Claim Score History test claim"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = DEFAULT_USER.Score(CTX, &claim, 0.75)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/score-history?bucket=day", claim.ID))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	history := []gruff.ScorePoint{}
+	jerr := json.Unmarshal(res.Body.Bytes(), &history)
+	assert.NoError(t, jerr)
+	assert.NotEmpty(t, history)
+	assert.InDelta(t, 0.75, history[len(history)-1].Score, 0.0001)
+	assert.Equal(t, 1, history[len(history)-1].Votes)
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/score-history", claim.ID))
+	r.SetQuery(H{"bucket": "hour", "from": claim.CreatedAt.Add(-time.Hour).Format(time.RFC3339), "to": time.Now().Add(time.Hour).Format(time.RFC3339)})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	history = []gruff.ScorePoint{}
+	jerr = json.Unmarshal(res.Body.Bytes(), &history)
+	assert.NoError(t, jerr)
+	assert.NotEmpty(t, history)
+	assert.Equal(t, 1, history[len(history)-1].Votes)
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/score-history", claim.ID))
+	r.SetQuery(H{"from": "yesterday"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/score-history?bucket=fortnight", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.GET("/api/claims/not-a-real-id/score-history")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestGetMyView(t *testing.T) {
+	setup()
+	defer teardown()
+
+	claim := gruff.Claim{Title: "This is the API My View test claim"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = DEFAULT_USER.Score(CTX, &claim, 0.25)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.GET(fmt.Sprintf("/api/claims/%s/my-view", claim.ID))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	view := gruff.Claim{}
+	jerr := json.Unmarshal(res.Body.Bytes(), &view)
+	assert.NoError(t, jerr)
+	assert.Equal(t, claim.ID, view.ID)
+	assert.NotNil(t, view.View)
+	assert.InDelta(t, 0.25, *view.View.Score, 0.0001)
+	assert.InDelta(t, 0.25, view.View.RollUp, 0.0001)
+
+	r = New(nil)
+	r.GET(fmt.Sprintf("/api/claims/%s/my-view", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestRevertClaim(t *testing.T) {
+	setup()
+	defer teardown()
+
+	claim := gruff.Claim{
+		Title:       "This is the API Revert Claim test claim",
+		Description: "It will be vandalized",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	originalKey := claim.ArangoKey()
+
+	err = claim.Update(CTX, gruff.Updates{"desc": "Vandalized!"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r := New(tokenForTestUser(DEFAULT_USER))
+	r.POST(fmt.Sprintf("/api/claims/%s/revert/%s", claim.ID, originalKey))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	reverted := gruff.Claim{}
+	reverted.ID = claim.ID
+	err = reverted.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, "It will be vandalized", reverted.Description)
+	assert.NotEqual(t, originalKey, reverted.ArangoKey())
+	assert.NotEqual(t, claim.ArangoKey(), reverted.ArangoKey())
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.POST(fmt.Sprintf("/api/claims/%s/revert/%s", claim.ID, "not-a-real-key"))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
+
 func TestConvertClaimToMultiPremise(t *testing.T) {
 	setup()
 	defer teardown()
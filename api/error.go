@@ -28,6 +28,10 @@ func AddNotFoundError(c echo.Context, payload map[string]interface{}, code int,
 	return c.JSON(http.StatusNotFound, map[string]interface{}{"code": code, "message": message})
 }
 
+func AddTooManyRequestsError(c echo.Context, payload map[string]interface{}, code int, message string) error {
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{"code": code, "message": message})
+}
+
 func AddServerError(c echo.Context, payload map[string]interface{}, code int, message string) error {
 	return c.JSON(http.StatusInternalServerError, map[string]interface{}{"code": code, "message": message})
 }
@@ -55,6 +59,8 @@ func AddError(ctx *gruff.ServerContext, c echo.Context, errGruff gruff.Error) er
 		err = AddPermissionError(c, ctx.Payload, code, errGruff.Error())
 	case 404:
 		err = AddNotFoundError(c, ctx.Payload, code, errGruff.Error())
+	case 429:
+		err = AddTooManyRequestsError(c, ctx.Payload, code, errGruff.Error())
 	default:
 		err = AddServerError(c, ctx.Payload, code, errGruff.Error())
 	}
@@ -5,23 +5,29 @@ import (
 	"net/http"
 	"reflect"
 	"strconv"
+	"time"
 
 	"github.com/GruffDebate/server/gruff"
 	"github.com/labstack/echo"
 )
 
-// TODO: Handle query date
 func List(c echo.Context) error {
 	// TODO: check authorized
 	ctx := ServerContext(c)
 
 	item := reflect.New(ctx.Type).Interface().(gruff.ArangoObject)
 
+	queryAt, err := GetQueryDateFromRequest(c)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
 	params := item.DefaultQueryParameters()
 	params = params.Merge(GetListParametersFromRequest(c))
+	params.QueryAt = queryAt
 
 	userID := ActiveUserID(c, ctx)
-	filters := gruff.BindVars{}
+	filters := params.BindQueryDate(gruff.BindVars{})
 	var query string
 	if userID != "" && gruff.IsVersionedModel(ctx.Type) {
 		filters["creator"] = userID
@@ -140,7 +146,6 @@ func Delete(c echo.Context) error {
 	return c.JSON(http.StatusOK, item)
 }
 
-// TODO: Handle query date
 func Get(c echo.Context) error {
 	ctx := ServerContext(c)
 
@@ -149,7 +154,12 @@ func Get(c echo.Context) error {
 		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
 	}
 
-	result, err := loadItem(c, id)
+	queryAt, err := GetQueryDateFromRequest(c)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	result, err := loadItemAt(c, id, queryAt)
 	if err != nil {
 		return AddError(ctx, c, err)
 	}
@@ -169,7 +179,139 @@ func Get(c echo.Context) error {
 	return c.JSON(http.StatusOK, result)
 }
 
+func RevertVersion(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !gruff.IsReverter(reflect.PtrTo(ctx.Type)) {
+		return AddError(ctx, c, gruff.NewServerError("This item isn't compatible with this request"))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	item, err := loadItem(c, id)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := item.(gruff.Reverter).Revert(ctx, c.Param("key")); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if gruff.IsLoader(reflect.PtrTo(ctx.Type)) {
+		loader := item.(gruff.Loader)
+		if err := loader.LoadFull(ctx); err != nil {
+			return AddError(ctx, c, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, item)
+}
+
+func ListVersions(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !gruff.IsVersionHistorian(reflect.PtrTo(ctx.Type)) {
+		return AddError(ctx, c, gruff.NewServerError("This item isn't compatible with this request"))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	item := reflect.New(ctx.Type).Interface()
+	gruff.SetID(item, id)
+
+	versions, err := item.(gruff.VersionHistorian).VersionHistory(ctx)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+	if len(versions) == 0 {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	return c.JSON(http.StatusOK, versions)
+}
+
+func DiffVersions(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !gruff.IsVersionDiffer(reflect.PtrTo(ctx.Type)) {
+		return AddError(ctx, c, gruff.NewServerError("This item isn't compatible with this request"))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	from := c.QueryParam("from")
+	if from == "" {
+		return AddError(ctx, c, gruff.NewBusinessError("From: non zero value required;"))
+	}
+	to := c.QueryParam("to")
+	if to == "" {
+		return AddError(ctx, c, gruff.NewBusinessError("To: non zero value required;"))
+	}
+
+	item := reflect.New(ctx.Type).Interface()
+	gruff.SetID(item, id)
+
+	diff, err := item.(gruff.VersionDiffer).DiffVersions(ctx, from, to)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, diff)
+}
+
+func ScoreHistory(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !gruff.IsScoreHistorian(reflect.PtrTo(ctx.Type)) {
+		return AddError(ctx, c, gruff.NewServerError("This item isn't compatible with this request"))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		bucket = gruff.SCORE_BUCKET_DAY
+	}
+
+	from, err := getTimeQueryParam(c, "from", "From")
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+	to, err := getTimeQueryParam(c, "to", "To")
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	item := reflect.New(ctx.Type).Interface()
+	gruff.SetID(item, id)
+
+	history, err := item.(gruff.ScoreHistorian).ScoreHistory(ctx, bucket, from, to)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
 func loadItem(c echo.Context, id string) (interface{}, gruff.Error) {
+	return loadItemAt(c, id, nil)
+}
+
+// Loads the version of the item that was active at queryAt,
+// or the current version if queryAt is nil
+func loadItemAt(c echo.Context, id string, queryAt *time.Time) (interface{}, gruff.Error) {
 	ctx := ServerContext(c)
 	var result interface{}
 	if gruff.IsLoader(reflect.PtrTo(ctx.Type)) {
@@ -178,6 +320,7 @@ func loadItem(c echo.Context, id string) (interface{}, gruff.Error) {
 
 		if gruff.IsVersionedModel(ctx.Type) {
 			gruff.SetID(loader, id)
+			gruff.SetQueryAt(loader, queryAt)
 		}
 
 		err := loader.LoadFull(ctx)
@@ -216,7 +359,26 @@ func validateKeyParameter(c echo.Context, item gruff.ArangoObject, paramses ...m
 	return nil
 }
 
-// TODO: GetQueryDateFromRequest
+// Reads the "at" query parameter, which must be an RFC3339 timestamp
+// Returns nil if the request should be made against the current data
+func GetQueryDateFromRequest(c echo.Context) (*time.Time, gruff.Error) {
+	return getTimeQueryParam(c, "at", "At")
+}
+
+// Reads a query parameter that must be an RFC3339 timestamp, returning nil if it wasn't given
+func getTimeQueryParam(c echo.Context, name, label string) (*time.Time, gruff.Error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, gruff.NewBusinessError(fmt.Sprintf("%s: must be a valid RFC3339 timestamp;", label))
+	}
+	return &t, nil
+}
+
 func GetListParametersFromRequest(c echo.Context) gruff.ArangoQueryParameters {
 	params := gruff.ArangoQueryParameters{}
 
@@ -87,7 +87,7 @@ func (mc TestMiddlewareConfigurer) ConfigureDefaultApiMiddleware(root *echo.Echo
 func (mc TestMiddlewareConfigurer) ConfigurePublicApiMiddleware(root *echo.Echo) *echo.Group {
 	api := mc.ConfigureDefaultApiMiddleware(root)
 	public := api.Group("/api")
-	public.Use(SettingHeaders(true))
+	public.Use(RegisteredClient(false))
 	public.Use(Session)
 
 	return public
@@ -97,7 +97,7 @@ func (mc TestMiddlewareConfigurer) ConfigurePrivateApiMiddleware(root *echo.Echo
 	api := mc.ConfigureDefaultApiMiddleware(root)
 	private := api.Group("/api")
 	private.Use(middleware.Gzip())
-	private.Use(SettingHeaders(true))
+	private.Use(RegisteredClient(false))
 	// private.Use(SetUpTestUser(ROLE))
 	// private.Use(SetTestUserToken)
 	private.Use(Session)
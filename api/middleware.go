@@ -3,10 +3,14 @@ package api
 import (
 	"context"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GruffDebate/server/gruff"
 	"github.com/GruffDebate/server/support"
@@ -19,8 +23,14 @@ import (
 var ARANGODB_CLIENT arango.Client
 var ARANGODB_POOL arango.Database
 
+// The networks of the load balancers and proxies in front of the server, whose
+// X-Forwarded-For headers can be believed
+var TRUSTED_PROXIES []*net.IPNet
+
 const (
 	HeaderReferrerPolicy = "Referrer-Policy"
+	HeaderApiKey         = "X-Gruff-Api-Key"
+	HeaderClient         = "X-Gruff-Client"
 )
 
 type securityMiddlewareOption func(*echo.Response)
@@ -67,18 +77,185 @@ func InitializePayload(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func SettingHeaders(test bool) echo.MiddlewareFunc {
+// Routes that browsers are sent to directly, rather than called by a client,
+// so they can't send the X-Gruff-Client header
+var CLIENTLESS_ROUTES map[string]bool = map[string]bool{
+	"/api/auth/oidc/:provider":          true,
+	"/api/auth/oidc/:provider/callback": true,
+	"/api/users/verify":                 true,
+}
+
+// Identifies the registered client making the request, from its ID in the X-Gruff-Client header,
+// and checks that it is being used from one of its origins and within its rate limit.
+// Unless the client is required, requests that don't name one are let through,
+// as are requests for the CLIENTLESS_ROUTES. So are all requests until the first client
+// has been registered, so that a new deployment can register it through the API.
+func RegisteredClient(required bool) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			if !test {
-				xGruff := c.Request().Header.Get("X-Gruff")
-				if xGruff != "Gruff" {
+			// The default middleware is configured for both the public and private routes
+			if c.Get("Client") != nil {
+				return next(c)
+			}
+
+			ctx := ServerContext(c)
+			id := c.Request().Header.Get(HeaderClient)
+			if id == "" {
+				if required && !CLIENTLESS_ROUTES[c.Path()] {
+					registered, err := gruff.HasClients(ctx)
+					if err != nil {
+						return AddError(ctx, c, err)
+					}
+					if registered {
+						return echo.NewHTTPError(http.StatusUnauthorized)
+					}
+				}
+				return next(c)
+			}
+
+			client, err := gruff.LoadClient(ctx, id)
+			if err != nil {
+				if err.Code() == gruff.ERROR_CODE_NOT_FOUND {
 					return echo.NewHTTPError(http.StatusUnauthorized)
 				}
+				return AddError(ctx, c, err)
+			}
+
+			if origin := c.Request().Header.Get(echo.HeaderOrigin); origin != "" && !client.AllowsOrigin(origin) {
+				return echo.NewHTTPError(http.StatusForbidden, "This client may not be used from this origin")
+			}
+
+			limit := gruff.RateLimit{Capacity: client.RateLimit, Per: time.Minute}
+			if limit.Enabled() {
+				wait, err := gruff.RATE_LIMIT_STORE.Take(ctx, "client:"+client.Key, limit)
+				if err != nil {
+					c.Logger().Error(err.Error())
+				} else if wait > 0 {
+					return tooManyRequests(ctx, c, wait, "This app has made too many requests. Please try again later.")
+				}
+			}
+
+			c.Set("Client", client)
+			return next(c)
+		}
+	}
+}
+
+// Limits how many requests each user, or each IP address for those who aren't signed in, can make.
+// Reading, writing and scoring each have their own budget.
+func RateLimiter(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := ServerContext(c)
+
+		kind := gruff.RATE_LIMIT_WRITE
+		if strings.HasSuffix(c.Path(), "/score") {
+			kind = gruff.RATE_LIMIT_SCORE
+		} else if isSafeMethod(c.Request().Method) {
+			kind = gruff.RATE_LIMIT_READ
+		}
+
+		who := "ip:" + ClientIP(c)
+		if ctx.UserLoggedIn() {
+			who = "user:" + ctx.UserContext.ArangoKey()
+		}
+
+		// If the limits can't be checked, it's better to let the request through than to fail it
+		wait, err := gruff.TakeRateLimitToken(ctx, kind, who)
+		if err != nil {
+			c.Logger().Error(err.Error())
+		} else if wait > 0 {
+			return tooManyRequests(ctx, c, wait, "Too many requests. Please try again later.")
+		}
+
+		return next(c)
+	}
+}
+
+// Returns the IP address of the client making the request.
+// Anybody can send an X-Forwarded-For header, so it is only believed as far back as
+// it was added by one of the TRUSTED_PROXIES; otherwise the address the request came from is used.
+func ClientIP(c echo.Context) string {
+	addr := c.Request().RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || !isTrustedProxy(ip) {
+		return addr
+	}
+
+	// Each proxy appends the address it got the request from, so the client is the last one
+	// that wasn't added by a trusted proxy
+	hops := strings.Split(c.Request().Header.Get(echo.HeaderXForwardedFor), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range TRUSTED_PROXIES {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func tooManyRequests(ctx *gruff.ServerContext, c echo.Context, wait time.Duration, msg string) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return AddError(ctx, c, gruff.NewTooManyRequestsError(msg, gruff.ERROR_SUBCODE_RATE_LIMITED))
+}
+
+// Allows browsers to call the API from the origins of the registered clients
+func CORS(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		res := c.Response()
+		origin := req.Header.Get(echo.HeaderOrigin)
+		preflight := req.Method == http.MethodOptions && req.Header.Get(echo.HeaderAccessControlRequestMethod) != ""
+
+		if origin == "" || res.Header().Get(echo.HeaderAccessControlAllowOrigin) != "" {
+			return next(c)
+		}
+		res.Header().Add(echo.HeaderVary, echo.HeaderOrigin)
+
+		ctx := ServerContext(c)
+		allowed, err := gruff.OriginAllowed(ctx, origin)
+		if err != nil {
+			return AddError(ctx, c, err)
+		}
+		if !allowed {
+			if preflight {
+				return c.NoContent(http.StatusNoContent)
 			}
+			return next(c)
+		}
 
+		res.Header().Set(echo.HeaderAccessControlAllowOrigin, origin)
+		if !preflight {
 			return next(c)
 		}
+
+		res.Header().Set(echo.HeaderAccessControlAllowMethods, strings.Join([]string{
+			http.MethodGet,
+			http.MethodHead,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodDelete,
+		}, ","))
+		if headers := req.Header.Get(echo.HeaderAccessControlRequestHeaders); headers != "" {
+			res.Header().Set(echo.HeaderAccessControlAllowHeaders, headers)
+		}
+		res.Header().Set(echo.HeaderAccessControlMaxAge, "600")
+		return c.NoContent(http.StatusNoContent)
 	}
 }
 
@@ -89,7 +266,20 @@ func Session(next echo.HandlerFunc) echo.HandlerFunc {
 
 		authorization := c.Request().Header.Get("Authorization")
 		secretKey := os.Getenv("JWT_KEY_SIGNIN")
-		if authorization != "" {
+		if apiKey := c.Request().Header.Get(HeaderApiKey); apiKey != "" {
+			key, keyUser, err := gruff.UseApiKey(ctx, apiKey)
+			if err != nil {
+				if err.Code() == gruff.ERROR_CODE_UNAUTHORIZED_ERROR {
+					return echo.NewHTTPError(http.StatusUnauthorized)
+				}
+				return AddError(ctx, c, err)
+			}
+			if !key.CanWrite() && !isSafeMethod(c.Request().Method) {
+				return echo.NewHTTPError(http.StatusForbidden, "This API key is read-only")
+			}
+			user = keyUser
+			c.Set("ApiKey", key)
+		} else if authorization != "" {
 			tokenSlice := strings.Split(authorization, " ")
 			if len(tokenSlice) == 2 && tokenSlice[0] == "Bearer" {
 				token, err := gruff.VerifyJWTToken(tokenSlice[1], secretKey)
@@ -99,12 +289,26 @@ func Session(next echo.HandlerFunc) echo.HandlerFunc {
 
 				claims := token.Claims.(jwt.MapClaims)
 				if token.Valid && claims["iss"] == gruff.JWT_ISS {
+					if jti, ok := claims["jti"].(string); ok {
+						revoked, err := gruff.IsJWTokenRevoked(ctx, jti)
+						if err != nil || revoked {
+							return echo.NewHTTPError(http.StatusUnauthorized)
+						}
+					}
+
 					uidParse := claims["user"].(string)
 					// uid := string(uidParse)
 					user.Key = uidParse
 					if err := user.Load(ctx); err != nil {
 						return echo.NewHTTPError(http.StatusUnauthorized)
 					}
+
+					// Tokens issued before the user's tokens were revoked are no longer accepted
+					if user.TokenRevoked(gruff.JWTokenIssuedAt(claims)) || user.Banned() {
+						return echo.NewHTTPError(http.StatusUnauthorized)
+					}
+
+					c.Set("TokenClaims", claims)
 				} else {
 					return echo.NewHTTPError(http.StatusUnauthorized)
 				}
@@ -121,6 +325,11 @@ func Session(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// Safe methods only read, so they're all that read-only API keys may be used for
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
 func DetermineType(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var tType reflect.Type
@@ -1,54 +1,55 @@
 package api
 
 import (
-	_ "net/http"
+	"net/http"
 
-	_ "github.com/GruffDebate/server/gruff"
-	_ "github.com/labstack/echo"
+	"github.com/GruffDebate/server/gruff"
+	"github.com/labstack/echo"
 )
 
-/*
+// Lists the logged in user's notifications, newest first, along with how many are unread.
+// With ?unread=true, only the unread ones are listed.
 func ListNotifications(c echo.Context) error {
 	ctx := ServerContext(c)
-	db := ctx.Database
 
-	userID := ctx.UserContext.ID
+	if !ctx.UserLoggedIn() {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	}
+
+	params := GetListParametersFromRequest(c)
+	unreadOnly := c.QueryParam("unread") == "true"
 
-	notifications := []gruff.Notification{}
-	db = db.Where("user_id = ?", userID)
-	db = db.Where("viewed = false")
-	db = db.Order("created_at DESC")
-	if err := db.Find(&notifications).Error; err != nil {
-		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	notifications, err := ctx.UserContext.Notifications(ctx, params, unreadOnly)
+	if err != nil {
+		return AddError(ctx, c, err)
 	}
 
-	return c.JSON(http.StatusOK, notifications)
+	unread, err := ctx.UserContext.UnreadNotificationCount(ctx)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	ctx.Payload["results"] = notifications
+	ctx.Payload["unread"] = unread
+	return c.JSON(http.StatusOK, ctx.Payload)
 }
 
 func MarkNotificationViewed(c echo.Context) error {
 	ctx := ServerContext(c)
-	db := ctx.Database
 
-	userID := ctx.UserContext.ID
-	notificationID := c.Param("id")
-	if notificationID == "" {
-		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
-	}
-
-	notification := gruff.Notification{}
-	if err := db.First(&notification, notificationID).Error; err != nil {
-		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	if !ctx.UserLoggedIn() {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
 	}
 
-	if notification.UserID != userID {
-		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
 	}
 
-	notification.Viewed = true
-	if err := db.Save(&notification).Error; err != nil {
-		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	notification, err := ctx.UserContext.MarkNotificationViewed(ctx, id)
+	if err != nil {
+		return AddError(ctx, c, err)
 	}
 
 	return c.JSON(http.StatusOK, notification)
 }
-*/
@@ -1,85 +1,96 @@
 package api
 
 import (
-	_ "encoding/json"
-	_ "fmt"
-	_ "net/http"
-	_ "testing"
-
-	_ "github.com/GruffDebate/server/gruff"
-	_ "github.com/GruffDebate/server/support"
-	_ "github.com/stretchr/testify/assert"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/GruffDebate/server/gruff"
+	"github.com/GruffDebate/server/support"
+	"github.com/stretchr/testify/assert"
 )
 
-/*
 func TestListNotifications(t *testing.T) {
 	setup()
 	defer teardown()
 
-	u1 := gruff.User{Name: "User1", Username: "user1", Email: "email1@gruff.org"}
-	u2 := gruff.User{Name: "User2", Username: "user2", Email: "email2@gruff.org"}
-	TESTDB.Create(&u1)
-	TESTDB.Create(&u2)
-
-	r := New(tokenForTestUser(u1))
-
-	n1 := gruff.Notification{UserID: u1.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM)}
-	n2 := gruff.Notification{UserID: u2.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM)}
-	n3 := gruff.Notification{UserID: u2.ID, Type: gruff.NOTIFICATION_TYPE_NEW_ARGUMENT, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM), NewID: gruff.NewNUUID(), NewType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
-	n4 := gruff.Notification{UserID: u1.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM), Viewed: true}
-	n5 := gruff.Notification{UserID: u1.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
-	n6 := gruff.Notification{UserID: u1.ID, Type: gruff.NOTIFICATION_TYPE_NEW_ARGUMENT, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), NewID: gruff.NewNUUID(), NewType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
-	n7 := gruff.Notification{UserID: u2.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
-	TESTDB.Create(&n1)
-	TESTDB.Create(&n2)
-	TESTDB.Create(&n3)
-	TESTDB.Create(&n4)
-	TESTDB.Create(&n5)
-	TESTDB.Create(&n6)
-	TESTDB.Create(&n7)
-
-	expectedResults, _ := json.Marshal([]gruff.Notification{n6, n5, n1})
+	u1 := createUser("User1", "user1", "email1@gruff.org")
+	u2 := createUser("User2", "user2", "email2@gruff.org")
 
+	r := New(nil)
 	r.GET("/api/notifications")
 	res, _ := r.Run(Router())
-	assert.Equal(t, string(expectedResults), res.Body.String())
-	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	n1 := gruff.Notification{UserID: u1.ArangoID(), Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: support.StringPtr("arg1"), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: support.StringPtr("claim1"), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM)}
+	n2 := gruff.Notification{UserID: u2.ArangoID(), Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: support.StringPtr("arg2"), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: support.StringPtr("claim2"), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM)}
+	n3 := gruff.Notification{UserID: u1.ArangoID(), Type: gruff.NOTIFICATION_TYPE_NEW_ARGUMENT, ItemID: support.StringPtr("claim3"), ItemType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM), NewID: support.StringPtr("arg3"), NewType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
+	for _, n := range []*gruff.Notification{&n1, &n2, &n3} {
+		err := n.Create(CTX)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+	}
+	_, err := u1.MarkNotificationViewed(CTX, n1.ArangoKey())
+	assert.NoError(t, err)
+
+	list := func(query string) ([]gruff.Notification, int) {
+		r := New(tokenForTestUser(u1))
+		r.GET(fmt.Sprintf("/api/notifications%s", query))
+		res, _ := r.Run(Router())
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		payload := struct {
+			Results []gruff.Notification `json:"results"`
+			Unread  int                  `json:"unread"`
+		}{}
+		jerr := json.Unmarshal(res.Body.Bytes(), &payload)
+		assert.NoError(t, jerr)
+		return payload.Results, payload.Unread
+	}
+
+	notifications, unread := list("")
+	assert.Equal(t, 1, unread)
+	assert.Len(t, notifications, 2)
+	assert.Equal(t, n3.ArangoKey(), notifications[0].ArangoKey())
+	assert.Equal(t, n1.ArangoKey(), notifications[1].ArangoKey())
+	assert.True(t, notifications[1].Viewed)
+
+	notifications, unread = list("?unread=true")
+	assert.Equal(t, 1, unread)
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, n3.ArangoKey(), notifications[0].ArangoKey())
 }
 
 func TestMarkNotificationViewed(t *testing.T) {
 	setup()
 	defer teardown()
 
-	u1 := gruff.User{Name: "User1", Username: "user1", Email: "email1@gruff.org"}
-	u2 := gruff.User{Name: "User2", Username: "user2", Email: "email2@gruff.org"}
-	TESTDB.Create(&u1)
-	TESTDB.Create(&u2)
-
-	r := New(tokenForTestUser(u1))
-
-	n1 := gruff.Notification{UserID: u1.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM)}
-	n2 := gruff.Notification{UserID: u2.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM)}
-	n3 := gruff.Notification{UserID: u2.ID, Type: gruff.NOTIFICATION_TYPE_NEW_ARGUMENT, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM), NewID: gruff.NewNUUID(), NewType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
-	n4 := gruff.Notification{UserID: u1.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_CLAIM), Viewed: true}
-	n5 := gruff.Notification{UserID: u1.ID, Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: gruff.NewNUUID(), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT), OldID: gruff.NewNUUID(), OldType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
-	TESTDB.Create(&n1)
-	TESTDB.Create(&n2)
-	TESTDB.Create(&n3)
-	TESTDB.Create(&n4)
-	TESTDB.Create(&n5)
+	u1 := createUser("User1", "user1", "email1@gruff.org")
+	u2 := createUser("User2", "user2", "email2@gruff.org")
 
-	n5.Viewed = true
-	expectedResults, _ := json.Marshal(n5)
+	n := gruff.Notification{UserID: u1.ArangoID(), Type: gruff.NOTIFICATION_TYPE_MOVED, ItemID: support.StringPtr("arg1"), ItemType: support.IntPtr(gruff.OBJECT_TYPE_ARGUMENT)}
+	err := n.Create(CTX)
+	assert.NoError(t, err)
 
-	m := map[string]interface{}{}
-
-	r.POST(fmt.Sprintf("/api/notifications/%d", n5.ID))
-	r.SetBody(m)
+	// Other users' notifications can't be found
+	r := New(tokenForTestUser(u2))
+	r.POST(fmt.Sprintf("/api/notifications/%s", n.ArangoKey()))
 	res, _ := r.Run(Router())
-	assert.Equal(t, string(expectedResults), res.Body.String())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	r = New(tokenForTestUser(u1))
+	r.POST(fmt.Sprintf("/api/notifications/%s", n.ArangoKey()))
+	res, _ = r.Run(Router())
 	assert.Equal(t, http.StatusOK, res.Code)
 
-	TESTDB.First(&n5)
-	assert.True(t, n5.Viewed)
+	viewed := gruff.Notification{}
+	jerr := json.Unmarshal(res.Body.Bytes(), &viewed)
+	assert.NoError(t, jerr)
+	assert.Equal(t, n.ArangoKey(), viewed.ArangoKey())
+	assert.True(t, viewed.Viewed)
+
+	count, err := u1.UnreadNotificationCount(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
 }
-*/
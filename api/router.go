@@ -1,14 +1,10 @@
 package api
 
 import (
-	"os"
-
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
 
-var cors []string
-
 type MiddlewareConfigurer interface {
 	ConfigureDefaultApiMiddleware(*echo.Echo) *echo.Echo
 	ConfigurePublicApiMiddleware(*echo.Echo) *echo.Group
@@ -20,19 +16,22 @@ func SetUpRouter(mc MiddlewareConfigurer) *echo.Echo {
 
 	root.GET("/", Home)
 
-	if os.Getenv("GRUFF_ENV") == "production" {
-		cors = []string{"*"}
-	} else {
-		cors = []string{"*"}
-	}
-
 	//
 	// PUBLIC ENDPOINTS
 	//
 	public := mc.ConfigurePublicApiMiddleware(root)
 
 	public.POST("/auth", SignIn)
+	public.POST("/auth/refresh", RefreshToken)
+	public.POST("/auth/logout", SignOut)
+	public.GET("/auth/oidc", ListIdentityProviders)
+	public.GET("/auth/oidc/:provider", StartOIDCLogin)
+	public.GET("/auth/oidc/:provider/callback", FinishOIDCLogin)
+	public.POST("/auth/oidc/token", ExchangeLoginCode)
 	public.POST("/users", SignUp)
+	public.GET("/users/verify", VerifyEmail)
+	public.POST("/users/password-reset", RequestPasswordReset)
+	public.POST("/users/password-reset/confirm", ConfirmPasswordReset)
 
 	//
 	// PRIVATE ENDPOINTS
@@ -43,9 +42,13 @@ func SetUpRouter(mc MiddlewareConfigurer) *echo.Echo {
 	private.GET("/users/:id", Get)
 	private.GET("/users/me", GetMe)
 	private.PUT("/users/me", UpdateMe)
+	private.POST("/users/verify", ResendVerificationEmail)
+	private.GET("/users/me/api-keys", ListApiKeys)
+	private.POST("/users/me/api-keys", CreateApiKey)
+	private.DELETE("/users/me/api-keys/:id", RevokeApiKey)
 	private.PUT("/users/:id", Update)
 	private.PUT("/users/password", ChangePassword)
-	public.PUT("/users/changePassword", ChangePassword)
+	private.PUT("/users/changePassword", ChangePassword)
 	private.DELETE("/users/:id", Delete)
 
 	private.GET("/users/claims", List)
@@ -54,12 +57,22 @@ func SetUpRouter(mc MiddlewareConfigurer) *echo.Echo {
 	private.PUT("/claims/:id/score", SetScore)
 	private.POST("/arguments/:id/score", SetScore)
 	private.PUT("/arguments/:id/score", SetScore)
+	private.DELETE("/claims/:id/score", RetractScore)
+	private.DELETE("/arguments/:id/score", RetractScore)
+	private.POST("/claims/:id/follow", Follow)
+	private.DELETE("/claims/:id/follow", Unfollow)
+	private.POST("/arguments/:id/follow", Follow)
+	private.DELETE("/arguments/:id/follow", Unfollow)
 
 	public.GET("/arguments/:id", Get)
+	public.GET("/arguments/:id/versions", ListVersions)
+	public.GET("/arguments/:id/diff", DiffVersions)
+	public.GET("/arguments/:id/score-history", ScoreHistory)
 	private.POST("/arguments", Create)
 	private.PUT("/arguments/:id", Update)
 	private.DELETE("/arguments/:id", Delete)
 	private.PUT("/arguments/:id/move/:type/:targetId", MoveArgument)
+	private.POST("/arguments/:id/revert/:key", RevertVersion)
 
 	// TODO: Test all these
 	public.GET("/contexts", List)
@@ -83,17 +96,36 @@ func SetUpRouter(mc MiddlewareConfigurer) *echo.Echo {
 
 	public.GET("/claims", ListClaims("new"))
 	public.GET("/claims/top", ListClaims("top"))
+	public.GET("/claims/controversial", ListClaims("controversial"))
 	public.GET("/claims/:id", Get)
 	public.GET("/claims/:id/parents", ListParentArguments)
+	public.GET("/claims/:id/versions", ListVersions)
+	public.GET("/claims/:id/diff", DiffVersions)
+	public.GET("/claims/:id/score-history", ScoreHistory)
+	private.GET("/claims/:id/my-view", GetMyView)
 	private.POST("/claims", Create)
 	private.PUT("/claims/:id", Update)
 	private.DELETE("/claims/:id", Delete)
 	private.PUT("/claims/:id/convert", ConvertClaimToMultiPremise)
+	private.POST("/claims/:id/revert/:key", RevertVersion)
 	private.POST("/claims/:parentId/premises/:id", AddPremise)
 	private.DELETE("/claims/:parentId/premises/:id", RemovePremise)
 	//private.POST("/claims/:id/truth", SetScore)
 	//private.PUT("/claims/:id/truth", SetScore)
 
+	private.GET("/admin/score-queue", GetScoreQueue)
+	private.POST("/admin/users/:id/revoke-tokens", RevokeUserTokens)
+	private.POST("/admin/users/:id/ban", BanUser)
+	private.DELETE("/admin/users/:id/ban", UnbanUser)
+	private.POST("/admin/users/:id/roles/:role", GrantRole)
+	private.DELETE("/admin/users/:id/roles/:role", RevokeRole)
+	private.GET("/admin/roles", ListRoles)
+	private.PUT("/admin/roles/:name", SaveRole)
+	private.DELETE("/admin/roles/:name", DeleteRole)
+	private.GET("/admin/clients", ListClients)
+	private.PUT("/admin/clients/:id", SaveClient)
+	private.DELETE("/admin/clients/:id", DeleteClient)
+
 	public.GET("/links", List)
 	public.GET("/links/:id", Get)
 	private.POST("/links", Create)
@@ -102,9 +134,9 @@ func SetUpRouter(mc MiddlewareConfigurer) *echo.Echo {
 
 	//public.GET("/tags/:id/claims", ListClaimsByTag)
 
-	//private.GET("/notifications", ListNotifications)
-	//private.POST("/notifications/:id", MarkNotificationViewed)
-	//private.PUT("/notifications/:id", MarkNotificationViewed)
+	private.GET("/notifications", ListNotifications)
+	private.POST("/notifications/:id", MarkNotificationViewed)
+	private.PUT("/notifications/:id", MarkNotificationViewed)
 
 	return root
 }
@@ -114,7 +146,6 @@ type ProductionMiddlewareConfigurer struct{}
 func (mc ProductionMiddlewareConfigurer) ConfigureDefaultApiMiddleware(root *echo.Echo) *echo.Echo {
 	root.Use(middleware.Logger())
 	root.Use(middleware.Recover())
-	root.Use(middleware.CORS())
 	root.Use(middleware.Secure())
 	root.Use(middleware.SecureWithConfig(middleware.SecureConfig{
 		XSSProtection:         "1; mode=block",
@@ -129,7 +160,8 @@ func (mc ProductionMiddlewareConfigurer) ConfigureDefaultApiMiddleware(root *ech
 	root.Use(DBMiddleware(ARANGODB_POOL))
 	root.Use(DetermineType)
 	root.Use(InitializePayload)
-	root.Use(SettingHeaders(false))
+	root.Use(CORS)
+	root.Use(RegisteredClient(true))
 
 	return root
 }
@@ -138,6 +170,7 @@ func (mc ProductionMiddlewareConfigurer) ConfigurePublicApiMiddleware(root *echo
 	api := mc.ConfigureDefaultApiMiddleware(root)
 	public := api.Group("/api")
 	public.Use(Session)
+	public.Use(RateLimiter)
 
 	return public
 }
@@ -146,6 +179,7 @@ func (mc ProductionMiddlewareConfigurer) ConfigurePrivateApiMiddleware(root *ech
 	api := mc.ConfigureDefaultApiMiddleware(root)
 	private := api.Group("/api")
 	private.Use(Session)
+	private.Use(RateLimiter)
 
 	return private
 }
@@ -2,12 +2,17 @@ package api
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/GruffDebate/server/gruff"
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func SignUp(c echo.Context) error {
@@ -23,13 +28,17 @@ func SignUp(c echo.Context) error {
 		return AddError(ctx, c, err)
 	}
 
-	t, err := TokenForUser(u)
+	// The account is usable either way, and the user can ask for another email later
+	if err := u.SendVerificationEmail(ctx); err != nil {
+		c.Logger().Error(err.Error())
+	}
+
+	tokens, err := tokensForUser(ctx, u)
 	if err != nil {
-		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+		return AddError(ctx, c, err)
 	}
-	user := map[string]interface{}{"user": u, "token": t}
 
-	return c.JSON(http.StatusCreated, user)
+	return c.JSON(http.StatusCreated, tokens)
 }
 
 func SignIn(c echo.Context) error {
@@ -40,46 +49,234 @@ func SignIn(c echo.Context) error {
 		return AddError(ctx, c, gruff.NewServerError(err.Error()))
 	}
 
+	ip := ClientIP(c)
+	wait, err := gruff.LoginLockedFor(ctx, u.Email, ip)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+	if wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+		return AddError(ctx, c, gruff.NewTooManyRequestsError("Too many failed attempts to sign in. Please try again later.", gruff.ERROR_SUBCODE_LOGIN_THROTTLED))
+	}
+
 	user := gruff.User{
 		Email: u.Email,
 	}
 
-	fmt.Println("-------------------------------Using email:", u.Email)
+	// Unknown emails and wrong passwords look the same, so that accounts can't be discovered by signing in
+	verified := false
+	if u.Email != "" && u.Password != "" {
+		if err := user.Load(ctx); err == nil {
+			verified, _ = user.VerifyPassword(ctx, u.Password)
+		} else if err.Code() == gruff.ERROR_CODE_NOT_FOUND {
+			gruff.VerifyDummyPassword(u.Password)
+		} else {
+			return AddError(ctx, c, err)
+		}
+	}
 
-	if err := user.Load(ctx); err != nil {
-		fmt.Println("-------------------------------not found")
+	if !verified {
+		if err := gruff.RecordLoginFailure(ctx, u.Email, ip); err != nil {
+			return AddError(ctx, c, err)
+		}
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Invalid email or password", gruff.ERROR_SUBCODE_CREDENTIALS_INVALID))
+	}
+	if err := user.CheckBanned(); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := gruff.RecordLoginSuccess(ctx, u.Email); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	tokens, err := tokensForUser(ctx, user)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func RefreshToken(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	req := refreshTokenRequest{}
+	if err := c.Bind(&req); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+
+	user, refreshToken, err := gruff.UseRefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	t, terr := TokenForUser(user)
+	if terr != nil {
 		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
 	}
 
-	if ok, _ := verifyPassword(user, u.Password); ok || true {
-		t, err := TokenForUser(user)
-		if err != nil {
-			return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	return c.JSON(http.StatusOK, map[string]interface{}{"user": user, "token": t, "refreshToken": refreshToken})
+}
+
+// Revokes the access token used to make the request, along with the given refresh token and its replacements
+func SignOut(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	req := refreshTokenRequest{}
+	if err := c.Bind(&req); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+
+	if claims, ok := c.Get("TokenClaims").(jwt.MapClaims); ok {
+		jti, _ := claims["jti"].(string)
+		exp, _ := claims["exp"].(float64)
+		if err := gruff.RevokeJWToken(ctx, jti, ctx.UserContext.ArangoKey(), time.Unix(int64(exp), 0)); err != nil {
+			return AddError(ctx, c, err)
+		}
+	}
+
+	if req.RefreshToken != "" {
+		if err := gruff.RevokeRefreshToken(ctx, req.RefreshToken); err != nil && err.Code() != gruff.ERROR_CODE_UNAUTHORIZED_ERROR {
+			return AddError(ctx, c, err)
 		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
 
-		u := map[string]interface{}{"user": user, "token": t}
+const OIDC_STATE_COOKIE = "gruff_oidc_state"
 
-		return c.JSON(http.StatusOK, u)
+func ListIdentityProviders(c echo.Context) error {
+	return c.JSON(http.StatusOK, gruff.IdentityProviderNames())
+}
+
+// Starts signing in with an external identity provider. Browsers are sent here directly,
+// naming the registered client in the client parameter and where to send the user back to
+// in redirect_uri, which gets a code the client can exchange for tokens.
+func StartOIDCLogin(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	authURL, state, err := gruff.StartExternalLogin(ctx, c.Param("provider"), c.QueryParam("client"), c.QueryParam("redirect_uri"))
+	if err != nil {
+		return AddError(ctx, c, err)
 	}
 
-	return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	// Ties the sign in to this browser, so that nobody else can finish it
+	c.SetCookie(&http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(gruff.EXTERNAL_LOGIN_EXPIRATION.Seconds()),
+		HttpOnly: true,
+		Secure:   c.IsTLS(),
+	})
+
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Finishes signing in with an external identity provider, which sends the user back here,
+// and sends them on to the client that started the sign in with a code, or the reason it failed
+func FinishOIDCLogin(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	state := c.QueryParam("state")
+	cookie, cerr := c.Cookie(OIDC_STATE_COOKIE)
+	if cerr != nil || state == "" || cookie.Value != state {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("This sign in is invalid or has expired"))
+	}
+	c.SetCookie(&http.Cookie{
+		Name:   OIDC_STATE_COOKIE,
+		Path:   "/api/auth/oidc",
+		MaxAge: -1,
+	})
+
+	code := c.QueryParam("code")
+	if c.QueryParam("error") != "" {
+		code = ""
+	}
+	user, login, err := gruff.FinishExternalLogin(ctx, c.Param("provider"), state, code)
+	if login.RedirectURI == "" {
+		if err == nil {
+			err = gruff.NewUnauthorizedError("This sign in is invalid or has expired")
+		}
+		return AddError(ctx, c, err)
+	}
+
+	if reason := c.QueryParam("error"); reason != "" {
+		return c.Redirect(http.StatusFound, login.RedirectWith(url.Values{"error": {reason}}))
+	}
+	if err != nil {
+		return c.Redirect(http.StatusFound, login.RedirectWith(url.Values{"error": {"access_denied"}, "error_description": {err.Error()}}))
+	}
+
+	loginCode, err := gruff.IssueLoginCode(ctx, user, login)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+	return c.Redirect(http.StatusFound, login.RedirectWith(url.Values{"code": {loginCode}}))
+}
+
+type loginCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Exchanges the code a client got back from signing in with an external identity provider for tokens
+func ExchangeLoginCode(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	req := loginCodeRequest{}
+	if err := c.Bind(&req); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+
+	client, _ := c.Get("Client").(gruff.Client)
+	user, err := gruff.ExchangeLoginCode(ctx, req.Code, client)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	tokens, err := tokensForUser(ctx, user)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Issues a new access token and a new family of refresh tokens
+func tokensForUser(ctx *gruff.ServerContext, user gruff.User) (map[string]interface{}, gruff.Error) {
+	t, err := TokenForUser(user)
+	if err != nil {
+		return nil, gruff.NewUnauthorizedError("Unauthorized")
+	}
+
+	refreshToken, rerr := gruff.IssueRefreshToken(ctx, user, "")
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	return map[string]interface{}{"user": user, "token": t, "refreshToken": refreshToken}, nil
 }
 
 func TokenForUser(user gruff.User) (string, error) {
 	expireAt := gruff.JWTTokenExpirationDate()
-	jwt, dberr := gruff.IssueJWToken(user.Key, []string{"user"}, expireAt)
+	jwt, dberr := gruff.IssueJWToken(user.Key, user.RoleNames(), expireAt)
 	return jwt, dberr
 }
 
-func verifyPassword(user gruff.User, password string) (bool, error) {
-	return bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)) == nil, nil
-}
-
 func ChangePassword(c echo.Context) error {
 	ctx := ServerContext(c)
 
+	if !ctx.UserLoggedIn() {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	}
+
 	type customPassword struct {
-		Email       string `json:"email"`
 		OldPassword string `json:"oldpassword"`
 		NewPassword string `json:"newpassword"`
 	}
@@ -103,6 +300,66 @@ func ChangePassword(c echo.Context) error {
 	return c.JSON(http.StatusOK, user)
 }
 
+// Runs work that the response shouldn't wait for
+var runInBackground = func(f func()) { go f() }
+
+// Emails a password reset link to the given address, if it belongs to an account.
+// The response is the same either way, and is sent before the account is even looked up
+// so that it doesn't take any longer for addresses that belong to an account.
+func RequestPasswordReset(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	req := struct {
+		Email string `json:"email"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		return AddError(ctx, c, gruff.NewBusinessError("Email: non zero value required;"))
+	}
+
+	wait, err := gruff.PasswordResetLockedFor(ctx, req.Email)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+	if wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+		return AddError(ctx, c, gruff.NewTooManyRequestsError("Too many password resets have been requested for this email. Please try again later.", gruff.ERROR_SUBCODE_RESET_THROTTLED))
+	}
+	if err := gruff.RecordPasswordResetRequest(ctx, req.Email); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	logger := c.Logger()
+	runInBackground(func() {
+		if err := gruff.RequestPasswordReset(ctx, req.Email); err != nil {
+			logger.Error(err.Error())
+		}
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func ConfirmPasswordReset(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	req := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+
+	if _, err := gruff.ResetPassword(ctx, req.Token, req.Password); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
 func GetMe(c echo.Context) error {
 	ctx := ServerContext(c)
 
@@ -133,9 +390,106 @@ func UpdateMe(c echo.Context) error {
 		return AddError(ctx, c, err)
 	}
 
+	// A new email address has to be verified again
+	if !user.Verified() && user.Email != ctx.UserContext.Email {
+		if err := user.SendVerificationEmail(ctx); err != nil {
+			c.Logger().Error(err.Error())
+		}
+	}
+
 	return c.JSON(http.StatusOK, user)
 }
 
+func VerifyEmail(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	user, err := gruff.VerifyEmail(ctx, c.QueryParam("token"))
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"email": user.Email, "verified": user.EmailVerifiedAt})
+}
+
+// Sends the logged in user another email with which to verify their address
+func ResendVerificationEmail(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !ctx.UserLoggedIn() {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	}
+
+	if err := ctx.UserContext.SendVerificationEmail(ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// API keys can only be managed by the user themselves, not by a bot using one of them
+func requireSignedInUser(c echo.Context, ctx *gruff.ServerContext) gruff.Error {
+	if !ctx.UserLoggedIn() {
+		return gruff.NewUnauthorizedError("Unauthorized")
+	}
+	if c.Get("ApiKey") != nil {
+		return gruff.NewPermissionError("API keys cannot be used to manage API keys")
+	}
+	return nil
+}
+
+func ListApiKeys(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requireSignedInUser(c, ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	keys, err := ctx.UserContext.ApiKeys(ctx)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// Creates a new API key for the logged in user. The key itself is only ever returned here.
+func CreateApiKey(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requireSignedInUser(c, ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	req := struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return AddError(ctx, c, gruff.NewServerError(err.Error()))
+	}
+
+	key, token, err := ctx.UserContext.IssueApiKey(ctx, req.Name, req.Scope)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{"apiKey": key, "key": token})
+}
+
+func RevokeApiKey(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if err := requireSignedInUser(c, ctx); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	if err := ctx.UserContext.RevokeApiKey(ctx, c.Param("id")); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
 func SetScore(c echo.Context) error {
 	ctx := ServerContext(c)
 
@@ -174,3 +528,86 @@ func SetScore(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, score)
 }
+
+func RetractScore(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !gruff.IsArangoObject(reflect.PtrTo(ctx.Type)) {
+		return AddError(ctx, c, gruff.NewServerError(fmt.Sprintf("This item isn't compatible with this request")))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	item, err := loadItem(c, id)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	u := ctx.UserContext
+	if err := u.RetractScore(ctx, item.(gruff.ArangoObject)); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func Follow(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !ctx.UserLoggedIn() {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	}
+
+	if !gruff.IsArangoObject(reflect.PtrTo(ctx.Type)) {
+		return AddError(ctx, c, gruff.NewServerError(fmt.Sprintf("This item isn't compatible with this request")))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	item, err := loadItem(c, id)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	u := ctx.UserContext
+	if err := u.Follow(ctx, item.(gruff.ArangoObject)); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func Unfollow(c echo.Context) error {
+	ctx := ServerContext(c)
+
+	if !ctx.UserLoggedIn() {
+		return AddError(ctx, c, gruff.NewUnauthorizedError("Unauthorized"))
+	}
+
+	if !gruff.IsArangoObject(reflect.PtrTo(ctx.Type)) {
+		return AddError(ctx, c, gruff.NewServerError(fmt.Sprintf("This item isn't compatible with this request")))
+	}
+
+	id := c.Param("id")
+	if id == "" {
+		return AddError(ctx, c, gruff.NewNotFoundError("Not Found"))
+	}
+
+	item, err := loadItem(c, id)
+	if err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	u := ctx.UserContext
+	if err := u.Unfollow(ctx, item.(gruff.ArangoObject)); err != nil {
+		return AddError(ctx, c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,11 +1,18 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/GruffDebate/server/gruff"
+	"github.com/GruffDebate/server/gruff/oidctest"
+	"github.com/labstack/echo"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -55,6 +62,162 @@ func TestLogin(t *testing.T) {
 	assert.Equal(t, http.StatusOK, res.Code)
 }
 
+func TestLoginWrongPassword(t *testing.T) {
+	setup()
+	defer teardown()
+
+	createUser("wrongpw", "wrongpw", "wrongpw@test1.com")
+
+	u := map[string]interface{}{
+		"email":    "wrongpw@test1.com",
+		"password": "654321",
+	}
+
+	r := New(nil)
+	r.POST("/api/auth")
+	r.SetBody(u)
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "Invalid email or password"}`, gruff.ERROR_SUBCODE_CREDENTIALS_INVALID), res.Body.String())
+
+	// Unknown accounts look just the same
+	u["email"] = "nobody@test1.com"
+	r = New(nil)
+	r.POST("/api/auth")
+	r.SetBody(u)
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "Invalid email or password"}`, gruff.ERROR_SUBCODE_CREDENTIALS_INVALID), res.Body.String())
+}
+
+func TestLoginThrottled(t *testing.T) {
+	setup()
+	defer teardown()
+
+	createUser("throttled", "throttled", "throttled@test1.com")
+
+	u := map[string]interface{}{
+		"email":    "throttled@test1.com",
+		"password": "654321",
+	}
+	for i := 0; i <= gruff.LOGIN_THROTTLE_ACCOUNT_ATTEMPTS; i++ {
+		r := New(nil)
+		r.POST("/api/auth")
+		r.SetBody(u)
+		res, _ := r.Run(Router())
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	}
+
+	// Even the right password is turned away while the account is locked
+	u["password"] = "123456"
+	r := New(nil)
+	r.POST("/api/auth")
+	r.SetBody(u)
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+}
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	TRUSTED_PROXIES = []*net.IPNet{proxies}
+	defer func() { TRUSTED_PROXIES = nil }()
+
+	clientIP := func(remoteAddr string, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return ClientIP(echo.New().NewContext(req, httptest.NewRecorder()))
+	}
+
+	assert.Equal(t, "203.0.113.7", clientIP("203.0.113.7:4321", nil))
+
+	// Clients can't pick their own address by sending the headers themselves
+	assert.Equal(t, "203.0.113.7", clientIP("203.0.113.7:4321", map[string]string{
+		echo.HeaderXForwardedFor: "198.51.100.1",
+		echo.HeaderXRealIP:       "198.51.100.1",
+	}))
+
+	// Behind a trusted proxy, the client is the last address the proxies didn't add themselves
+	assert.Equal(t, "198.51.100.1", clientIP("10.0.0.2:4321", map[string]string{
+		echo.HeaderXForwardedFor: "192.0.2.99, 198.51.100.1, 10.0.0.3",
+	}))
+	assert.Equal(t, "10.0.0.2", clientIP("10.0.0.2:4321", nil))
+}
+
+func TestRefreshToken(t *testing.T) {
+	setup()
+	defer teardown()
+
+	createUser("refresher", "refresher", "refresher@test1.com")
+
+	r := New(nil)
+	r.POST("/api/auth")
+	r.SetBody(map[string]interface{}{"email": "refresher@test1.com", "password": "123456"})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	signIn := map[string]interface{}{}
+	jerr := json.Unmarshal(res.Body.Bytes(), &signIn)
+	assert.NoError(t, jerr)
+	refreshToken := signIn["refreshToken"].(string)
+	assert.NotEmpty(t, refreshToken)
+
+	r = New(nil)
+	r.POST("/api/auth/refresh")
+	r.SetBody(map[string]interface{}{"refreshToken": refreshToken})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	refreshed := map[string]interface{}{}
+	jerr = json.Unmarshal(res.Body.Bytes(), &refreshed)
+	assert.NoError(t, jerr)
+	assert.NotEmpty(t, refreshed["token"])
+	assert.NotEqual(t, refreshToken, refreshed["refreshToken"])
+
+	// Refresh tokens can only be used once
+	r = New(nil)
+	r.POST("/api/auth/refresh")
+	r.SetBody(map[string]interface{}{"refreshToken": refreshToken})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestLogout(t *testing.T) {
+	setup()
+	defer teardown()
+
+	u := createUser("loggingout", "loggingout", "loggingout@test1.com")
+	token := tokenForTestUser(u)
+	refreshToken, err := gruff.IssueRefreshToken(CTX, u, "")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r := New(token)
+	r.GET("/api/users/me")
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	r = New(token)
+	r.POST("/api/auth/logout")
+	r.SetBody(map[string]interface{}{"refreshToken": refreshToken})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	r = New(token)
+	r.GET("/api/users/me")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(nil)
+	r.POST("/api/auth/refresh")
+	r.SetBody(map[string]interface{}{"refreshToken": refreshToken})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
 func TestListClaimsByUser(t *testing.T) {
 	setup()
 	defer teardown()
@@ -185,6 +348,652 @@ func TestSetScore(t *testing.T) {
 	assert.Equal(t, float32(0.22), score.Score)
 }
 
+func TestRetractScore(t *testing.T) {
+	setup()
+	defer teardown()
+
+	u := CTX.UserContext
+
+	claim := gruff.Claim{
+		Title: "Dude, I take it back",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r := New(tokenForTestUser(u))
+	r.DELETE(fmt.Sprintf("/api/claims/%s/score", claim.ID))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	err = u.Score(CTX, &claim, 0.85)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r = New(tokenForTestUser(u))
+	r.DELETE(fmt.Sprintf("/api/claims/%s/score", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	score, err := u.ScoreFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.Nil(t, score)
+
+	err = claim.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, gruff.DEFAULT_CLAIM_SCORE, claim.Truth)
+}
+
+func TestFollow(t *testing.T) {
+	setup()
+	defer teardown()
+
+	claim := gruff.Claim{
+		Title: "Somebody ought to keep an eye on this claim",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	u := createUser("watcher", "watcher", "watcher@test1.com")
+
+	r := New(nil)
+	r.POST(fmt.Sprintf("/api/claims/%s/follow", claim.ID))
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(nil)
+	r.DELETE(fmt.Sprintf("/api/claims/%s/follow", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(tokenForTestUser(u))
+	r.DELETE(fmt.Sprintf("/api/claims/%s/follow", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	r = New(tokenForTestUser(u))
+	r.POST(fmt.Sprintf("/api/claims/%s/follow", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	follow, err := u.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.NotNil(t, follow)
+
+	// Following twice is harmless
+	r = New(tokenForTestUser(u))
+	r.POST(fmt.Sprintf("/api/claims/%s/follow", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	followers, err := gruff.Followers(CTX, &claim)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{CTX.UserContext.ArangoID(), u.ArangoID()}, followers)
+
+	r = New(tokenForTestUser(u))
+	r.DELETE(fmt.Sprintf("/api/claims/%s/follow", claim.ID))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	follow, err = u.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.Nil(t, follow)
+}
+
+type recordingMailer struct {
+	sent []gruff.Email
+}
+
+func (m *recordingMailer) Send(email gruff.Email) gruff.Error {
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+func TestVerifyEmail(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mailer := &recordingMailer{}
+	gruff.MAILER = mailer
+	defer func() { gruff.MAILER = gruff.LogMailer{} }()
+
+	r := New(nil)
+	r.POST("/api/users")
+	r.SetBody(map[string]interface{}{
+		"name":     "verifyme",
+		"username": "verifyme",
+		"email":    "verifyme@test1.com",
+		"password": "123456",
+	})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusCreated, res.Code)
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "verifyme@test1.com", mailer.sent[0].To)
+
+	link := verificationLinkFromEmail(t, mailer.sent[0])
+
+	r = New(nil)
+	r.GET("/api/users/verify?token=not-a-token")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "This verification link is invalid or has expired"}`, gruff.ERROR_SUBCODE_EMAIL_TOKEN_INVALID), res.Body.String())
+
+	r = New(nil)
+	r.GET(link)
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	u := gruff.User{Email: "verifyme@test1.com"}
+	err := u.Load(CTX)
+	assert.NoError(t, err)
+	assert.NotNil(t, u.EmailVerifiedAt)
+
+	// Once verified, there's nothing more to send
+	r = New(tokenForTestUser(u))
+	r.POST("/api/users/verify")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Len(t, mailer.sent, 1)
+}
+
+func TestVerifyEmailInProduction(t *testing.T) {
+	setup()
+	defer teardown()
+
+	pool := ARANGODB_POOL
+	ARANGODB_POOL = TESTDB
+	defer func() { ARANGODB_POOL = pool }()
+	router := SetUpRouter(ProductionMiddlewareConfigurer{})
+
+	client := gruff.Client{Key: "gruff-web", AllowedOrigins: []string{"https://www.gruff.org"}}
+	err := client.Create(CTX)
+	assert.NoError(t, err)
+	defer client.Delete(CTX)
+
+	mailer := &recordingMailer{}
+	gruff.MAILER = mailer
+	defer func() { gruff.MAILER = gruff.LogMailer{} }()
+
+	u := createUser("verifyprod", "verifyprod", "verifyprod@test1.com")
+	err = u.SendVerificationEmail(CTX)
+	assert.NoError(t, err)
+	assert.Len(t, mailer.sent, 1)
+
+	// A browser following the link doesn't say which client it is
+	r := New(nil)
+	r.GET(verificationLinkFromEmail(t, mailer.sent[0]))
+	res, _ := r.Run(router)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	err = u.Load(CTX)
+	assert.NoError(t, err)
+	assert.True(t, u.Verified())
+}
+
+func verificationLinkFromEmail(t *testing.T, email gruff.Email) string {
+	start := strings.Index(email.Body, "/api/users/verify?")
+	assert.True(t, start >= 0)
+	return strings.Fields(email.Body[start:])[0]
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mailer := &recordingMailer{}
+	gruff.MAILER = mailer
+	defer func() { gruff.MAILER = gruff.LogMailer{} }()
+
+	u := createUser("resend", "resend", "resend@test1.com")
+
+	r := New(nil)
+	r.POST("/api/users/verify")
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(tokenForTestUser(u))
+	r.POST("/api/users/verify")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "resend@test1.com", mailer.sent[0].To)
+}
+
+func TestSetScoreUnverified(t *testing.T) {
+	setup()
+	defer teardown()
+
+	gruff.REQUIRE_VERIFIED_VOTERS = true
+	defer func() { gruff.REQUIRE_VERIFIED_VOTERS = false }()
+
+	u := createUser("unverified", "unverified", "unverified@test1.com")
+
+	claim := gruff.Claim{
+		Title: "Only verified users can score me",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	r := New(tokenForTestUser(u))
+	r.POST(fmt.Sprintf("/api/claims/%s/score", claim.ID))
+	r.SetBody(map[string]interface{}{"score": 0.85})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "You must verify your email address before you can vote"}`, gruff.ERROR_SUBCODE_EMAIL_UNVERIFIED), res.Body.String())
+
+	r = New(tokenForTestUser(DEFAULT_USER))
+	r.POST(fmt.Sprintf("/api/claims/%s/score", claim.ID))
+	r.SetBody(map[string]interface{}{"score": 0.85})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestChangePassword(t *testing.T) {
+	setup()
+	defer teardown()
+
+	u := createUser("changepw", "changepw", "changepw@test1.com")
+
+	r := New(nil)
+	r.PUT("/api/users/password")
+	r.SetBody(map[string]interface{}{"oldpassword": "123456", "newpassword": "654321"})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(tokenForTestUser(u))
+	r.PUT("/api/users/password")
+	r.SetBody(map[string]interface{}{"oldpassword": "wrong1", "newpassword": "654321"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "Old Password: is incorrect;"}`, gruff.ERROR_SUBCODE_CREDENTIALS_INVALID), res.Body.String())
+
+	r = New(tokenForTestUser(u))
+	r.PUT("/api/users/password")
+	r.SetBody(map[string]interface{}{"oldpassword": "123456", "newpassword": "654321"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	r = New(nil)
+	r.POST("/api/auth")
+	r.SetBody(map[string]interface{}{"email": "changepw@test1.com", "password": "654321"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mailer := &recordingMailer{}
+	gruff.MAILER = mailer
+	defer func() { gruff.MAILER = gruff.LogMailer{} }()
+
+	background := runInBackground
+	runInBackground = func(f func()) { f() }
+	defer func() { runInBackground = background }()
+
+	createUser("resetpw", "resetpw", "resetpw@test1.com")
+
+	// Unknown addresses look just the same
+	r := New(nil)
+	r.POST("/api/users/password-reset")
+	r.SetBody(map[string]interface{}{"email": "nobody@test1.com"})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Len(t, mailer.sent, 0)
+
+	r = New(nil)
+	r.POST("/api/users/password-reset")
+	r.SetBody(map[string]interface{}{"email": "resetpw@test1.com"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Len(t, mailer.sent, 1)
+
+	body := mailer.sent[0].Body
+	start := strings.Index(body, "token=")
+	assert.True(t, start >= 0)
+	token, _ := url.QueryUnescape(strings.Fields(body[start+len("token="):])[0])
+
+	r = New(nil)
+	r.POST("/api/users/password-reset/confirm")
+	r.SetBody(map[string]interface{}{"token": token, "password": "654321"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	r = New(nil)
+	r.POST("/api/users/password-reset/confirm")
+	r.SetBody(map[string]interface{}{"token": token, "password": "abcdef"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "This password reset link is invalid or has expired"}`, gruff.ERROR_SUBCODE_RESET_TOKEN_INVALID), res.Body.String())
+
+	r = New(nil)
+	r.POST("/api/auth")
+	r.SetBody(map[string]interface{}{"email": "resetpw@test1.com", "password": "654321"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestPasswordResetThrottle(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mailer := &recordingMailer{}
+	gruff.MAILER = mailer
+	defer func() { gruff.MAILER = gruff.LogMailer{} }()
+
+	background := runInBackground
+	runInBackground = func(f func()) { f() }
+	defer func() { runInBackground = background }()
+
+	createUser("floodme", "floodme", "floodme@test1.com")
+
+	r := New(nil)
+	r.POST("/api/users/password-reset")
+	r.SetBody(map[string]interface{}{"email": ""})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	for i := 0; i <= gruff.LOGIN_THROTTLE_PASSWORD_RESET_ATTEMPTS; i++ {
+		r = New(nil)
+		r.POST("/api/users/password-reset")
+		r.SetBody(map[string]interface{}{"email": "floodme@test1.com"})
+		res, _ = r.Run(Router())
+		assert.Equal(t, http.StatusNoContent, res.Code)
+	}
+	assert.Len(t, mailer.sent, gruff.LOGIN_THROTTLE_PASSWORD_RESET_ATTEMPTS+1)
+
+	r = New(nil)
+	r.POST("/api/users/password-reset")
+	r.SetBody(map[string]interface{}{"email": "FloodMe@test1.com"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+	assert.JSONEq(t, fmt.Sprintf(`{"code": %d, "message": "Too many password resets have been requested for this email. Please try again later."}`, gruff.ERROR_SUBCODE_RESET_THROTTLED), res.Body.String())
+	assert.Len(t, mailer.sent, gruff.LOGIN_THROTTLE_PASSWORD_RESET_ATTEMPTS+1)
+
+	// Addresses without an account are throttled just the same
+	for i := 0; i <= gruff.LOGIN_THROTTLE_PASSWORD_RESET_ATTEMPTS; i++ {
+		r = New(nil)
+		r.POST("/api/users/password-reset")
+		r.SetBody(map[string]interface{}{"email": "nobody@test1.com"})
+		res, _ = r.Run(Router())
+		assert.Equal(t, http.StatusNoContent, res.Code)
+	}
+	r = New(nil)
+	r.POST("/api/users/password-reset")
+	r.SetBody(map[string]interface{}{"email": "nobody@test1.com"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+}
+
+// Registers a mock identity provider and a client to sign in to. Returns a function that starts signing in
+// through the given router as the user the claims describe, returning the state and the callback
+// the provider sends them back to, and a function to clean up afterwards.
+func setupOIDCLogin(t *testing.T) (func(router *echo.Echo, claims map[string]interface{}) (string, *url.URL), func()) {
+	mock := oidctest.NewProvider("gruff")
+	gruff.RegisterIdentityProvider(&gruff.OIDCProvider{
+		ProviderName: "mock",
+		Issuer:       mock.Issuer,
+		ClientID:     mock.ClientID,
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/mock/callback",
+	})
+	client := gruff.Client{Key: "gruff-web", AllowedOrigins: []string{"https://www.gruff.org"}}
+	err := client.Create(CTX)
+	assert.NoError(t, err)
+
+	start := func(router *echo.Echo, claims map[string]interface{}) (string, *url.URL) {
+		// Browsers are sent here directly, so they don't say which client they are using
+		r := New(nil)
+		r.GET("/api/auth/oidc/mock?client=gruff-web&redirect_uri=" + url.QueryEscape("https://www.gruff.org/auth/callback"))
+		res, _ := r.Run(router)
+		assert.Equal(t, http.StatusFound, res.Code)
+
+		var state string
+		for _, c := range res.Result().Cookies() {
+			if c.Name == OIDC_STATE_COOKIE {
+				state = c.Value
+				assert.True(t, c.HttpOnly)
+			}
+		}
+		assert.NotEmpty(t, state)
+
+		callback, err := mock.Authorize(res.Header().Get("Location"), claims)
+		assert.NoError(t, err)
+		assert.Equal(t, state, callback.Query().Get("state"))
+		return state, callback
+	}
+
+	cleanup := func() {
+		mock.Close()
+		delete(gruff.IDENTITY_PROVIDERS, "mock")
+		client.Delete(CTX)
+	}
+	return start, cleanup
+}
+
+var oidcStudent map[string]interface{} = map[string]interface{}{
+	"sub":            "s1234567",
+	"email":          "student@campus.edu",
+	"email_verified": true,
+	"name":           "Stu Dent",
+}
+
+func TestOIDCLogin(t *testing.T) {
+	setup()
+	defer teardown()
+
+	start, cleanup := setupOIDCLogin(t)
+	defer cleanup()
+
+	r := New(nil)
+	r.GET("/api/auth/oidc")
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `["mock"]`, res.Body.String())
+
+	r = New(nil)
+	r.GET("/api/auth/oidc/nonexistent?client=gruff-web&redirect_uri=" + url.QueryEscape("https://www.gruff.org/auth/callback"))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	// Users can only be sent back to the client's own origins
+	r = New(nil)
+	r.GET("/api/auth/oidc/mock?client=gruff-web&redirect_uri=" + url.QueryEscape("https://www.evil.com/auth/callback"))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	state, callback := start(Router(), oidcStudent)
+	r = New(map[string]string{"Cookie": fmt.Sprintf("%s=%s", OIDC_STATE_COOKIE, state)})
+	r.GET(callback.RequestURI())
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusFound, res.Code)
+
+	// The browser is sent back to the client with a code, rather than the tokens themselves
+	back, perr := url.Parse(res.Header().Get("Location"))
+	assert.NoError(t, perr)
+	assert.Equal(t, "https://www.gruff.org/auth/callback", fmt.Sprintf("%s://%s%s", back.Scheme, back.Host, back.Path))
+	code := back.Query().Get("code")
+	assert.NotEmpty(t, code)
+	assert.NotContains(t, res.Header().Get("Location"), "token")
+
+	// Which only the client can exchange for tokens
+	r = New(nil)
+	r.POST("/api/auth/oidc/token")
+	r.SetBody(map[string]interface{}{"code": code})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(map[string]string{HeaderClient: "gruff-web"})
+	r.POST("/api/auth/oidc/token")
+	r.SetBody(map[string]interface{}{"code": code})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	signIn := map[string]interface{}{}
+	jerr := json.Unmarshal(res.Body.Bytes(), &signIn)
+	assert.NoError(t, jerr)
+	assert.NotEmpty(t, signIn["token"])
+	assert.NotEmpty(t, signIn["refreshToken"])
+
+	// Only once
+	r = New(map[string]string{HeaderClient: "gruff-web"})
+	r.POST("/api/auth/oidc/token")
+	r.SetBody(map[string]interface{}{"code": code})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	u := gruff.User{Email: "student@campus.edu"}
+	err := u.Load(CTX)
+	assert.NoError(t, err)
+	assert.True(t, u.Verified())
+
+	// The sign in has to be finished in the browser that started it
+	_, callback = start(Router(), oidcStudent)
+	r = New(nil)
+	r.GET(callback.RequestURI())
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	r = New(map[string]string{"Cookie": fmt.Sprintf("%s=%s", OIDC_STATE_COOKIE, state)})
+	r.GET(callback.RequestURI())
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	// Users who turn the provider down are sent back to the client to say so
+	state, callback = start(Router(), oidcStudent)
+	r = New(map[string]string{"Cookie": fmt.Sprintf("%s=%s", OIDC_STATE_COOKIE, state)})
+	r.GET(fmt.Sprintf("/api/auth/oidc/mock/callback?error=access_denied&state=%s", url.QueryEscape(state)))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusFound, res.Code)
+	back, _ = url.Parse(res.Header().Get("Location"))
+	assert.Equal(t, "access_denied", back.Query().Get("error"))
+	assert.Empty(t, back.Query().Get("code"))
+}
+
+func TestOIDCLoginInProduction(t *testing.T) {
+	setup()
+	defer teardown()
+
+	pool := ARANGODB_POOL
+	ARANGODB_POOL = TESTDB
+	defer func() { ARANGODB_POOL = pool }()
+	router := func() *echo.Echo {
+		return SetUpRouter(ProductionMiddlewareConfigurer{})
+	}
+
+	start, cleanup := setupOIDCLogin(t)
+	defer cleanup()
+
+	// Everything else still needs the client
+	r := New(nil)
+	r.GET("/api/auth/oidc")
+	res, _ := r.Run(router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	state, callback := start(router(), oidcStudent)
+	r = New(map[string]string{"Cookie": fmt.Sprintf("%s=%s", OIDC_STATE_COOKIE, state)})
+	r.GET(callback.RequestURI())
+	res, _ = r.Run(router())
+	assert.Equal(t, http.StatusFound, res.Code)
+
+	back, perr := url.Parse(res.Header().Get("Location"))
+	assert.NoError(t, perr)
+	code := back.Query().Get("code")
+	assert.NotEmpty(t, code)
+
+	r = New(map[string]string{HeaderClient: "gruff-web", "Origin": "https://www.gruff.org"})
+	r.POST("/api/auth/oidc/token")
+	r.SetBody(map[string]interface{}{"code": code})
+	res, _ = r.Run(router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	signIn := map[string]interface{}{}
+	jerr := json.Unmarshal(res.Body.Bytes(), &signIn)
+	assert.NoError(t, jerr)
+	assert.NotEmpty(t, signIn["token"])
+}
+
+func TestApiKeys(t *testing.T) {
+	setup()
+	defer teardown()
+
+	u := createUser("factchecker", "factchecker", "factchecker@test1.com")
+
+	r := New(nil)
+	r.POST("/api/users/me/api-keys")
+	r.SetBody(map[string]interface{}{"name": "Bot", "scope": "write"})
+	res, _ := r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	create := func(name, scope string) string {
+		r := New(tokenForTestUser(u))
+		r.POST("/api/users/me/api-keys")
+		r.SetBody(map[string]interface{}{"name": name, "scope": scope})
+		res, _ := r.Run(Router())
+		assert.Equal(t, http.StatusCreated, res.Code)
+
+		created := map[string]interface{}{}
+		jerr := json.Unmarshal(res.Body.Bytes(), &created)
+		assert.NoError(t, jerr)
+		return created["key"].(string)
+	}
+	writer := create("Fact-checking bot", "write")
+	reader := create("Dashboard", "read")
+
+	r = New(tokenForTestUser(u))
+	r.GET("/api/users/me/api-keys")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	keys := []gruff.ApiKey{}
+	jerr := json.Unmarshal(res.Body.Bytes(), &keys)
+	assert.NoError(t, jerr)
+	assert.Len(t, keys, 2)
+	assert.NotContains(t, res.Body.String(), writer)
+
+	// Keys act for the user who made them
+	r = New(map[string]string{HeaderApiKey: reader})
+	r.GET("/api/users/me")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+	me := gruff.User{}
+	jerr = json.Unmarshal(res.Body.Bytes(), &me)
+	assert.NoError(t, jerr)
+	assert.Equal(t, u.ArangoKey(), me.ArangoKey())
+
+	// Read-only keys can't change anything
+	r = New(map[string]string{HeaderApiKey: reader})
+	r.PUT("/api/users/me")
+	r.SetBody(map[string]interface{}{"name": "Renamed by a bot"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(map[string]string{HeaderApiKey: writer})
+	r.PUT("/api/users/me")
+	r.SetBody(map[string]interface{}{"name": "Renamed by a bot"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	// Keys can't be used to make more keys
+	r = New(map[string]string{HeaderApiKey: writer})
+	r.POST("/api/users/me/api-keys")
+	r.SetBody(map[string]interface{}{"name": "Another bot", "scope": "write"})
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	r = New(tokenForTestUser(u))
+	r.DELETE(fmt.Sprintf("/api/users/me/api-keys/%s", keys[0].ArangoKey()))
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusNoContent, res.Code)
+
+	r = New(map[string]string{HeaderApiKey: writer})
+	r.GET("/api/users/me")
+	res, _ = r.Run(Router())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
 /*
 func TestListUsers(t *testing.T) {
 	setup()
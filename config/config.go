@@ -2,22 +2,48 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/GruffDebate/server/gruff"
 	arango "github.com/arangodb/go-driver"
 )
 
 var CONFIGURATIONS map[string]string = map[string]string{
-	"GRUFF_ENV":            "development",
-	"GRUFF_NAME":           "GRUFF",
-	"PORT":                 "8080",
-	"ARANGO_ENDPOINT":      "http://localhost:8529",
-	"ARANGO_DB":            "gruff",
-	"ARANGO_USER":          "root",
-	"ARANGO_PASS":          "",
-	"JWT_KEY_SIGNIN":       "a324dd15-74c5-44ea-8f64-8f0e6b90844c",
-	"JWT_TOKEN_EXPIRATION": "720",
+	"GRUFF_ENV":                "development",
+	"GRUFF_NAME":               "GRUFF",
+	"PORT":                     "8080",
+	"ARANGO_ENDPOINT":          "http://localhost:8529",
+	"ARANGO_DB":                "gruff",
+	"ARANGO_USER":              "root",
+	"ARANGO_PASS":              "",
+	"JWT_KEY_SIGNIN":           "a324dd15-74c5-44ea-8f64-8f0e6b90844c",
+	"JWT_TOKEN_EXPIRATION":     "1",
+	"REFRESH_TOKEN_EXPIRATION": "720",
+	"SCORE_QUEUE":              "true",
+	"SCORE_QUEUE_INTERVAL":     "1000",
+	"SCORE_QUEUE_BATCH":        "100",
+	"SCORE_AGGREGATOR":         "mean",
+	"GRUFF_URL":                "http://localhost:8080",
+	"JWT_KEY_EMAIL":            "7c0e8a1d-3f5b-4e62-9a8d-2b6f1c4e9d73",
+	"MAILER":                   "log",
+	"MAILER_LOG_PATH":          "",
+	"SMTP_HOST":                "localhost",
+	"SMTP_PORT":                "25",
+	"SMTP_USER":                "",
+	"SMTP_PASS":                "",
+	"SMTP_FROM":                "noreply@gruff.org",
+	"REQUIRE_VERIFIED_EMAIL":   "true",
+	"OIDC_PROVIDERS":           "",
+	"CLIENTS_FILE":             "",
+	"RATE_LIMIT_STORE":         "memory",
+	"RATE_LIMIT_READ":          "600",
+	"RATE_LIMIT_WRITE":         "60",
+	"RATE_LIMIT_SCORE":         "120",
+	"TRUSTED_PROXIES":          "",
 }
 
 func Init() {
@@ -36,6 +62,72 @@ func Init() {
 	if os.Getenv("JWT_TOKEN_EXPIRATION") == "" {
 		os.Setenv("JWT_TOKEN_EXPIRATION", CONFIGURATIONS["JWT_TOKEN_EXPIRATION"])
 	}
+	if os.Getenv("REFRESH_TOKEN_EXPIRATION") == "" {
+		os.Setenv("REFRESH_TOKEN_EXPIRATION", CONFIGURATIONS["REFRESH_TOKEN_EXPIRATION"])
+	}
+	if os.Getenv("SCORE_QUEUE") == "" {
+		os.Setenv("SCORE_QUEUE", CONFIGURATIONS["SCORE_QUEUE"])
+	}
+	if os.Getenv("SCORE_QUEUE_INTERVAL") == "" {
+		os.Setenv("SCORE_QUEUE_INTERVAL", CONFIGURATIONS["SCORE_QUEUE_INTERVAL"])
+	}
+	if os.Getenv("SCORE_QUEUE_BATCH") == "" {
+		os.Setenv("SCORE_QUEUE_BATCH", CONFIGURATIONS["SCORE_QUEUE_BATCH"])
+	}
+	if os.Getenv("SCORE_AGGREGATOR") == "" {
+		os.Setenv("SCORE_AGGREGATOR", CONFIGURATIONS["SCORE_AGGREGATOR"])
+	}
+	if os.Getenv("GRUFF_URL") == "" {
+		os.Setenv("GRUFF_URL", CONFIGURATIONS["GRUFF_URL"])
+	}
+	if os.Getenv("JWT_KEY_EMAIL") == "" {
+		os.Setenv("JWT_KEY_EMAIL", CONFIGURATIONS["JWT_KEY_EMAIL"])
+	}
+	if os.Getenv("MAILER") == "" {
+		os.Setenv("MAILER", CONFIGURATIONS["MAILER"])
+	}
+	if os.Getenv("MAILER_LOG_PATH") == "" {
+		os.Setenv("MAILER_LOG_PATH", CONFIGURATIONS["MAILER_LOG_PATH"])
+	}
+	if os.Getenv("SMTP_HOST") == "" {
+		os.Setenv("SMTP_HOST", CONFIGURATIONS["SMTP_HOST"])
+	}
+	if os.Getenv("SMTP_PORT") == "" {
+		os.Setenv("SMTP_PORT", CONFIGURATIONS["SMTP_PORT"])
+	}
+	if os.Getenv("SMTP_USER") == "" {
+		os.Setenv("SMTP_USER", CONFIGURATIONS["SMTP_USER"])
+	}
+	if os.Getenv("SMTP_PASS") == "" {
+		os.Setenv("SMTP_PASS", CONFIGURATIONS["SMTP_PASS"])
+	}
+	if os.Getenv("SMTP_FROM") == "" {
+		os.Setenv("SMTP_FROM", CONFIGURATIONS["SMTP_FROM"])
+	}
+	if os.Getenv("REQUIRE_VERIFIED_EMAIL") == "" {
+		os.Setenv("REQUIRE_VERIFIED_EMAIL", CONFIGURATIONS["REQUIRE_VERIFIED_EMAIL"])
+	}
+	if os.Getenv("OIDC_PROVIDERS") == "" {
+		os.Setenv("OIDC_PROVIDERS", CONFIGURATIONS["OIDC_PROVIDERS"])
+	}
+	if os.Getenv("CLIENTS_FILE") == "" {
+		os.Setenv("CLIENTS_FILE", CONFIGURATIONS["CLIENTS_FILE"])
+	}
+	if os.Getenv("RATE_LIMIT_STORE") == "" {
+		os.Setenv("RATE_LIMIT_STORE", CONFIGURATIONS["RATE_LIMIT_STORE"])
+	}
+	if os.Getenv("RATE_LIMIT_READ") == "" {
+		os.Setenv("RATE_LIMIT_READ", CONFIGURATIONS["RATE_LIMIT_READ"])
+	}
+	if os.Getenv("RATE_LIMIT_WRITE") == "" {
+		os.Setenv("RATE_LIMIT_WRITE", CONFIGURATIONS["RATE_LIMIT_WRITE"])
+	}
+	if os.Getenv("RATE_LIMIT_SCORE") == "" {
+		os.Setenv("RATE_LIMIT_SCORE", CONFIGURATIONS["RATE_LIMIT_SCORE"])
+	}
+	if os.Getenv("TRUSTED_PROXIES") == "" {
+		os.Setenv("TRUSTED_PROXIES", CONFIGURATIONS["TRUSTED_PROXIES"])
+	}
 	if os.Getenv("ARANGO_ENDPOINT") == "" {
 		os.Setenv("ARANGO_ENDPOINT", CONFIGURATIONS["ARANGO_ENDPOINT"])
 	}
@@ -54,6 +146,27 @@ func Init() {
 	fmt.Println("PORT=", os.Getenv("PORT"))
 	fmt.Println("JWT_KEY_SIGNIN=", os.Getenv("JWT_KEY_SIGNIN"))
 	fmt.Println("JWT_TOKEN_EXPIRATION=", os.Getenv("JWT_TOKEN_EXPIRATION"))
+	fmt.Println("REFRESH_TOKEN_EXPIRATION=", os.Getenv("REFRESH_TOKEN_EXPIRATION"))
+	fmt.Println("SCORE_QUEUE=", os.Getenv("SCORE_QUEUE"))
+	fmt.Println("SCORE_QUEUE_INTERVAL=", os.Getenv("SCORE_QUEUE_INTERVAL"))
+	fmt.Println("SCORE_QUEUE_BATCH=", os.Getenv("SCORE_QUEUE_BATCH"))
+	fmt.Println("SCORE_AGGREGATOR=", os.Getenv("SCORE_AGGREGATOR"))
+	fmt.Println("GRUFF_URL=", os.Getenv("GRUFF_URL"))
+	fmt.Println("JWT_KEY_EMAIL=", os.Getenv("JWT_KEY_EMAIL"))
+	fmt.Println("MAILER=", os.Getenv("MAILER"))
+	fmt.Println("MAILER_LOG_PATH=", os.Getenv("MAILER_LOG_PATH"))
+	fmt.Println("SMTP_HOST=", os.Getenv("SMTP_HOST"))
+	fmt.Println("SMTP_PORT=", os.Getenv("SMTP_PORT"))
+	fmt.Println("SMTP_USER=", os.Getenv("SMTP_USER"))
+	fmt.Println("SMTP_FROM=", os.Getenv("SMTP_FROM"))
+	fmt.Println("REQUIRE_VERIFIED_EMAIL=", os.Getenv("REQUIRE_VERIFIED_EMAIL"))
+	fmt.Println("OIDC_PROVIDERS=", os.Getenv("OIDC_PROVIDERS"))
+	fmt.Println("CLIENTS_FILE=", os.Getenv("CLIENTS_FILE"))
+	fmt.Println("RATE_LIMIT_STORE=", os.Getenv("RATE_LIMIT_STORE"))
+	fmt.Println("RATE_LIMIT_READ=", os.Getenv("RATE_LIMIT_READ"))
+	fmt.Println("RATE_LIMIT_WRITE=", os.Getenv("RATE_LIMIT_WRITE"))
+	fmt.Println("RATE_LIMIT_SCORE=", os.Getenv("RATE_LIMIT_SCORE"))
+	fmt.Println("TRUSTED_PROXIES=", os.Getenv("TRUSTED_PROXIES"))
 	fmt.Println("ARANGO_ENDPOINT=", os.Getenv("ARANGO_ENDPOINT"))
 	fmt.Println("ARANGO_DB=", os.Getenv("ARANGO_DB"))
 	fmt.Println("ARANGO_USER=", os.Getenv("ARANGO_USER"))
@@ -73,3 +186,152 @@ func InitDB() arango.Database {
 
 	return db
 }
+
+func InitScoreAggregator() {
+	agg, err := gruff.ScoreAggregatorNamed(os.Getenv("SCORE_AGGREGATOR"))
+	if err != nil {
+		fmt.Println("Error configuring the score aggregator, using the default:", err.Error())
+		return
+	}
+	gruff.SCORE_AGGREGATOR = agg
+}
+
+func InitMailer() {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		gruff.MAILER = gruff.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASS"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	case "log", "":
+		gruff.MAILER = gruff.LogMailer{Path: os.Getenv("MAILER_LOG_PATH")}
+	default:
+		fmt.Println("Unknown mailer", os.Getenv("MAILER"), "- logging emails instead")
+		gruff.MAILER = gruff.LogMailer{Path: os.Getenv("MAILER_LOG_PATH")}
+	}
+
+	gruff.REQUIRE_VERIFIED_VOTERS = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+}
+
+// Registers each of the OpenID Connect providers named in OIDC_PROVIDERS (separated by commas),
+// configured by OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET
+// and optionally OIDC_<NAME>_SCOPES (separated by spaces)
+func InitIdentityProviders() {
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_"
+
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			fmt.Println("Skipping identity provider", name, "- set", prefix+"ISSUER and", prefix+"CLIENT_ID")
+			continue
+		}
+
+		gruff.RegisterIdentityProvider(&gruff.OIDCProvider{
+			ProviderName: name,
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  fmt.Sprintf("%s/api/auth/oidc/%s/callback", strings.TrimRight(os.Getenv("GRUFF_URL"), "/"), name),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		})
+		fmt.Println("Registered identity provider", name, "at", issuer)
+	}
+}
+
+// Registers the client apps listed in the JSON file at CLIENTS_FILE, if there is one.
+// More can be registered in the database while the server is running.
+func InitClients() {
+	path := os.Getenv("CLIENTS_FILE")
+	if path == "" {
+		return
+	}
+
+	clients, err := gruff.ReadClientsFile(path)
+	if err != nil {
+		fmt.Println("Error registering clients:", err.Error())
+		return
+	}
+	for _, c := range clients {
+		gruff.RegisterClient(c)
+		fmt.Println("Registered client", c.Key)
+	}
+}
+
+// Sets the number of requests per minute allowed for reading, writing and scoring (0 for no limit),
+// and where to keep track of them: in each server's "memory", or in "arango" to share them between servers
+func InitRateLimits() {
+	store, err := gruff.RateLimitStoreNamed(os.Getenv("RATE_LIMIT_STORE"))
+	if err != nil {
+		fmt.Println("Error configuring the rate limit store, using the default:", err.Error())
+	} else {
+		gruff.RATE_LIMIT_STORE = store
+	}
+
+	for kind, name := range map[string]string{
+		gruff.RATE_LIMIT_READ:  "RATE_LIMIT_READ",
+		gruff.RATE_LIMIT_WRITE: "RATE_LIMIT_WRITE",
+		gruff.RATE_LIMIT_SCORE: "RATE_LIMIT_SCORE",
+	} {
+		perMinute, err := strconv.Atoi(os.Getenv(name))
+		if err != nil {
+			perMinute, _ = strconv.Atoi(CONFIGURATIONS[name])
+		}
+		gruff.RATE_LIMITS[kind] = gruff.RateLimit{Capacity: perMinute, Per: time.Minute}
+	}
+}
+
+// Returns the networks of the proxies in front of the server, from the IP addresses
+// or CIDR ranges in TRUSTED_PROXIES (separated by commas)
+func InitTrustedProxies() []*net.IPNet {
+	proxies := []*net.IPNet{}
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			fmt.Println("Skipping trusted proxy", entry, "-", err.Error())
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+// Returns the background worker that processes queued score updates.
+// The worker runs even if score updates are made during the request,
+// since migrations queue items to be rescored as well.
+func InitScoreWorker(db arango.Database) *gruff.ScoreWorker {
+	gruff.QUEUE_SCORE_UPDATES = os.Getenv("SCORE_QUEUE") == "true"
+
+	interval, err := strconv.Atoi(os.Getenv("SCORE_QUEUE_INTERVAL"))
+	if err != nil {
+		interval, _ = strconv.Atoi(CONFIGURATIONS["SCORE_QUEUE_INTERVAL"])
+	}
+	batch, err := strconv.Atoi(os.Getenv("SCORE_QUEUE_BATCH"))
+	if err != nil {
+		batch, _ = strconv.Atoi(CONFIGURATIONS["SCORE_QUEUE_BATCH"])
+	}
+
+	return &gruff.ScoreWorker{
+		DB:        db,
+		Interval:  time.Duration(interval) * time.Millisecond,
+		BatchSize: batch,
+	}
+}
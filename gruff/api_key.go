@@ -0,0 +1,225 @@
+package gruff
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+)
+
+/*
+ * Bots and other integrations act on behalf of a user with an ApiKey, rather than with
+ * the short-lived tokens issued when the user signs in.
+ *
+ * Like refresh tokens, API keys are opaque random strings of which only a hash is stored,
+ * so the key itself is only ever shown once, when it is created. Each key is scoped either
+ * to reading (safe requests only) or to writing (anything the user could do), and lasts
+ * until it is revoked, or until all of the user's tokens are revoked.
+ */
+
+const API_KEY_PREFIX string = "gruff_"
+const API_KEY_SCOPE_READ string = "read"
+const API_KEY_SCOPE_WRITE string = "write"
+const MAX_API_KEYS_PER_USER int = 20
+
+// Recording every single use would mean a write for every request, so uses are only
+// recorded once they are at least this far apart
+const API_KEY_USE_RESOLUTION time.Duration = 1 * time.Minute
+
+type ApiKey struct {
+	Key        string     `json:"_key"`
+	UserKey    string     `json:"user"`
+	Name       string     `json:"name" valid:"length(1|100),required"`
+	Scope      string     `json:"scope" valid:"in(read|write),required"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"start"`
+	LastUsedAt *time.Time `json:"used,omitempty"`
+	EndedAt    *time.Time `json:"end,omitempty"`
+}
+
+// ArangoObject interface
+
+func (k ApiKey) CollectionName() string {
+	return "api_keys"
+}
+
+func (k ApiKey) ArangoKey() string {
+	return k.Key
+}
+
+func (k ApiKey) ArangoID() string {
+	return fmt.Sprintf("%s/%s", k.CollectionName(), k.ArangoKey())
+}
+
+func (k ApiKey) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (k *ApiKey) Create(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(k)
+	if err != nil {
+		return err
+	}
+	k.PrepareForCreate(ctx)
+	if _, err := col.CreateDocument(ctx.Context, k); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (k *ApiKey) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("API keys cannot be modified")
+}
+
+// Revokes this key, so that it can't be used again
+func (k *ApiKey) Delete(ctx *ServerContext) Error {
+	k.PrepareForDelete(ctx)
+	col, err := ctx.Arango.CollectionFor(k)
+	if err != nil {
+		return err
+	}
+	if _, err := col.UpdateDocument(ctx.Context, k.ArangoKey(), Updates{"end": k.EndedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (k *ApiKey) PrepareForCreate(ctx *ServerContext) {
+	k.CreatedAt = ctx.RequestTime()
+	k.LastUsedAt = nil
+	k.EndedAt = nil
+}
+
+func (k *ApiKey) PrepareForDelete(ctx *ServerContext) {
+	k.EndedAt = support.TimePtr(ctx.RequestTime())
+}
+
+// Validator
+
+func (k ApiKey) ValidateForCreate() Error {
+	return ValidateStruct(k)
+}
+
+// Business methods
+
+// Returns true if the key may be used for requests that change things
+func (k ApiKey) CanWrite() bool {
+	return k.Scope == API_KEY_SCOPE_WRITE
+}
+
+// Creates a new API key for the user, returning it along with the key itself,
+// which can't be retrieved again afterwards
+func (u User) IssueApiKey(ctx *ServerContext, name, scope string) (ApiKey, string, Error) {
+	k := ApiKey{
+		UserKey: u.ArangoKey(),
+		Name:    strings.TrimSpace(name),
+		Scope:   scope,
+	}
+	if err := k.ValidateForCreate(); err != nil {
+		return k, "", err
+	}
+
+	existing, err := u.ApiKeys(ctx)
+	if err != nil {
+		return k, "", err
+	}
+	if len(existing) >= MAX_API_KEYS_PER_USER {
+		return k, "", NewBusinessError(fmt.Sprintf("You can't have more than %d API keys. Please revoke one you no longer use.", MAX_API_KEYS_PER_USER))
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return k, "", err
+	}
+	token = API_KEY_PREFIX + token
+
+	k.Key = hashOpaqueToken(token)
+	k.Prefix = token[:len(API_KEY_PREFIX)+6]
+	if err := k.Create(ctx); err != nil {
+		return k, "", err
+	}
+	return k, token, nil
+}
+
+// Returns the user's API keys that haven't been revoked, oldest first
+func (u User) ApiKeys(ctx *ServerContext) ([]ApiKey, Error) {
+	keys := []ApiKey{}
+	bindVars := BindVars{
+		"user": u.ArangoKey(),
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                               FILTER obj.user == @user
+                                  AND obj.end == null
+                               SORT obj.start
+                               RETURN obj`,
+		ApiKey{}.CollectionName())
+	err := FindArangoObjects(ctx, query, bindVars, &keys)
+	return keys, err
+}
+
+// Revokes the user's API key with the given ID
+func (u User) RevokeApiKey(ctx *ServerContext, id string) Error {
+	k := ApiKey{}
+	if err := LoadArangoObject(ctx, &k, id); err != nil {
+		return err
+	}
+	// Other users' keys are treated as though they don't exist
+	if k.UserKey != u.ArangoKey() || k.EndedAt != nil {
+		return NewNotFoundError("Not Found")
+	}
+	return k.Delete(ctx)
+}
+
+// Returns the active API key with the given value, along with the user it acts for
+func UseApiKey(ctx *ServerContext, token string) (ApiKey, User, Error) {
+	k := ApiKey{}
+	u := User{}
+
+	if !strings.HasPrefix(token, API_KEY_PREFIX) {
+		return k, u, NewUnauthorizedError("Unauthorized")
+	}
+	if err := LoadArangoObject(ctx, &k, hashOpaqueToken(token)); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return k, u, NewUnauthorizedError("Unauthorized")
+		}
+		return k, u, err
+	}
+	if k.EndedAt != nil {
+		return k, u, NewUnauthorizedError("Unauthorized")
+	}
+
+	u.Key = k.UserKey
+	if err := u.Load(ctx); err != nil {
+		return k, u, NewUnauthorizedError("Unauthorized")
+	}
+	// Revoking all of a user's tokens revokes their API keys too
+	if u.DeletedAt != nil || u.TokenRevoked(k.CreatedAt) {
+		return k, u, NewUnauthorizedError("Unauthorized")
+	}
+	if err := u.CheckBanned(); err != nil {
+		return k, u, err
+	}
+
+	if err := k.recordUse(ctx); err != nil {
+		return k, u, err
+	}
+	return k, u, nil
+}
+
+func (k *ApiKey) recordUse(ctx *ServerContext) Error {
+	now := ctx.RequestTime()
+	if k.LastUsedAt != nil && now.Sub(*k.LastUsedAt) < API_KEY_USE_RESOLUTION {
+		return nil
+	}
+
+	col, err := ctx.Arango.CollectionFor(k)
+	if err != nil {
+		return err
+	}
+	k.LastUsedAt = support.TimePtr(now)
+	if _, err := col.UpdateDocument(ctx.Context, k.ArangoKey(), Updates{"used": k.LastUsedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package gruff
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiKeys(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Name: "Fact Checker", Username: "FactChecker", Email: "factchecker@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	_, _, err = u.IssueApiKey(CTX, "  ", API_KEY_SCOPE_READ)
+	assert.Error(t, err)
+	_, _, err = u.IssueApiKey(CTX, "Bot", "admin")
+	assert.Error(t, err)
+
+	key, token, err := u.IssueApiKey(CTX, "Fact-checking bot", API_KEY_SCOPE_WRITE)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.True(t, strings.HasPrefix(token, API_KEY_PREFIX))
+	assert.True(t, strings.HasPrefix(token, key.Prefix))
+	assert.Equal(t, hashOpaqueToken(token), key.ArangoKey())
+	assert.True(t, key.CanWrite())
+
+	reader, readerToken, err := u.IssueApiKey(CTX, "Dashboard", API_KEY_SCOPE_READ)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.False(t, reader.CanWrite())
+
+	keys, err := u.ApiKeys(CTX)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "Fact-checking bot", keys[0].Name)
+	assert.Nil(t, keys[0].LastUsedAt)
+
+	used, user, err := UseApiKey(CTX, token)
+	assert.NoError(t, err)
+	assert.Equal(t, u.ArangoKey(), user.ArangoKey())
+	assert.Equal(t, key.ArangoKey(), used.ArangoKey())
+	assert.NotNil(t, used.LastUsedAt)
+	firstUse := *used.LastUsedAt
+
+	// Uses close together are only recorded once
+	CTX.RequestAt = support.TimePtr(firstUse.Add(10 * time.Second))
+	used, _, err = UseApiKey(CTX, token)
+	assert.NoError(t, err)
+	assert.True(t, firstUse.Equal(*used.LastUsedAt))
+	CTX.RequestAt = support.TimePtr(firstUse.Add(2 * API_KEY_USE_RESOLUTION))
+	used, _, err = UseApiKey(CTX, token)
+	assert.NoError(t, err)
+	assert.True(t, used.LastUsedAt.After(firstUse))
+	CTX.RequestAt = nil
+
+	_, _, err = UseApiKey(CTX, API_KEY_PREFIX+"not-a-key")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+
+	// Users can only revoke their own keys
+	other := User{Name: "Someone Else", Username: "SomeoneElse", Email: "someoneelse@gruff.org", Password: "123456"}
+	err = other.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = other.RevokeApiKey(CTX, key.ArangoKey())
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+
+	err = u.RevokeApiKey(CTX, key.ArangoKey())
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	_, _, err = UseApiKey(CTX, token)
+	assert.Error(t, err)
+	keys, err = u.ApiKeys(CTX)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	// Revoking all of the user's tokens revokes their keys too
+	CTX.RequestAt = support.TimePtr(reader.CreatedAt.Add(time.Second))
+	err = u.RevokeTokens(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	_, _, err = UseApiKey(CTX, readerToken)
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"time"
 
 	"github.com/GruffDebate/server/support"
 	arango "github.com/arangodb/go-driver"
@@ -36,10 +37,15 @@ type ArangoContext struct {
 	Context     context.Context
 	DB          arango.Database
 	Collections map[string]arango.Collection
+	Transaction *ArangoTransaction
 }
 
-func (ctx ArangoContext) Rollback() Error {
-	return NewServerError("Not implemented yet")
+// An ArangoTransaction is a stream transaction that may be joined by nested operations.
+// Only the outermost operation commits it.
+type ArangoTransaction struct {
+	ID     arango.TransactionID
+	Parent context.Context
+	depth  int
 }
 
 func (ctx ArangoContext) Collection(name string) (arango.Collection, Error) {
@@ -104,18 +110,20 @@ var DEFAULT_QUERY_PARAMETERS = ArangoQueryParameters{
 }
 
 type ArangoQueryParameters struct {
-	Sort   *string
-	Offset *int
-	Limit  *int
-	Return *string
+	Sort    *string
+	Offset  *int
+	Limit   *int
+	Return  *string
+	QueryAt *time.Time
 }
 
 func (aqp ArangoQueryParameters) Merge(params ArangoQueryParameters) ArangoQueryParameters {
 	merged := ArangoQueryParameters{
-		Sort:   aqp.Sort,
-		Offset: aqp.Offset,
-		Limit:  aqp.Limit,
-		Return: aqp.Return,
+		Sort:    aqp.Sort,
+		Offset:  aqp.Offset,
+		Limit:   aqp.Limit,
+		Return:  aqp.Return,
+		QueryAt: aqp.QueryAt,
 	}
 
 	if params.Sort != nil {
@@ -130,6 +138,9 @@ func (aqp ArangoQueryParameters) Merge(params ArangoQueryParameters) ArangoQuery
 	if params.Return != nil {
 		merged.Return = params.Return
 	}
+	if params.QueryAt != nil {
+		merged.QueryAt = params.QueryAt
+	}
 
 	return merged
 }
@@ -157,6 +168,23 @@ func (aqp ArangoQueryParameters) Apply(query string) string {
 	return fmt.Sprintf(query, sort, limit, ret)
 }
 
+// ActiveFilter returns a match expression for the items referenced by ref
+// that were active at QueryAt, or that are currently active if QueryAt is nil.
+// The @query_at bind variable must be set with BindQueryDate when QueryAt is used.
+func (aqp ArangoQueryParameters) ActiveFilter(ref string) string {
+	if aqp.QueryAt == nil {
+		return fmt.Sprintf("%s.end == null", ref)
+	}
+	return fmt.Sprintf("%s.start <= @query_at AND (%s.end == null OR %s.end > @query_at)", ref, ref, ref)
+}
+
+func (aqp ArangoQueryParameters) BindQueryDate(bindVars BindVars) BindVars {
+	if aqp.QueryAt != nil {
+		bindVars["query_at"] = *aqp.QueryAt
+	}
+	return bindVars
+}
+
 // Default CRUD Operations
 
 func CreateArangoObject(ctx *ServerContext, obj ArangoObject) Error {
@@ -303,12 +331,12 @@ func DeleteArangoObjects(ctx *ServerContext, collectionName, filter string, bind
 // Default Finders
 
 func DefaultListQuery(obj ArangoObject, params ArangoQueryParameters) string {
-	query := fmt.Sprintf("FOR obj IN %s FILTER obj.end == null", obj.CollectionName())
+	query := fmt.Sprintf("FOR obj IN %s FILTER %s", obj.CollectionName(), params.ActiveFilter("obj"))
 	return params.Apply(query)
 }
 
 func DefaultListQueryForUser(obj ArangoObject, params ArangoQueryParameters) string {
-	query := fmt.Sprintf("FOR obj IN %s FILTER obj.creator == @creator AND obj.end == null", obj.CollectionName())
+	query := fmt.Sprintf("FOR obj IN %s FILTER obj.creator == @creator AND %s", obj.CollectionName(), params.ActiveFilter("obj"))
 	return params.Apply(query)
 }
 
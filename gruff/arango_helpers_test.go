@@ -77,6 +77,29 @@ func TestDefaultListQuery(t *testing.T) {
 	obj = &Argument{}
 	params.Return = support.StringPtr("obj._id")
 	assert.Equal(t, "FOR obj IN arguments FILTER obj.end == null SORT obj.start DESC LIMIT 0, 20 RETURN obj._id", DefaultListQuery(obj, params))
+
+	params.QueryAt = support.TimePtr(time.Now())
+	assert.Equal(t, "FOR obj IN arguments FILTER obj.start <= @query_at AND (obj.end == null OR obj.end > @query_at) SORT obj.start DESC LIMIT 0, 20 RETURN obj._id", DefaultListQuery(obj, params))
+	assert.Equal(t, "FOR obj IN arguments FILTER obj.creator == @creator AND obj.start <= @query_at AND (obj.end == null OR obj.end > @query_at) SORT obj.start DESC LIMIT 0, 20 RETURN obj._id", DefaultListQueryForUser(obj, params))
+
+	bindVars := params.BindQueryDate(BindVars{})
+	assert.Equal(t, *params.QueryAt, bindVars["query_at"])
+
+	params.QueryAt = nil
+	bindVars = params.BindQueryDate(BindVars{})
+	_, ok := bindVars["query_at"]
+	assert.False(t, ok)
+}
+
+func TestArangoQueryParametersMergeQueryAt(t *testing.T) {
+	queryAt := time.Now()
+	params := DEFAULT_QUERY_PARAMETERS.Merge(ArangoQueryParameters{QueryAt: &queryAt})
+	assert.Equal(t, &queryAt, params.QueryAt)
+	assert.Equal(t, DEFAULT_QUERY_PARAMETERS.Limit, params.Limit)
+
+	params = params.Merge(ArangoQueryParameters{Limit: support.IntPtr(5)})
+	assert.Equal(t, &queryAt, params.QueryAt)
+	assert.Equal(t, 5, *params.Limit)
 }
 
 func TestListArangoObjects(t *testing.T) {
@@ -2,6 +2,7 @@ package gruff
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
@@ -52,22 +53,25 @@ const DEFAULT_ARGUMENT_SCORE float32 = 1.00
 
 type Argument struct {
 	VersionedModel
-	TargetClaimID    *string    `json:"targetClaimId,omitempty"`
-	TargetClaim      *Claim     `json:"targetClaim,omitempty" transient:"true"`
-	TargetArgumentID *string    `json:"targetArgId,omitempty"`
-	TargetArgument   *Argument  `json:"targetArg,omitempty" transient:"true"`
-	ClaimID          string     `json:"claimId"`
-	Claim            *Claim     `json:"claim,omitempty" transient:"true"`
-	Title            string     `json:"title" valid:"length(3|1000)"`
-	Negation         string     `json:"negation"`
-	Question         string     `json:"question"`
-	Description      string     `json:"desc" valid:"length(3|4000)"`
-	Note             string     `json:"note"`
-	Pro              bool       `json:"pro"`
-	Relevance        float32    `json:"relevance"`
-	Str              float32    `json:"strength"`
-	ProArgs          []Argument `json:"proargs" transient:"true"`
-	ConArgs          []Argument `json:"conargs" transient:"true"`
+	TargetClaimID    *string           `json:"targetClaimId,omitempty"`
+	TargetClaim      *Claim            `json:"targetClaim,omitempty" transient:"true"`
+	TargetArgumentID *string           `json:"targetArgId,omitempty"`
+	TargetArgument   *Argument         `json:"targetArg,omitempty" transient:"true"`
+	ClaimID          string            `json:"claimId"`
+	Claim            *Claim            `json:"claim,omitempty" transient:"true"`
+	Title            string            `json:"title" valid:"length(3|1000)"`
+	Negation         string            `json:"negation"`
+	Question         string            `json:"question"`
+	Description      string            `json:"desc" valid:"length(3|4000)"`
+	Note             string            `json:"note"`
+	Pro              bool              `json:"pro"`
+	Relevance        float32           `json:"relevance"`
+	Str              float32           `json:"strength"`
+	StrengthRU       float32           `json:"strengthRU" settable:"false"`
+	Distribution     ScoreDistribution `json:"distribution" settable:"false"`
+	ProArgs          []Argument        `json:"proargs" transient:"true"`
+	ConArgs          []Argument        `json:"conargs" transient:"true"`
+	View             *UserView         `json:"myView,omitempty" transient:"true"`
 }
 
 // ArangoObject interface
@@ -157,8 +161,13 @@ func (a *Argument) Create(ctx *ServerContext) Error {
 		}
 	}
 
+	// New versions of existing arguments are also created here, but aren't new to anyone
+	isNew := a.ID == ""
+
 	a.Relevance = DEFAULT_ARGUMENT_SCORE
 	a.Str = a.Relevance * baseClaim.Truth
+	a.StrengthRU = a.Relevance * baseClaim.TruthRU
+	a.Distribution = NewScoreDistribution([]Vote{})
 
 	if err := CreateArangoObject(ctx, a); err != nil {
 		ctx.Rollback()
@@ -183,14 +192,35 @@ func (a *Argument) Create(ctx *ServerContext) Error {
 		return err
 	}
 
+	if isNew {
+		if err := autoFollow(ctx, a); err != nil {
+			ctx.Rollback()
+			return err
+		}
+		if err := NotifyNewArgument(ctx, target, *a); err != nil {
+			ctx.Rollback()
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Computed fields, such as the scores, are left out of the updates
 func (a *Argument) Update(ctx *ServerContext, updates Updates) Error {
+	updates, err := ClearUnsettableData(a, updates)
+	if err != nil {
+		return err
+	}
 	return UpdateArangoObject(ctx, a, updates)
 }
 
-func (a *Argument) version(ctx *ServerContext, updates Updates) Error {
+func (a *Argument) version(ctx *ServerContext, updates Updates) (err Error) {
+	if err = ctx.StartTransaction(VERSIONING_COLLECTIONS...); err != nil {
+		return err
+	}
+	defer func() { err = ctx.EndTransaction(err) }()
+
 	oldVersion := *a
 
 	// Don't use the standard Delete method because it deletes arguments, too
@@ -204,6 +234,14 @@ func (a *Argument) version(ctx *ServerContext, updates Updates) Error {
 		return err
 	}
 
+	// Moves are notified separately by MoveTo
+	if !isMove(updates) {
+		if err := NotifyNewVersion(ctx, a, oldVersion.ArangoKey()); err != nil {
+			ctx.Rollback()
+			return err
+		}
+	}
+
 	// Find all edges going to old ver, make copy to new ver
 	// The Inference edge is created during the Create method
 	inference, err := oldVersion.Inference(ctx)
@@ -280,7 +318,7 @@ func (a *Argument) version(ctx *ServerContext, updates Updates) Error {
 		}
 	}
 
-	if err := a.UpdateScore(ctx); err != nil {
+	if err := ScheduleScoreUpdate(ctx, a); err != nil {
 		ctx.Rollback()
 		return err
 	}
@@ -368,15 +406,27 @@ func (a Argument) UserCanCreate(ctx *ServerContext) (bool, Error) {
 }
 
 func (a Argument) UserCanUpdate(ctx *ServerContext, updates Updates) (bool, Error) {
-	return a.UserCanDelete(ctx)
+	if a.createdBy(ctx.UserContext) {
+		return true, nil
+	}
+	// Moving an argument to a different target is a separate permission from editing it
+	_, movingToClaim := updates["targetClaimId"]
+	_, movingToArg := updates["targetArgId"]
+	if movingToClaim || movingToArg {
+		return ctx.UserContext.Can(ctx, PERMISSION_ARGUMENT_MOVE)
+	}
+	return ctx.UserContext.Can(ctx, PERMISSION_ARGUMENT_EDIT_ANY)
 }
 
 func (a Argument) UserCanDelete(ctx *ServerContext) (bool, Error) {
-	u := ctx.UserContext
-	if u.Curator {
+	if a.createdBy(ctx.UserContext) {
 		return true, nil
 	}
-	return a.CreatedByID == u.ArangoID(), nil
+	return ctx.UserContext.Can(ctx, PERMISSION_ARGUMENT_DELETE_ANY)
+}
+
+func (a Argument) createdBy(u User) bool {
+	return u.ArangoKey() != "" && a.CreatedByID == u.ArangoID()
 }
 
 // Validator
@@ -478,6 +528,14 @@ func (a *Argument) LoadFull(ctx *ServerContext) Error {
 		bc.QueryAt = nil
 		arg.Claim = &bc
 
+		if a.QueryAt != nil {
+			arg.QueryAt = a.QueryAt
+			if err := arg.loadScoresAt(ctx); err != nil {
+				return err
+			}
+			arg.QueryAt = nil
+		}
+
 		if arg.Pro {
 			proArgs = append(proArgs, arg)
 		} else {
@@ -497,9 +555,129 @@ func (a *Argument) LoadFull(ctx *ServerContext) Error {
 	baseClaim.QueryAt = nil
 	a.Claim = &baseClaim
 
+	// The cached scores are only valid for the current state of the debate
+	if a.QueryAt != nil {
+		if err := a.loadScoresAt(ctx); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Versions
+
+// The fields that are compared when building the version history of an Argument
+var ARGUMENT_VERSIONED_FIELDS = []string{"title", "negation", "question", "desc", "note", "pro", "claimId", "targetClaimId", "targetArgId"}
+
+// Returns every version of this Argument, from oldest to newest
+func (a Argument) Versions(ctx *ServerContext) ([]Argument, Error) {
+	versions := []Argument{}
+	bindVars := BindVars{
+		"id": a.ID,
+	}
+	err := FindArangoObjects(ctx, VersionsQuery(&a), bindVars, &versions)
+	return versions, err
+}
+
+func (a Argument) VersionHistory(ctx *ServerContext) ([]Version, Error) {
+	versions, err := a.Versions(ctx)
+	if err != nil {
+		return []Version{}, err
+	}
+	return BuildVersionHistory(versions, ARGUMENT_VERSIONED_FIELDS)
+}
+
+// Charts the relevance of this Argument over time, one point per bucket between from and to,
+// or over the most recent buckets if they aren't given
+func (a Argument) ScoreHistory(ctx *ServerContext, bucket string, from, to *time.Time) ([]ScorePoint, Error) {
+	versions, err := a.Versions(ctx)
+	if err != nil {
+		return []ScorePoint{}, err
+	}
+	if len(versions) == 0 {
+		return []ScorePoint{}, NewNotFoundError("Not Found")
+	}
+
+	return chartScoreHistory(ctx, a.CollectionName(), a.ID, versions[0].CreatedAt, bucket, from, to, DEFAULT_ARGUMENT_SCORE)
+}
+
+// Compares two versions of this Argument, identified by their keys
+func (a Argument) DiffVersions(ctx *ServerContext, fromKey, toKey string) (VersionDiff, Error) {
+	diff := VersionDiff{
+		FromKey: fromKey,
+		ToKey:   toKey,
+	}
+
+	from, err := a.loadVersion(ctx, fromKey)
+	if err != nil {
+		return diff, err
+	}
+	to, err := a.loadVersion(ctx, toKey)
+	if err != nil {
+		return diff, err
+	}
+
+	diff.Fields = FieldChanges(from, to, ARGUMENT_VERSIONED_FIELDS)
+
+	fromArgs, err := from.Arguments(ctx)
+	if err != nil {
+		return diff, err
+	}
+	toArgs, err := to.Arguments(ctx)
+	if err != nil {
+		return diff, err
+	}
+	diff.Inferences = DiffIDs(argumentIDs(fromArgs), argumentIDs(toArgs))
+
+	return diff, nil
+}
+
+// Creates a new version of this Argument whose fields match those of an earlier version
+// The Argument stays attached to its current target; use MoveTo to change that
+func (a *Argument) Revert(ctx *ServerContext, key string) Error {
+	if a.ArangoKey() == key {
+		return NewBusinessError("This is already the current version")
+	}
+
+	version, err := a.loadVersion(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	updates := Updates{
+		"title":    version.Title,
+		"negation": version.Negation,
+		"question": version.Question,
+		"desc":     version.Description,
+		"note":     version.Note,
+		"pro":      version.Pro,
+	}
+
+	return a.Update(ctx, updates)
+}
+
+// Loads a specific version of this Argument by its key
+func (a Argument) loadVersion(ctx *ServerContext, key string) (Argument, Error) {
+	version := Argument{}
+	version.Key = key
+	if err := version.Load(ctx); err != nil {
+		return version, err
+	}
+	if version.ID != a.ID {
+		return version, NewNotFoundError("not found")
+	}
+	return version, nil
+}
+
+func argumentIDs(args []Argument) []string {
+	ids := make([]string, len(args))
+	for i, arg := range args {
+		ids[i] = arg.ID
+	}
+	return ids
+}
+
 // Scorer
 
 func (a *Argument) Score(ctx *ServerContext) (float32, Error) {
@@ -509,8 +687,19 @@ func (a *Argument) Score(ctx *ServerContext) (float32, Error) {
 	return a.scoreAt(ctx)
 }
 
+// Recalculates and stores the scores of this Argument,
+// then of the Claim or Argument that it targets
 func (a *Argument) UpdateScore(ctx *ServerContext) Error {
+	return a.updateScore(ctx, scoreCascade{})
+}
+
+func (a *Argument) updateScore(ctx *ServerContext, cascade scoreCascade) Error {
 	// TODO: not on deleted - validate for update
+	if cascade.visit(a) {
+		return nil
+	}
+	defer cascade.leave(a)
+
 	a.QueryAt = nil
 	score, err := a.scoreAt(ctx)
 	if err != nil {
@@ -528,9 +717,24 @@ func (a *Argument) UpdateScore(ctx *ServerContext) Error {
 	}
 
 	strength := score * truth
+	a.Relevance = score
+	a.Str = strength
+
+	rollUp, err := a.rollUpAt(ctx, newRollUpState())
+	if err != nil {
+		return err
+	}
+
+	dist, err := a.distributionAt(ctx)
+	if err != nil {
+		return err
+	}
+
 	updates := Updates{
-		"relevance": score,
-		"strength":  strength,
+		"relevance":    score,
+		"strength":     strength,
+		"strengthRU":   rollUp,
+		"distribution": dist,
 	}
 
 	col, grr := ctx.Arango.CollectionFor(a)
@@ -541,54 +745,51 @@ func (a *Argument) UpdateScore(ctx *ServerContext) Error {
 		return NewServerError(err.Error())
 	}
 
-	a.Relevance = score
-	a.Str = strength
-	return nil
-}
+	a.StrengthRU = rollUp
+	a.Distribution = dist
 
-func (a *Argument) scoreAt(ctx *ServerContext) (float32, Error) {
-	var score float32
-	results := map[string]interface{}{}
+	return a.cascadeScore(ctx, cascade)
+}
 
-	bindVars := BindVars{
-		"argument": a.ID,
+// The roll-up score of the target depends on the strength of this Argument
+func (a *Argument) cascadeScore(ctx *ServerContext, cascade scoreCascade) Error {
+	if a.TargetClaimID != nil {
+		target := Claim{}
+		target.ID = *a.TargetClaimID
+		if err := target.Load(ctx); err != nil {
+			return err
+		}
+		return target.updateScore(ctx, cascade)
+	} else if a.TargetArgumentID != nil {
+		target := Argument{}
+		target.ID = *a.TargetArgumentID
+		if err := target.Load(ctx); err != nil {
+			return err
+		}
+		return target.updateScore(ctx, cascade)
 	}
-	query := fmt.Sprintf(`FOR obj IN %s 
-                                 FOR a IN %s
-                                   FILTER obj._to == a._id
-                                      AND a.id == @argument
-                                   %s
-                                   COLLECT
-                                   AGGREGATE 
-                                     num = COUNT(obj),
-                                     score = AVG(obj.score)
-                                   RETURN { num, score }`,
-		UserScore{}.CollectionName(),
-		a.CollectionName(),
-		a.DateFilter(bindVars))
+	return nil
+}
 
-	db := ctx.Arango.DB
-	cursor, err := db.Query(ctx.Context, query, bindVars)
-	defer CloseCursor(cursor)
+func (a *Argument) scoreAt(ctx *ServerContext) (float32, Error) {
+	bindVars := BindVars{}
+	score, ok, err := AggregateVotes(ctx, SCORE_AGGREGATOR, a.CollectionName(), a.ID, a.DateFilter(bindVars), bindVars)
 	if err != nil {
-		return score, NewServerError(err.Error())
+		return 0.0, err
 	}
-	_, err = cursor.ReadDocument(ctx.Context, &results)
-	if err != nil {
-		return score, NewServerError(err.Error())
+	if !ok {
+		score = DEFAULT_ARGUMENT_SCORE
 	}
+	return score, nil
+}
 
-	if val, ok := results["score"].(float64); ok {
-		score = float32(val)
-	}
-	if score == 0.0 {
-		if count, ok := results["num"].(float64); ok {
-			if count == 0.0 {
-				score = DEFAULT_ARGUMENT_SCORE
-			}
-		}
+func (a *Argument) distributionAt(ctx *ServerContext) (ScoreDistribution, Error) {
+	bindVars := BindVars{}
+	votes, err := Votes(ctx, a.CollectionName(), a.ID, a.DateFilter(bindVars), bindVars)
+	if err != nil {
+		return ScoreDistribution{}, err
 	}
-	return score, nil
+	return NewScoreDistribution(votes), nil
 }
 
 func (a *Argument) Strength(ctx *ServerContext) (float32, Error) {
@@ -603,9 +804,11 @@ func (a *Argument) Strength(ctx *ServerContext) (float32, Error) {
 
 	claim := Claim{}
 	claim.ID = a.ClaimID
+	claim.QueryAt = a.QueryAt
 	if err := claim.Load(ctx); err != nil {
 		return 0.0, err
 	}
+	claim.QueryAt = a.QueryAt
 	truth, err := claim.Score(ctx)
 	if err != nil {
 		return 0.0, err
@@ -614,6 +817,91 @@ func (a *Argument) Strength(ctx *ServerContext) (float32, Error) {
 	return relevance * truth, nil
 }
 
+// Returns the roll-up strength, which combines the Relevance (rolled up through the
+// relevance arguments made about this Argument) with the roll-up truth of its Claim
+func (a *Argument) RollUp(ctx *ServerContext) (float32, Error) {
+	if a.QueryAt == nil {
+		return a.StrengthRU, nil
+	}
+	return a.rollUpAt(ctx, newRollUpState())
+}
+
+func (a *Argument) rollUpAt(ctx *ServerContext, state *rollUpState) (float32, Error) {
+	relevance, voted := state.votes[a.ID]
+	if !voted {
+		var err Error
+		if relevance, err = a.Score(ctx); err != nil {
+			return 0.0, err
+		}
+	}
+
+	args, err := a.Arguments(ctx)
+	if err != nil {
+		return 0.0, err
+	}
+
+	pro := []float32{}
+	con := []float32{}
+	for _, arg := range args {
+		arg.QueryAt = a.QueryAt
+		strength := arg.StrengthRU
+		if !state.useStored(a.QueryAt) {
+			if strength, err = arg.rollUpAt(ctx, state); err != nil {
+				return 0.0, err
+			}
+		}
+		if arg.Pro {
+			pro = append(pro, strength)
+		} else {
+			con = append(con, strength)
+		}
+	}
+	relevance = RollUpScore(relevance, pro, con)
+
+	claim := Claim{}
+	claim.ID = a.ClaimID
+	claim.QueryAt = a.QueryAt
+	if err := claim.Load(ctx); err != nil {
+		return 0.0, err
+	}
+	claim.QueryAt = a.QueryAt
+	truth := claim.TruthRU
+	if !state.useStored(a.QueryAt) {
+		if truth, err = claim.rollUpAt(ctx, state); err != nil {
+			return 0.0, err
+		}
+	}
+
+	return relevance * truth, nil
+}
+
+// Replaces the cached relevance, strength and roll-up strength with the values
+// calculated from the opinions that were active at QueryAt
+func (a *Argument) loadScoresAt(ctx *ServerContext) Error {
+	relevance, err := a.Score(ctx)
+	if err != nil {
+		return err
+	}
+	strength, err := a.Strength(ctx)
+	if err != nil {
+		return err
+	}
+	rollUp, err := a.RollUp(ctx)
+	if err != nil {
+		return err
+	}
+	a.Relevance = relevance
+	a.Str = strength
+	a.StrengthRU = rollUp
+
+	dist, err := a.distributionAt(ctx)
+	if err != nil {
+		return err
+	}
+	a.Distribution = dist
+	return nil
+}
+
 func (a Argument) UserScores(ctx *ServerContext) ([]UserScore, Error) {
 	edges := []UserScore{}
 
@@ -743,7 +1031,12 @@ func (a *Argument) LoadTarget(ctx *ServerContext) Error {
 // Curation
 
 // TODO: Test
-func (a *Argument) MoveTo(ctx *ServerContext, target ArangoObject, pro bool) Error {
+func (a *Argument) MoveTo(ctx *ServerContext, target ArangoObject, pro bool) (err Error) {
+	if err = ctx.StartTransaction(VERSIONING_COLLECTIONS...); err != nil {
+		return err
+	}
+	defer func() { err = ctx.EndTransaction(err) }()
+
 	// Create a new version with the new target id
 	updates := Updates{
 		"pro": pro,
@@ -781,6 +1074,13 @@ func (a *Argument) MoveTo(ctx *ServerContext, target ArangoObject, pro bool) Err
 		return NewServerError("Target must be either a claim or another argument")
 	}
 
+	oldTargetID, oldTargetType := a.targetID()
+	children, err := a.Arguments(ctx)
+	if err != nil {
+		ctx.Rollback()
+		return err
+	}
+
 	if err := a.Update(ctx, updates); err != nil {
 		ctx.Rollback()
 		return err
@@ -800,12 +1100,45 @@ func (a *Argument) MoveTo(ctx *ServerContext, target ArangoObject, pro bool) Err
 		return err
 	}
 
+	if err := NotifyArgumentMoved(ctx, *a, oldTargetID, oldTargetType); err != nil {
+		ctx.Rollback()
+		return err
+	}
+	for _, child := range children {
+		if err := NotifyParentArgumentMoved(ctx, child, a.ID, oldTargetID, oldTargetType); err != nil {
+			ctx.Rollback()
+			return err
+		}
+	}
+	if err := NotifyNewArgument(ctx, target, *a); err != nil {
+		ctx.Rollback()
+		return err
+	}
+
 	// TODO: Handle/invalidate scores
 	// TODO: re-evalute relevance of arguments
 
 	return nil
 }
 
+// Returns true if the updates move an argument to a different claim or argument
+func isMove(updates Updates) bool {
+	_, toClaim := updates["targetClaimId"]
+	_, toArg := updates["targetArgId"]
+	return toClaim || toArg
+}
+
+// Returns the ID and object type of the claim or argument this argument is for or against
+func (a Argument) targetID() (string, int) {
+	if a.TargetClaimID != nil {
+		return *a.TargetClaimID, OBJECT_TYPE_CLAIM
+	}
+	if a.TargetArgumentID != nil {
+		return *a.TargetArgumentID, OBJECT_TYPE_ARGUMENT
+	}
+	return "", 0
+}
+
 // Scopes
 
 func OrderByBestArgument(db *gorm.DB) *gorm.DB {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/GruffDebate/server/support"
 	arango "github.com/arangodb/go-driver"
@@ -38,20 +39,23 @@ const DEFAULT_CLAIM_SCORE float32 = 0.50
 
 type Claim struct {
 	VersionedModel
-	Title         string     `json:"title" valid:"length(3|1000)"`
-	Negation      string     `json:"negation"`
-	Question      string     `json:"question"`
-	Description   string     `json:"desc" valid:"length(3|4000)"`
-	Note          string     `json:"note"`
-	Image         string     `json:"img,omitempty"`
-	MultiPremise  bool       `json:"mp"`
-	PremiseRule   int        `json:"mprule"`
-	Truth         float32    `json:"truth"` // Average score from direct opinions
-	PremiseClaims []Claim    `json:"premises,omitempty" transient:"true"`
-	ProArgs       []Argument `json:"proargs" transient:"true"`
-	ConArgs       []Argument `json:"conargs" transient:"true"`
-	Links         []Link     `json:"links,omitempty" transient:"true"`
-	ContextElems  []Context  `json:"contexts" transient:"true"`
+	Title         string            `json:"title" valid:"length(3|1000)"`
+	Negation      string            `json:"negation"`
+	Question      string            `json:"question"`
+	Description   string            `json:"desc" valid:"length(3|4000)"`
+	Note          string            `json:"note"`
+	Image         string            `json:"img,omitempty"`
+	MultiPremise  bool              `json:"mp"`
+	PremiseRule   int               `json:"mprule"`
+	Truth         float32           `json:"truth"`                    // Average score from direct opinions
+	TruthRU       float32           `json:"truthRU" settable:"false"` // Roll-up score, combining Truth with the strength of the arguments
+	Distribution  ScoreDistribution `json:"distribution" settable:"false"`
+	PremiseClaims []Claim           `json:"premises,omitempty" transient:"true"`
+	ProArgs       []Argument        `json:"proargs" transient:"true"`
+	ConArgs       []Argument        `json:"conargs" transient:"true"`
+	Links         []Link            `json:"links,omitempty" transient:"true"`
+	ContextElems  []Context         `json:"contexts" transient:"true"`
+	View          *UserView         `json:"myView,omitempty" transient:"true"`
 }
 
 // ArangoObject interface
@@ -86,6 +90,8 @@ func (c *Claim) Create(ctx *ServerContext) Error {
 	contexts := c.ContextElems
 
 	c.Truth = DEFAULT_CLAIM_SCORE
+	c.TruthRU = DEFAULT_CLAIM_SCORE
+	c.Distribution = NewScoreDistribution([]Vote{})
 
 	aerr := CreateArangoObject(ctx, c)
 	if aerr != nil {
@@ -93,6 +99,11 @@ func (c *Claim) Create(ctx *ServerContext) Error {
 		return aerr
 	}
 
+	if err := autoFollow(ctx, c); err != nil {
+		ctx.Rollback()
+		return err
+	}
+
 	if len(contexts) > 0 {
 		for _, context := range contexts {
 			err := context.Load(ctx)
@@ -112,11 +123,21 @@ func (c *Claim) Create(ctx *ServerContext) Error {
 	return nil
 }
 
+// Computed fields, such as the scores, are left out of the updates
 func (c *Claim) Update(ctx *ServerContext, updates Updates) Error {
+	updates, err := ClearUnsettableData(c, updates)
+	if err != nil {
+		return err
+	}
 	return UpdateArangoObject(ctx, c, updates)
 }
 
-func (c *Claim) version(ctx *ServerContext, updates Updates) Error {
+func (c *Claim) version(ctx *ServerContext, updates Updates) (err Error) {
+	if err = ctx.StartTransaction(VERSIONING_COLLECTIONS...); err != nil {
+		return err
+	}
+	defer func() { err = ctx.EndTransaction(err) }()
+
 	c.QueryAt = nil
 	oldVersion := *c
 
@@ -131,6 +152,11 @@ func (c *Claim) version(ctx *ServerContext, updates Updates) Error {
 		return err
 	}
 
+	if err := NotifyNewVersion(ctx, c, oldVersion.ArangoKey()); err != nil {
+		ctx.Rollback()
+		return err
+	}
+
 	// Find all edges going to old ver, make copy to new ver
 	if c.MultiPremise {
 		premiseEdges, err := oldVersion.PremiseEdges(ctx)
@@ -310,6 +336,13 @@ func (c *Claim) version(ctx *ServerContext, updates Updates) Error {
 		}
 	}
 
+	// The premise rule determines how the truth of a multi-premise claim is derived,
+	// and everything based on the old version now needs to point at the new scores
+	if err := ScheduleScoreUpdate(ctx, c); err != nil {
+		ctx.Rollback()
+		return err
+	}
+
 	return nil
 }
 
@@ -444,15 +477,21 @@ func (c Claim) UserCanCreate(ctx *ServerContext) (bool, Error) {
 }
 
 func (c Claim) UserCanUpdate(ctx *ServerContext, updates Updates) (bool, Error) {
-	return c.UserCanDelete(ctx)
+	if c.createdBy(ctx.UserContext) {
+		return true, nil
+	}
+	return ctx.UserContext.Can(ctx, PERMISSION_CLAIM_EDIT_ANY)
 }
 
 func (c Claim) UserCanDelete(ctx *ServerContext) (bool, Error) {
-	u := ctx.UserContext
-	if u.Curator {
+	if c.createdBy(ctx.UserContext) {
 		return true, nil
 	}
-	return c.CreatedByID == u.ArangoID(), nil
+	return ctx.UserContext.Can(ctx, PERMISSION_CLAIM_DELETE_ANY)
+}
+
+func (c Claim) createdBy(u User) bool {
+	return u.ArangoKey() != "" && c.CreatedByID == u.ArangoID()
 }
 
 // Validator
@@ -559,6 +598,14 @@ func (c *Claim) LoadFull(ctx *ServerContext) Error {
 			bc.QueryAt = nil
 			arg.Claim = &bc
 
+			if c.QueryAt != nil {
+				arg.QueryAt = c.QueryAt
+				if err := arg.loadScoresAt(ctx); err != nil {
+					return err
+				}
+				arg.QueryAt = nil
+			}
+
 			if arg.Pro {
 				proArgs = append(proArgs, arg)
 			} else {
@@ -570,6 +617,27 @@ func (c *Claim) LoadFull(ctx *ServerContext) Error {
 		c.ConArgs = conArgs
 	}
 
+	// The cached score is only valid for the current state of the debate
+	if c.QueryAt != nil {
+		truth, err := c.scoreAt(ctx)
+		if err != nil {
+			return err
+		}
+		c.Truth = truth
+
+		rollUp, err := c.rollUpAt(ctx, newRollUpState())
+		if err != nil {
+			return err
+		}
+		c.TruthRU = rollUp
+
+		dist, err := c.distributionAt(ctx)
+		if err != nil {
+			return err
+		}
+		c.Distribution = dist
+	}
+
 	contexts, err := c.Contexts(ctx)
 	if err != nil {
 		return err
@@ -581,6 +649,187 @@ func (c *Claim) LoadFull(ctx *ServerContext) Error {
 	return nil
 }
 
+// Versions
+
+// The fields that are compared when building the version history of a Claim
+var CLAIM_VERSIONED_FIELDS = []string{"title", "negation", "question", "desc", "note", "img", "mp", "mprule"}
+
+// Returns every version of this Claim, from oldest to newest
+func (c Claim) Versions(ctx *ServerContext) ([]Claim, Error) {
+	versions := []Claim{}
+	bindVars := BindVars{
+		"id": c.ID,
+	}
+	err := FindArangoObjects(ctx, VersionsQuery(&c), bindVars, &versions)
+	return versions, err
+}
+
+func (c Claim) VersionHistory(ctx *ServerContext) ([]Version, Error) {
+	versions, err := c.Versions(ctx)
+	if err != nil {
+		return []Version{}, err
+	}
+	return BuildVersionHistory(versions, CLAIM_VERSIONED_FIELDS)
+}
+
+// Charts the truth of this Claim over time, one point per bucket between from and to,
+// or over the most recent buckets if they aren't given.
+// The truth of a multi-premise Claim is derived from its premises as they were at the end of each bucket.
+func (c Claim) ScoreHistory(ctx *ServerContext, bucket string, from, to *time.Time) ([]ScorePoint, Error) {
+	versions, err := c.Versions(ctx)
+	if err != nil {
+		return []ScorePoint{}, err
+	}
+	if len(versions) == 0 {
+		return []ScorePoint{}, NewNotFoundError("Not Found")
+	}
+
+	history, err := chartScoreHistory(ctx, c.CollectionName(), c.ID, versions[0].CreatedAt, bucket, from, to, DEFAULT_CLAIM_SCORE)
+	if err != nil {
+		return history, err
+	}
+
+	for i, point := range history {
+		end := point.End
+		version := Claim{}
+		version.ID = c.ID
+		version.QueryAt = &end
+		if err := version.Load(ctx); err != nil {
+			if err.Code() == ERROR_CODE_NOT_FOUND {
+				continue
+			}
+			return history, err
+		}
+		version.QueryAt = &end
+		if version.MultiPremise && version.PremiseRule != PREMISE_RULE_NONE {
+			if history[i].Score, err = version.premiseScoreAt(ctx); err != nil {
+				return history, err
+			}
+		}
+	}
+
+	return history, nil
+}
+
+// Compares two versions of this Claim, identified by their keys
+func (c Claim) DiffVersions(ctx *ServerContext, fromKey, toKey string) (VersionDiff, Error) {
+	diff := VersionDiff{
+		FromKey: fromKey,
+		ToKey:   toKey,
+	}
+
+	from, err := c.loadVersion(ctx, fromKey)
+	if err != nil {
+		return diff, err
+	}
+	to, err := c.loadVersion(ctx, toKey)
+	if err != nil {
+		return diff, err
+	}
+
+	diff.Fields = FieldChanges(from, to, CLAIM_VERSIONED_FIELDS)
+
+	fromContexts, err := from.contextKeys(ctx)
+	if err != nil {
+		return diff, err
+	}
+	toContexts, err := to.contextKeys(ctx)
+	if err != nil {
+		return diff, err
+	}
+	diff.Contexts = DiffIDs(fromContexts, toContexts)
+
+	fromPremises, err := from.Premises(ctx)
+	if err != nil {
+		return diff, err
+	}
+	toPremises, err := to.Premises(ctx)
+	if err != nil {
+		return diff, err
+	}
+	diff.Premises = DiffIDs(claimIDs(fromPremises), claimIDs(toPremises))
+
+	fromArgs, err := from.Arguments(ctx)
+	if err != nil {
+		return diff, err
+	}
+	toArgs, err := to.Arguments(ctx)
+	if err != nil {
+		return diff, err
+	}
+	diff.Inferences = DiffIDs(argumentIDs(fromArgs), argumentIDs(toArgs))
+
+	return diff, nil
+}
+
+// Creates a new version of this Claim whose fields and contexts match those of an earlier version
+// Premises, arguments and user scores are carried forward from the current version
+func (c *Claim) Revert(ctx *ServerContext, key string) Error {
+	if c.ArangoKey() == key {
+		return NewBusinessError("This is already the current version")
+	}
+
+	version, err := c.loadVersion(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	updates := Updates{
+		"title":    version.Title,
+		"negation": version.Negation,
+		"question": version.Question,
+		"desc":     version.Description,
+		"note":     version.Note,
+		"img":      version.Image,
+		"mprule":   version.PremiseRule,
+	}
+
+	// Multi-premise claims inherit their contexts from their premises
+	if !c.MultiPremise {
+		contexts, err := version.Contexts(ctx)
+		if err != nil {
+			return err
+		}
+		updates["contexts"] = contexts
+	}
+
+	return c.Update(ctx, updates)
+}
+
+// Loads a specific version of this Claim by its key
+func (c Claim) loadVersion(ctx *ServerContext, key string) (Claim, Error) {
+	version := Claim{}
+	version.Key = key
+	if err := version.Load(ctx); err != nil {
+		return version, err
+	}
+	if version.ID != c.ID {
+		return version, NewNotFoundError("not found")
+	}
+	return version, nil
+}
+
+func (c Claim) contextKeys(ctx *ServerContext) ([]string, Error) {
+	keys := []string{}
+	edges, err := c.ContextEdges(ctx)
+	if err != nil {
+		return keys, err
+	}
+	prefix := Context{}.CollectionName() + "/"
+	for _, edge := range edges {
+		keys = append(keys, strings.TrimPrefix(edge.From, prefix))
+	}
+	return keys, nil
+}
+
+func claimIDs(claims []Claim) []string {
+	ids := make([]string, len(claims))
+	for i, claim := range claims {
+		ids[i] = claim.ID
+	}
+	return ids
+}
+
 // Arguments
 
 func (c Claim) Arguments(ctx *ServerContext) ([]Argument, Error) {
@@ -641,7 +890,12 @@ func (c Claim) Inferences(ctx *ServerContext) ([]Inference, Error) {
 
 // Premises
 
-func (c *Claim) AddPremise(ctx *ServerContext, premise *Claim) Error {
+func (c *Claim) AddPremise(ctx *ServerContext, premise *Claim) (err Error) {
+	if err = ctx.StartTransaction(VERSIONING_COLLECTIONS...); err != nil {
+		return err
+	}
+	defer func() { err = ctx.EndTransaction(err) }()
+
 	if premise == nil {
 		ctx.Rollback()
 		return NewServerError("Premise is nil")
@@ -721,6 +975,16 @@ func (c *Claim) AddPremise(ctx *ServerContext, premise *Claim) Error {
 		ctx.Rollback()
 		return err
 	}
+
+	if err := NotifyNewPremise(ctx, *c, *premise); err != nil {
+		ctx.Rollback()
+		return err
+	}
+
+	if err := ScheduleScoreUpdate(ctx, c); err != nil {
+		ctx.Rollback()
+		return err
+	}
 	return nil
 }
 
@@ -791,6 +1055,11 @@ func (c *Claim) RemovePremise(ctx *ServerContext, premiseId string) Error {
 		c.PremiseRule = PREMISE_RULE_NONE
 	}
 
+	if err := ScheduleScoreUpdate(ctx, c); err != nil {
+		ctx.Rollback()
+		return err
+	}
+
 	return nil
 }
 
@@ -918,6 +1187,11 @@ func (c Claim) ReorderPremise(ctx *ServerContext, premise Claim, new int) ([]Cla
 		}
 	}
 
+	if err := ScheduleScoreUpdate(ctx, &c); err != nil {
+		ctx.Rollback()
+		return premises, err
+	}
+
 	premises, err = c.Premises(ctx)
 	if err != nil {
 		ctx.Rollback()
@@ -988,6 +1262,27 @@ func (c Claim) EdgesToThisPremise(ctx *ServerContext) ([]PremiseEdge, Error) {
 
 // Arguments that use this Claim
 
+// Returns the multi-premise Claims that use this Claim as one of their premises
+func (c Claim) ClaimsUsingThisPremise(ctx *ServerContext) ([]Claim, Error) {
+	claims := []Claim{}
+
+	bindVars := BindVars{
+		"to": c.ArangoID(),
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                                 FOR c IN %s
+                                   FILTER obj._from == c._id
+                                      AND obj._to == @to
+                                   %s
+                                   RETURN c`,
+		PremiseEdge{}.CollectionName(),
+		Claim{}.CollectionName(),
+		c.DateFilter(bindVars),
+	)
+	err := FindArangoObjects(ctx, query, bindVars, &claims)
+	return claims, err
+}
+
 func (c Claim) BaseClaimEdges(ctx *ServerContext) ([]BaseClaimEdge, Error) {
 	edges := []BaseClaimEdge{}
 
@@ -1069,6 +1364,11 @@ func (c *Claim) AddContext(ctx *ServerContext, context Context) Error {
 		ctx.Rollback()
 		return err
 	}
+
+	if err := NotifyNewContext(ctx, *c, context); err != nil {
+		ctx.Rollback()
+		return err
+	}
 	return nil
 }
 
@@ -1169,7 +1469,12 @@ func (c Claim) ContextEdges(ctx *ServerContext) ([]ContextEdge, Error) {
 // Curation
 
 // TODO: Test
-func (c *Claim) ConvertToMultiPremise(ctx *ServerContext) Error {
+func (c *Claim) ConvertToMultiPremise(ctx *ServerContext) (err Error) {
+	if err = ctx.StartTransaction(VERSIONING_COLLECTIONS...); err != nil {
+		return err
+	}
+	defer func() { err = ctx.EndTransaction(err) }()
+
 	if c.MultiPremise {
 		ctx.Rollback()
 		return NewBusinessError("This claim is already a multi-premise claim")
@@ -1258,16 +1563,41 @@ func (c *Claim) Score(ctx *ServerContext) (float32, Error) {
 	return c.scoreAt(ctx)
 }
 
+// Recalculates and stores the scores of this Claim,
+// then of every Claim and Argument whose score depends on it
 func (c *Claim) UpdateScore(ctx *ServerContext) Error {
+	return c.updateScore(ctx, scoreCascade{})
+}
+
+func (c *Claim) updateScore(ctx *ServerContext, cascade scoreCascade) Error {
 	// TODO: not on deleted - validate for update
+	if cascade.visit(c) {
+		return nil
+	}
+	defer cascade.leave(c)
+
 	c.QueryAt = nil
 	score, err := c.scoreAt(ctx)
 	if err != nil {
 		return err
 	}
 
+	c.Truth = score
+
+	rollUp, err := c.rollUpAt(ctx, newRollUpState())
+	if err != nil {
+		return err
+	}
+
+	dist, err := c.distributionAt(ctx)
+	if err != nil {
+		return err
+	}
+
 	updates := Updates{
-		"truth": score,
+		"truth":        score,
+		"truthRU":      rollUp,
+		"distribution": dist,
 	}
 
 	col, grr := ctx.Arango.CollectionFor(c)
@@ -1278,56 +1608,167 @@ func (c *Claim) UpdateScore(ctx *ServerContext) Error {
 		return NewServerError(err.Error())
 	}
 
-	c.Truth = score
+	c.TruthRU = rollUp
+	c.Distribution = dist
+
+	return c.cascadeScore(ctx, cascade)
+}
+
+func (c Claim) cascadeScore(ctx *ServerContext, cascade scoreCascade) Error {
+	// Multi-premise claims derive their truth from this one
+	parents, err := c.ClaimsUsingThisPremise(ctx)
+	if err != nil {
+		return err
+	}
+	for _, parent := range parents {
+		if err := parent.updateScore(ctx, cascade); err != nil {
+			return err
+		}
+	}
+
+	// Arguments derive their strength from this one
+	args, err := c.ArgumentsBasedOnThisClaim(ctx)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := arg.updateScore(ctx, cascade); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (c *Claim) scoreAt(ctx *ServerContext) (float32, Error) {
-	var score float32
-	results := map[string]interface{}{}
+// Returns the roll-up score, which combines the flat Truth score with the
+// roll-up strengths of the arguments for and against this Claim
+func (c *Claim) RollUp(ctx *ServerContext) (float32, Error) {
+	if c.QueryAt == nil {
+		return c.TruthRU, nil
+	}
+	return c.rollUpAt(ctx, newRollUpState())
+}
 
-	bindVars := BindVars{
-		"claim": c.ID,
+func (c *Claim) rollUpAt(ctx *ServerContext, state *rollUpState) (float32, Error) {
+	if score, ok := state.truths[c.ID]; ok {
+		return score, nil
 	}
-	query := fmt.Sprintf(`FOR obj IN %s 
-                                 FOR c IN %s
-                                   FILTER obj._to == c._id
-                                      AND c.id == @claim
-                                   %s
-                                   COLLECT
-                                   AGGREGATE 
-                                     num = COUNT(obj),
-                                     score = AVG(obj.score)
-                                   RETURN { num, score }`,
-		UserScore{}.CollectionName(),
-		c.CollectionName(),
-		c.DateFilter(bindVars))
 
-	db := ctx.Arango.DB
-	cursor, err := db.Query(ctx.Context, query, bindVars)
-	defer CloseCursor(cursor)
+	flat, err := c.flatScoreAt(ctx, state)
 	if err != nil {
-		return score, NewServerError(err.Error())
+		return flat, err
+	}
+	if state.visiting[c.ID] {
+		return flat, nil
 	}
-	_, err = cursor.ReadDocument(ctx.Context, &results)
+	state.visiting[c.ID] = true
+	defer delete(state.visiting, c.ID)
+
+	args, err := c.Arguments(ctx)
 	if err != nil {
-		return score, NewServerError(err.Error())
+		return flat, err
 	}
 
-	if val, ok := results["score"].(float64); ok {
-		score = float32(val)
+	pro := []float32{}
+	con := []float32{}
+	for _, arg := range args {
+		arg.QueryAt = c.QueryAt
+		strength := arg.StrengthRU
+		if !state.useStored(c.QueryAt) {
+			if strength, err = arg.rollUpAt(ctx, state); err != nil {
+				return flat, err
+			}
+		}
+		if arg.Pro {
+			pro = append(pro, strength)
+		} else {
+			con = append(con, strength)
+		}
 	}
-	if score == 0.0 {
-		if count, ok := results["num"].(float64); ok {
-			if count == 0 {
-				score = DEFAULT_CLAIM_SCORE
+
+	if implied, total := ImpliedScore(pro, con); total > 0.0 {
+		state.implied[c.ID] = implied
+	}
+
+	score := RollUpScore(flat, pro, con)
+	state.truths[c.ID] = score
+	return score, nil
+}
+
+// Returns the truth of this Claim before its arguments are rolled up. When the roll-up is made from
+// a user's point of view, that is their own vote, or for a multi-premise Claim, the premise rule
+// applied to their view of its premises.
+func (c *Claim) flatScoreAt(ctx *ServerContext, state *rollUpState) (float32, Error) {
+	if state.votes != nil && c.MultiPremise && c.PremiseRule != PREMISE_RULE_NONE {
+		premises, err := c.Premises(ctx)
+		if err != nil {
+			return 0.0, err
+		}
+
+		scores := []float32{}
+		for _, premise := range premises {
+			premise.QueryAt = c.QueryAt
+			score, err := premise.flatScoreAt(ctx, state)
+			if err != nil {
+				return 0.0, err
 			}
+			scores = append(scores, score)
 		}
+		return PremiseRuleScore(c.PremiseRule, scores), nil
+	}
+
+	if vote, ok := state.votes[c.ID]; ok {
+		return vote, nil
+	}
+	return c.Score(ctx)
+}
+
+func (c *Claim) scoreAt(ctx *ServerContext) (float32, Error) {
+	if c.MultiPremise && c.PremiseRule != PREMISE_RULE_NONE {
+		return c.premiseScoreAt(ctx)
 	}
 
+	bindVars := BindVars{}
+	score, ok, err := AggregateVotes(ctx, SCORE_AGGREGATOR, c.CollectionName(), c.ID, c.DateFilter(bindVars), bindVars)
+	if err != nil {
+		return 0.0, err
+	}
+	if !ok {
+		score = DEFAULT_CLAIM_SCORE
+	}
 	return score, nil
 }
 
+// Summarizes the direct votes on this Claim, even if its truth is derived from its premises
+func (c *Claim) distributionAt(ctx *ServerContext) (ScoreDistribution, Error) {
+	bindVars := BindVars{}
+	votes, err := Votes(ctx, c.CollectionName(), c.ID, c.DateFilter(bindVars), bindVars)
+	if err != nil {
+		return ScoreDistribution{}, err
+	}
+	return NewScoreDistribution(votes), nil
+}
+
+// The truth of a multi-premise Claim is derived from the truth of its premises, according to its premise rule
+func (c *Claim) premiseScoreAt(ctx *ServerContext) (float32, Error) {
+	premises, err := c.Premises(ctx)
+	if err != nil {
+		return 0.0, err
+	}
+
+	scores := []float32{}
+	for _, premise := range premises {
+		premise.QueryAt = c.QueryAt
+		score, err := premise.Score(ctx)
+		if err != nil {
+			return 0.0, err
+		}
+		scores = append(scores, score)
+	}
+
+	return PremiseRuleScore(c.PremiseRule, scores), nil
+}
+
 func (c Claim) UserScores(ctx *ServerContext) ([]UserScore, Error) {
 	edges := []UserScore{}
 
@@ -1375,22 +1816,36 @@ func (c Claim) HasCycle(ctx *ServerContext) (bool, Error) {
 // Queries
 
 // TODO: Obviously, this is going to have to be denormalized at some point
+// Lists the claims on which voters disagree the most, most controversial first
+func (c Claim) QueryForControversialClaims(params ArangoQueryParameters) string {
+	params = c.DefaultQueryParameters().Merge(params)
+	params.Sort = support.StringPtr("obj.distribution.controversy DESC, obj.start DESC")
+	query := fmt.Sprintf(`FOR obj IN claims
+                    FILTER obj.distribution.controversy > 0
+                       AND %s`,
+		params.ActiveFilter("obj"))
+	return params.Apply(query)
+}
+
 func (c Claim) QueryForTopLevelClaims(params ArangoQueryParameters) string {
 	params = c.DefaultQueryParameters().Merge(params)
-	query := `FOR obj IN claims 
+	query := fmt.Sprintf(`FOR obj IN claims 
                     LET bcCount=(FOR bc IN base_claims 
                                    FILTER bc._to == obj._id 
-                                      AND bc.end == null 
+                                      AND %s 
                                   COLLECT WITH COUNT INTO length 
                                    RETURN length) 
                     FILTER bcCount[0] == 0 
                     LET pCount=(FOR p IN premises
                                    FILTER p._to == obj._id 
-                                      AND p.end == null 
+                                      AND %s 
                                   COLLECT WITH COUNT INTO length 
                                    RETURN length) 
                     FILTER bcCount[0] == 0 
                     FILTER pCount[0] == 0 
-                    AND obj.end == null`
+                    AND %s`,
+		params.ActiveFilter("bc"),
+		params.ActiveFilter("p"),
+		params.ActiveFilter("obj"))
 	return params.Apply(query)
 }
@@ -978,6 +978,197 @@ func TestLoadClaimAtDate(t *testing.T) {
 	assert.Equal(t, thirdKey, lookup.ArangoKey())
 }
 
+func TestClaimVersionHistory(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{
+		Title:       "History is written by the editors",
+		Description: "Every change is remembered",
+	}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	firstKey := claim.ArangoKey()
+
+	err = claim.Update(CTX, Updates{"title": "History is rewritten by the editors"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	secondKey := claim.ArangoKey()
+
+	err = claim.Update(CTX, Updates{"negation": "History is forgotten", "note": "Noted"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	thirdKey := claim.ArangoKey()
+
+	history, err := claim.VersionHistory(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, firstKey, history[0].Key)
+	assert.Equal(t, secondKey, history[1].Key)
+	assert.Equal(t, thirdKey, history[2].Key)
+	assert.NotNil(t, history[0].DeletedAt)
+	assert.NotNil(t, history[1].DeletedAt)
+	assert.Nil(t, history[2].DeletedAt)
+	assert.Equal(t, []string{}, history[0].Changed)
+	assert.Equal(t, []string{"title"}, history[1].Changed)
+	assert.Equal(t, []string{"negation", "note"}, history[2].Changed)
+	assert.Equal(t, DEFAULT_USER.ArangoID(), history[2].UpdatedByID)
+
+	unknown := Claim{}
+	unknown.ID = "not a real id"
+	history, err = unknown.VersionHistory(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(history))
+}
+
+func TestClaimDiffVersions(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	context1 := Context{
+		ShortName: "DiffClaimContext1",
+		Title:     "Diff Claim Context 1",
+		URL:       "https://en.wikipedia.org/wiki/Diff",
+	}
+	err := context1.Create(CTX)
+	assert.NoError(t, err)
+
+	context2 := Context{
+		ShortName: "DiffClaimContext2",
+		Title:     "Diff Claim Context 2",
+		URL:       "https://en.wikipedia.org/wiki/Delta_encoding",
+	}
+	err = context2.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{
+		Title:        "Change can be measured",
+		Description:  "Compare before and after",
+		ContextElems: []Context{context1},
+	}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	firstKey := claim.ArangoKey()
+
+	err = claim.Update(CTX, Updates{"title": "Change can always be measured"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	secondKey := claim.ArangoKey()
+
+	err = claim.AddContext(CTX, context2)
+	assert.NoError(t, err)
+	err = claim.RemoveContext(CTX, context1.ArangoKey())
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	diff, err := claim.DiffVersions(CTX, firstKey, secondKey)
+	assert.NoError(t, err)
+	assert.Equal(t, firstKey, diff.FromKey)
+	assert.Equal(t, secondKey, diff.ToKey)
+	assert.Equal(t, []FieldChange{{Field: "title", From: "Change can be measured", To: "Change can always be measured"}}, diff.Fields)
+	assert.Equal(t, []string{}, diff.Contexts.Added)
+	assert.Equal(t, []string{}, diff.Contexts.Removed)
+	assert.Equal(t, []string{}, diff.Premises.Added)
+	assert.Equal(t, []string{}, diff.Inferences.Added)
+
+	// The context changes on the current version were made without creating a new version
+	diff, err = claim.DiffVersions(CTX, firstKey, claim.ArangoKey())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{context2.ArangoKey()}, diff.Contexts.Added)
+	assert.Equal(t, []string{context1.ArangoKey()}, diff.Contexts.Removed)
+
+	other := Claim{Title: "Some other claim"}
+	err = other.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	_, err = claim.DiffVersions(CTX, firstKey, other.ArangoKey())
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+}
+
+func TestClaimRevert(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	context1 := Context{
+		ShortName: "RevertClaimContext1",
+		Title:     "Revert Claim Context 1",
+		URL:       "https://en.wikipedia.org/wiki/Reversion_(software_development)",
+	}
+	err := context1.Create(CTX)
+	assert.NoError(t, err)
+
+	context2 := Context{
+		ShortName: "RevertClaimContext2",
+		Title:     "Revert Claim Context 2",
+		URL:       "https://en.wikipedia.org/wiki/Vandalism_on_Wikipedia",
+	}
+	err = context2.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{
+		Title:        "Vandalism can be undone",
+		Description:  "Every version is kept",
+		ContextElems: []Context{context1},
+	}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	originalKey := claim.ArangoKey()
+
+	arg := Argument{
+		TargetClaimID: &claim.ID,
+		Title:         "Nothing is ever really deleted",
+		Pro:           true,
+	}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = claim.Update(CTX, Updates{
+		"title":    "Vandals rule",
+		"desc":     "Nothing to see here",
+		"contexts": []Context{context2},
+	})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = claim.Revert(CTX, claim.ArangoKey())
+	assert.Error(t, err)
+
+	err = claim.Revert(CTX, originalKey)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	current := Claim{}
+	current.ID = claim.ID
+	err = current.LoadFull(CTX)
+	assert.NoError(t, err)
+	assert.NotEqual(t, originalKey, current.ArangoKey())
+	assert.Equal(t, "Vandalism can be undone", current.Title)
+	assert.Equal(t, "Every version is kept", current.Description)
+	assert.Equal(t, 1, len(current.ContextElems))
+	assert.Equal(t, context1.ArangoKey(), current.ContextElems[0].ArangoKey())
+	assert.Equal(t, 1, len(current.ProArgs))
+	assert.Equal(t, arg.ID, current.ProArgs[0].ID)
+
+	history, err := claim.VersionHistory(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(history))
+
+	other := Claim{Title: "Some other claim"}
+	err = other.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = current.Revert(CTX, other.ArangoKey())
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+}
+
 func TestClaimReorderPremise(t *testing.T) {
 	setupDB()
 	defer teardownDB()
@@ -1627,6 +1818,228 @@ func TestClaimLoadFull(t *testing.T) {
 	assert.Equal(t, 0, len(claim.ContextElems))
 }
 
+func TestClaimLoadFullAtDate(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{
+		Username: "TimeTraveler",
+	}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{
+		Title:       "What you saw then is not what you see now",
+		Description: "Scores change over time",
+	}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	arg := Argument{
+		TargetClaimID: &claim.ID,
+		Title:         "The past is a foreign country",
+		Pro:           true,
+	}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	beforeScores := time.Now()
+
+	err = u.Score(CTX, &claim, 0.80)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = u.Score(CTX, &arg, 0.40)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	current := Claim{}
+	current.ID = claim.ID
+	err = current.LoadFull(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0.80), current.Truth)
+	assert.Equal(t, 1, len(current.ProArgs))
+	assert.Equal(t, float32(0.40), current.ProArgs[0].Relevance)
+
+	past := Claim{}
+	past.ID = claim.ID
+	past.QueryAt = &beforeScores
+	err = past.LoadFull(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, claim.ArangoKey(), past.ArangoKey())
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, past.Truth)
+	assert.Equal(t, 1, len(past.ProArgs))
+	assert.Equal(t, DEFAULT_ARGUMENT_SCORE, past.ProArgs[0].Relevance)
+	assert.Equal(t, DEFAULT_ARGUMENT_SCORE*DEFAULT_CLAIM_SCORE, past.ProArgs[0].Str)
+
+	beforeClaim := claim.CreatedAt.Add(-1 * time.Hour)
+	past = Claim{}
+	past.ID = claim.ID
+	past.QueryAt = &beforeClaim
+	err = past.LoadFull(CTX)
+	assert.Error(t, err)
+	assert.Equal(t, "not found", err.Error())
+}
+
+func TestClaimRollUp(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{
+		Username: "RollingUp",
+	}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{Title: "The whole tree has something to say"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, claim.TruthRU)
+
+	arg := Argument{
+		TargetClaimID: &claim.ID,
+		Title:         "Every branch counts",
+		Pro:           true,
+	}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, DEFAULT_ARGUMENT_SCORE*DEFAULT_CLAIM_SCORE, arg.StrengthRU)
+
+	baseClaim := Claim{}
+	baseClaim.ID = arg.ClaimID
+	err = baseClaim.Load(CTX)
+	assert.NoError(t, err)
+
+	err = u.Score(CTX, &claim, 0.40)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = u.Score(CTX, &baseClaim, 1.00)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = arg.UpdateScore(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1.0), arg.StrengthRU)
+
+	err = claim.UpdateScore(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0.40), claim.Truth)
+	assert.InDelta(t, 0.70, claim.TruthRU, 0.0001)
+
+	// A relevance argument against the argument weakens it, and the claim with it
+	relArg := Argument{
+		TargetArgumentID: &arg.ID,
+		Title:            "Not every branch is relevant",
+		Pro:              false,
+	}
+	err = relArg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = arg.UpdateScore(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1.0), arg.Str)
+	assert.InDelta(t, 0.6667, arg.StrengthRU, 0.0001)
+
+	err = claim.UpdateScore(CTX)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.64, claim.TruthRU, 0.0001)
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.64, saved.TruthRU, 0.0001)
+	ru, err := saved.RollUp(CTX)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.64, ru, 0.0001)
+}
+
+func TestClaimScoreCascades(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{
+		Username: "Cascader",
+	}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{Title: "Scores flow uphill"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	arg := Argument{
+		TargetClaimID: &claim.ID,
+		Title:         "Every vote ripples upward",
+		Pro:           true,
+	}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	relArg := Argument{
+		TargetArgumentID: &arg.ID,
+		Title:            "Ripples fade with distance",
+		Pro:              false,
+	}
+	err = relArg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	relClaim := Claim{}
+	relClaim.ID = relArg.ClaimID
+	err = relClaim.Load(CTX)
+	assert.NoError(t, err)
+
+	err = u.Score(CTX, &relClaim, 1.00)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	savedRelArg := Argument{}
+	savedRelArg.ID = relArg.ID
+	err = savedRelArg.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1.0), savedRelArg.Str)
+	assert.Equal(t, float32(1.0), savedRelArg.StrengthRU)
+
+	savedArg := Argument{}
+	savedArg.ID = arg.ID
+	err = savedArg.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_ARGUMENT_SCORE*DEFAULT_CLAIM_SCORE, savedArg.Str)
+	assert.InDelta(t, 0.25, savedArg.StrengthRU, 0.0001)
+
+	savedClaim := Claim{}
+	savedClaim.ID = claim.ID
+	err = savedClaim.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, savedClaim.Truth)
+	assert.InDelta(t, 0.60, savedClaim.TruthRU, 0.0001)
+
+	// Scoring the base claim of an argument refreshes its cached strength
+	baseClaim := Claim{}
+	baseClaim.ID = arg.ClaimID
+	err = baseClaim.Load(CTX)
+	assert.NoError(t, err)
+
+	err = u.Score(CTX, &baseClaim, 0.20)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	savedArg = Argument{}
+	savedArg.ID = arg.ID
+	err = savedArg.Load(CTX)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.20, savedArg.Str, 0.0001)
+	assert.InDelta(t, 0.10, savedArg.StrengthRU, 0.0001)
+}
+
 func TestClaimLoadFullMP(t *testing.T) {
 	setupDB()
 	defer teardownDB()
@@ -1981,6 +2394,69 @@ func TestClaimDeleteLoop(t *testing.T) {
 	assert.Nil(t, distantClaim.DeletedAt)
 }
 
+func TestClaimMultiPremiseScore(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{
+		Username: "PremiseScorer",
+	}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{
+		Title:        "Both halves must hold",
+		MultiPremise: true,
+		PremiseRule:  PREMISE_RULE_ALL,
+	}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	premise1 := Claim{Title: "The first half holds"}
+	err = claim.AddPremise(CTX, &premise1)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, claim.Truth)
+
+	premise2 := Claim{Title: "The second half holds"}
+	err = claim.AddPremise(CTX, &premise2)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, DEFAULT_CLAIM_SCORE*DEFAULT_CLAIM_SCORE, claim.Truth)
+
+	// Scoring a premise rescores the claim
+	err = u.Score(CTX, &premise1, 0.80)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = u.Score(CTX, &premise2, 0.90)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.72, saved.Truth, 0.0001)
+
+	// Votes on the claim itself are ignored in favor of its premises
+	err = u.Score(CTX, &saved, 0.10)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.InDelta(t, 0.72, saved.Truth, 0.0001)
+
+	// Changing the rule rescores the claim
+	err = saved.Update(CTX, Updates{"mprule": PREMISE_RULE_ANY_TWO})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, float32(0.80), saved.Truth)
+
+	err = saved.RemovePremise(CTX, premise1.ID)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, float32(0.0), saved.Truth)
+}
+
 func TestClaimAddPremiseLoop(t *testing.T) {
 	setupDB()
 	defer teardownDB()
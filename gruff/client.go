@@ -0,0 +1,284 @@
+package gruff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+/*
+ * Every app that talks to the API (the web site, the mobile apps, bots) is a registered Client.
+ *
+ * Apps identify themselves by sending their client ID in the X-Gruff-Client header. Each client
+ * lists the origins that browsers may call the API from on its behalf, and how many requests
+ * per minute it may make.
+ *
+ * Clients are registered either in a file, loaded when the server starts, or in the database,
+ * where they can be changed while it is running. Clients saved in the database replace those
+ * with the same ID from the file. Since every request needs its client, they are all cached
+ * for CLIENT_CACHE_EXPIRATION, so changes can take that long to reach every server.
+ */
+
+const CLIENT_ORIGIN_ANY string = "*"
+const CLIENT_CACHE_EXPIRATION time.Duration = 1 * time.Minute
+
+type Client struct {
+	Key            string   `json:"_key"`
+	Name           string   `json:"name"`
+	AllowedOrigins []string `json:"origins"`
+	// Requests per minute, or 0 for no limit
+	RateLimit int `json:"rateLimit"`
+}
+
+var clientIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{1,63}$`)
+
+// The clients registered from a file when the server starts
+var REGISTERED_CLIENTS map[string]Client = map[string]Client{}
+
+// ArangoObject interface
+
+func (c Client) CollectionName() string {
+	return "clients"
+}
+
+func (c Client) ArangoKey() string {
+	return c.Key
+}
+
+func (c Client) ArangoID() string {
+	return fmt.Sprintf("%s/%s", c.CollectionName(), c.ArangoKey())
+}
+
+func (c Client) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+// Saves the client, replacing any earlier registration with the same ID
+func (c *Client) Create(ctx *ServerContext) Error {
+	c.PrepareForCreate(ctx)
+	if err := c.ValidateForCreate(); err != nil {
+		return err
+	}
+
+	bindVars := BindVars{
+		"key":       c.Key,
+		"name":      c.Name,
+		"origins":   c.AllowedOrigins,
+		"rateLimit": c.RateLimit,
+	}
+	query := fmt.Sprintf(`UPSERT { _key: @key }
+                               INSERT { _key: @key, name: @name, origins: @origins, rateLimit: @rateLimit }
+                               REPLACE { _key: @key, name: @name, origins: @origins, rateLimit: @rateLimit }
+                               IN %s`,
+		c.CollectionName())
+	if _, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars); err != nil {
+		return NewServerError(err.Error())
+	}
+	clearClientCache()
+	return nil
+}
+
+func (c *Client) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Clients are replaced, rather than modified")
+}
+
+// Removes the client from the database. Clients registered in a file can't be removed this way.
+func (c *Client) Delete(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(c)
+	if err != nil {
+		return err
+	}
+	if _, err := col.RemoveDocument(ctx.Context, c.ArangoKey()); err != nil {
+		if arango.IsNotFound(err) {
+			return NewNotFoundError("Not Found")
+		}
+		return NewServerError(err.Error())
+	}
+	clearClientCache()
+	return nil
+}
+
+func (c *Client) PrepareForCreate(ctx *ServerContext) {
+	if c.AllowedOrigins == nil {
+		c.AllowedOrigins = []string{}
+	}
+	for i, o := range c.AllowedOrigins {
+		c.AllowedOrigins[i] = strings.TrimRight(strings.ToLower(strings.TrimSpace(o)), "/")
+	}
+}
+
+func (c *Client) PrepareForDelete(ctx *ServerContext) {
+}
+
+// Validator
+
+func (c Client) ValidateForCreate() Error {
+	if !clientIDRegexp.MatchString(c.Key) {
+		return NewBusinessError("ID: must be 2 to 64 letters, digits, dots, dashes or underscores, starting with a letter or digit;")
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == CLIENT_ORIGIN_ANY {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return NewBusinessError(fmt.Sprintf("Origins: %q is not a valid origin, such as https://www.gruff.org;", o))
+		}
+	}
+	if c.RateLimit < 0 {
+		return NewBusinessError("Rate Limit: must not be negative;")
+	}
+	return nil
+}
+
+// Business methods
+
+// Returns true if browsers may call the API for this client from the given origin
+func (c Client) AllowsOrigin(origin string) bool {
+	origin = strings.TrimRight(strings.ToLower(origin), "/")
+	for _, o := range c.AllowedOrigins {
+		if o == CLIENT_ORIGIN_ANY || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the client may have users sent back to the given URI after signing in.
+// Unlike calls from browsers, this needs one of the client's own origins, rather than any origin.
+func (c Client) AllowsRedirect(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return false
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, o := range c.AllowedOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the clients listed in a JSON file, which holds an array of clients
+// such as [{"_key": "web", "name": "Web site", "origins": ["https://www.gruff.org"], "rateLimit": 600}]
+func ReadClientsFile(path string) ([]Client, Error) {
+	clients := []Client{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return clients, NewServerError(err.Error())
+	}
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return clients, NewServerError(fmt.Sprintf("Couldn't read the clients in %s: %s", path, err.Error()))
+	}
+	for i := range clients {
+		clients[i].PrepareForCreate(nil)
+		if err := clients[i].ValidateForCreate(); err != nil {
+			return clients, NewServerError(fmt.Sprintf("Client %q in %s is invalid: %s", clients[i].Key, path, err.Error()))
+		}
+	}
+	return clients, nil
+}
+
+func RegisterClient(c Client) {
+	REGISTERED_CLIENTS[c.Key] = c
+	clearClientCache()
+}
+
+var clientCache = struct {
+	sync.Mutex
+	clients  map[string]Client
+	loadedAt time.Time
+}{}
+
+func clearClientCache() {
+	clientCache.Lock()
+	defer clientCache.Unlock()
+	clientCache.clients = nil
+}
+
+// Returns every client, by ID
+func allClients(ctx *ServerContext) (map[string]Client, Error) {
+	clientCache.Lock()
+	defer clientCache.Unlock()
+
+	now := time.Now()
+	if clientCache.clients != nil && now.Sub(clientCache.loadedAt) < CLIENT_CACHE_EXPIRATION {
+		return clientCache.clients, nil
+	}
+
+	saved := []Client{}
+	query := fmt.Sprintf("FOR obj IN %s RETURN obj", Client{}.CollectionName())
+	if err := FindArangoObjects(ctx, query, BindVars{}, &saved); err != nil {
+		return nil, err
+	}
+
+	clients := map[string]Client{}
+	for id, c := range REGISTERED_CLIENTS {
+		clients[id] = c
+	}
+	for _, c := range saved {
+		clients[c.Key] = c
+	}
+
+	clientCache.clients = clients
+	clientCache.loadedAt = now
+	return clients, nil
+}
+
+func LoadClient(ctx *ServerContext, id string) (Client, Error) {
+	clients, err := allClients(ctx)
+	if err != nil {
+		return Client{}, err
+	}
+	c, ok := clients[id]
+	if !ok {
+		return c, NewNotFoundError(fmt.Sprintf("There is no client with the ID %s", id))
+	}
+	return c, nil
+}
+
+// Returns true once any client has been registered, whether in a file or in the database
+func HasClients(ctx *ServerContext) (bool, Error) {
+	clients, err := allClients(ctx)
+	if err != nil {
+		return false, err
+	}
+	return len(clients) > 0, nil
+}
+
+// Returns every client, whether registered in a file or in the database
+func ListClients(ctx *ServerContext) ([]Client, Error) {
+	list := []Client{}
+	clients, err := allClients(ctx)
+	if err != nil {
+		return list, err
+	}
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
+
+// Returns true if any client may be used from the given origin
+func OriginAllowed(ctx *ServerContext, origin string) (bool, Error) {
+	clients, err := allClients(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range clients {
+		if c.AllowsOrigin(origin) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
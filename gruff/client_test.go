@@ -0,0 +1,142 @@
+package gruff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientValidateForCreate(t *testing.T) {
+	c := Client{Key: "gruff-web", AllowedOrigins: []string{"https://www.gruff.org", "http://localhost:3000", "*"}, RateLimit: 600}
+	assert.NoError(t, c.ValidateForCreate())
+
+	c.Key = "gruff web"
+	assert.Error(t, c.ValidateForCreate())
+
+	c.Key = "gruff-web"
+	c.AllowedOrigins = []string{"https://www.gruff.org/claims"}
+	assert.Error(t, c.ValidateForCreate())
+	c.AllowedOrigins = []string{"www.gruff.org"}
+	assert.Error(t, c.ValidateForCreate())
+
+	c.AllowedOrigins = []string{}
+	c.RateLimit = -1
+	assert.Error(t, c.ValidateForCreate())
+}
+
+func TestClientAllowsOrigin(t *testing.T) {
+	c := Client{Key: "gruff-web", AllowedOrigins: []string{" https://www.Gruff.org/ "}}
+	c.PrepareForCreate(CTX)
+	assert.True(t, c.AllowsOrigin("https://www.gruff.org"))
+	assert.True(t, c.AllowsOrigin("HTTPS://WWW.GRUFF.ORG"))
+	assert.False(t, c.AllowsOrigin("http://www.gruff.org"))
+	assert.False(t, c.AllowsOrigin("https://www.gruff.org.evil.com"))
+
+	c.AllowedOrigins = []string{CLIENT_ORIGIN_ANY}
+	assert.True(t, c.AllowsOrigin("https://anywhere.com"))
+}
+
+func TestClientAllowsRedirect(t *testing.T) {
+	c := Client{Key: "gruff-web", AllowedOrigins: []string{"https://www.gruff.org"}}
+	c.PrepareForCreate(CTX)
+	assert.True(t, c.AllowsRedirect("https://www.gruff.org/auth/callback"))
+	assert.True(t, c.AllowsRedirect("https://WWW.gruff.org/auth/callback?from=home"))
+	assert.False(t, c.AllowsRedirect("https://www.gruff.org.evil.com/auth/callback"))
+	assert.False(t, c.AllowsRedirect("https://www.gruff.org@evil.com/auth/callback"))
+	assert.False(t, c.AllowsRedirect("javascript:alert(1)"))
+	assert.False(t, c.AllowsRedirect(""))
+
+	// Being callable from anywhere doesn't mean users can be sent anywhere
+	c.AllowedOrigins = []string{CLIENT_ORIGIN_ANY}
+	assert.False(t, c.AllowsRedirect("https://anywhere.com/auth/callback"))
+}
+
+func TestReadClientsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clients")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "clients.json")
+	ioutil.WriteFile(path, []byte(`[
+		{"_key": "gruff-web", "name": "Web site", "origins": ["https://www.gruff.org/"], "rateLimit": 600},
+		{"_key": "bot", "name": "Fact-checking bot"}
+	]`), 0600)
+
+	clients, gerr := ReadClientsFile(path)
+	assert.NoError(t, gerr)
+	assert.Len(t, clients, 2)
+	assert.Equal(t, []string{"https://www.gruff.org"}, clients[0].AllowedOrigins)
+	assert.Equal(t, 600, clients[0].RateLimit)
+	assert.Equal(t, []string{}, clients[1].AllowedOrigins)
+
+	ioutil.WriteFile(path, []byte(`[{"_key": "bot", "origins": ["not an origin"]}]`), 0600)
+	_, gerr = ReadClientsFile(path)
+	assert.Error(t, gerr)
+
+	_, gerr = ReadClientsFile(filepath.Join(dir, "missing.json"))
+	assert.Error(t, gerr)
+}
+
+func TestLoadClient(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	RegisterClient(Client{Key: "gruff-web", Name: "Web site", AllowedOrigins: []string{"https://www.gruff.org"}})
+	RegisterClient(Client{Key: "bot", Name: "Bot"})
+	defer func() {
+		delete(REGISTERED_CLIENTS, "gruff-web")
+		delete(REGISTERED_CLIENTS, "bot")
+		clearClientCache()
+	}()
+
+	c, err := LoadClient(CTX, "gruff-web")
+	assert.NoError(t, err)
+	assert.Equal(t, "Web site", c.Name)
+
+	_, err = LoadClient(CTX, "nobody")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+
+	// Saved clients replace those from the file
+	saved := Client{Key: "gruff-web", Name: "New web site", AllowedOrigins: []string{"https://new.gruff.org"}, RateLimit: 100}
+	err = saved.Create(CTX)
+	assert.NoError(t, err)
+	mobile := Client{Key: "mobile", Name: "Mobile app"}
+	err = mobile.Create(CTX)
+	assert.NoError(t, err)
+
+	c, err = LoadClient(CTX, "gruff-web")
+	assert.NoError(t, err)
+	assert.Equal(t, "New web site", c.Name)
+	assert.Equal(t, 100, c.RateLimit)
+
+	clients, err := ListClients(CTX)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 3)
+	assert.Equal(t, "bot", clients[0].Key)
+	assert.Equal(t, "gruff-web", clients[1].Key)
+	assert.Equal(t, "mobile", clients[2].Key)
+
+	allowed, err := OriginAllowed(CTX, "https://new.gruff.org")
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = OriginAllowed(CTX, "https://www.gruff.org")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	// Deleting the saved client brings back the one from the file
+	err = saved.Delete(CTX)
+	assert.NoError(t, err)
+	c, err = LoadClient(CTX, "gruff-web")
+	assert.NoError(t, err)
+	assert.Equal(t, "Web site", c.Name)
+
+	err = mobile.Delete(CTX)
+	assert.NoError(t, err)
+	err = mobile.Delete(CTX)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+}
@@ -133,12 +133,11 @@ func (c Context) UserCanCreate(ctx *ServerContext) (bool, Error) {
 }
 
 func (c Context) UserCanUpdate(ctx *ServerContext, updates Updates) (bool, Error) {
-	return c.UserCanDelete(ctx)
+	return ctx.UserContext.Can(ctx, PERMISSION_CONTEXT_EDIT)
 }
 
 func (c Context) UserCanDelete(ctx *ServerContext) (bool, Error) {
-	u := ctx.UserContext
-	return u.Curator, nil
+	return ctx.UserContext.Can(ctx, PERMISSION_CONTEXT_DELETE)
 }
 
 // Validator
@@ -0,0 +1,92 @@
+package gruff
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+)
+
+/*
+ * A user verifies their email address by following a link containing a signed token,
+ * which names both the user and the address it was sent to. Changing the address
+ * makes any outstanding links useless, and the new address has to be verified again.
+ */
+
+const EMAIL_VERIFICATION_EXPIRATION time.Duration = 48 * time.Hour
+
+// When true, users can't vote until they have verified their email address
+var REQUIRE_VERIFIED_VOTERS bool = false
+
+func (u User) Verified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// Emails the user a link with which to verify their email address
+func (u User) SendVerificationEmail(ctx *ServerContext) Error {
+	if u.Verified() {
+		return NewBusinessError("This email address has already been verified")
+	}
+
+	token, err := IssueJWTTokenForEmail(u.ArangoKey(), u.Email, JWT_PURPOSE_VERIFY_EMAIL, time.Now().Add(EMAIL_VERIFICATION_EXPIRATION))
+	if err != nil {
+		return NewServerError(err.Error())
+	}
+
+	link := fmt.Sprintf("%s/api/users/verify?token=%s", strings.TrimRight(os.Getenv("GRUFF_URL"), "/"), url.QueryEscape(token))
+	email := Email{
+		To:      u.Email,
+		Subject: fmt.Sprintf("Please verify your email address for %s", os.Getenv("GRUFF_NAME")),
+		Body: fmt.Sprintf("Hi %s,\n\nPlease verify your email address by following this link:\n\n%s\n\nThe link expires in %d hours.\n",
+			u.Name,
+			link,
+			int(EMAIL_VERIFICATION_EXPIRATION.Hours())),
+	}
+	return MAILER.Send(email)
+}
+
+// Marks the email address named in the token as verified, returning the user it belongs to
+func VerifyEmail(ctx *ServerContext, token string) (User, Error) {
+	u := User{}
+	invalid := NewBusinessError("This verification link is invalid or has expired", ERROR_SUBCODE_EMAIL_TOKEN_INVALID)
+
+	uid, email, verr := VerifyJWTTokenForEmail(token, JWT_PURPOSE_VERIFY_EMAIL)
+	if verr != nil {
+		return u, invalid
+	}
+
+	u.Key = uid
+	if err := u.Load(ctx); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return u, invalid
+		}
+		return u, err
+	}
+	if u.DeletedAt != nil || !strings.EqualFold(u.Email, email) {
+		return u, invalid
+	}
+	if u.Verified() {
+		return u, nil
+	}
+
+	col, err := ctx.Arango.CollectionFor(&u)
+	if err != nil {
+		return u, err
+	}
+	u.EmailVerifiedAt = support.TimePtr(ctx.RequestTime())
+	if _, err := col.UpdateDocument(ctx.Context, u.ArangoKey(), Updates{"verified": u.EmailVerifiedAt}); err != nil {
+		return u, NewServerError(err.Error())
+	}
+	return u, nil
+}
+
+// Returns an error if the user isn't allowed to vote yet
+func (u User) CanVote() Error {
+	if REQUIRE_VERIFIED_VOTERS && !u.Verified() {
+		return NewPermissionError("You must verify your email address before you can vote", ERROR_SUBCODE_EMAIL_UNVERIFIED)
+	}
+	return nil
+}
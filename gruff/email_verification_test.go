@@ -0,0 +1,170 @@
+package gruff
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingMailer struct {
+	sent []Email
+}
+
+func (m *recordingMailer) Send(email Email) Error {
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+func tokenFromEmail(email Email) string {
+	i := strings.Index(email.Body, "token=")
+	if i < 0 {
+		return ""
+	}
+	token := strings.Fields(email.Body[i+len("token="):])[0]
+	token, _ = url.QueryUnescape(token)
+	return token
+}
+
+func TestVerifyJWTTokenForEmail(t *testing.T) {
+	token, err := IssueJWTTokenForEmail("abc", "someone@gruff.org", JWT_PURPOSE_VERIFY_EMAIL, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	uid, email, err := VerifyJWTTokenForEmail(token, JWT_PURPOSE_VERIFY_EMAIL)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", uid)
+	assert.Equal(t, "someone@gruff.org", email)
+
+	_, _, err = VerifyJWTTokenForEmail(token, "reset")
+	assert.Error(t, err)
+
+	expired, err := IssueJWTTokenForEmail("abc", "someone@gruff.org", JWT_PURPOSE_VERIFY_EMAIL, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	_, _, err = VerifyJWTTokenForEmail(expired, JWT_PURPOSE_VERIFY_EMAIL)
+	assert.Error(t, err)
+
+	// Sign in tokens aren't email tokens
+	signin, err := IssueJWToken("abc", []string{}, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	_, _, err = VerifyJWTTokenForEmail(signin, JWT_PURPOSE_VERIFY_EMAIL)
+	assert.Error(t, err)
+}
+
+func TestLogMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	mailer := LogMailer{Path: path}
+	assert.NoError(t, mailer.Send(Email{To: "one@gruff.org", Subject: "First", Body: "Hello"}))
+	assert.NoError(t, mailer.Send(Email{To: "two@gruff.org", Subject: "Second", Body: "Again"}))
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "To: one@gruff.org\nSubject: First\n\nHello")
+	assert.Contains(t, string(content), "To: two@gruff.org\nSubject: Second\n\nAgain")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	mailer := &recordingMailer{}
+	MAILER = mailer
+	defer func() { MAILER = LogMailer{} }()
+
+	u := User{Name: "Verifier", Username: "Verifier", Email: "verifier@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.False(t, u.Verified())
+
+	err = u.SendVerificationEmail(CTX)
+	assert.NoError(t, err)
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "verifier@gruff.org", mailer.sent[0].To)
+	token := tokenFromEmail(mailer.sent[0])
+	assert.NotEmpty(t, token)
+
+	_, err = VerifyEmail(CTX, "not-a-token")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_EMAIL_TOKEN_INVALID, err.Subcode())
+	CTX.RequestAt = nil
+
+	verified, err := VerifyEmail(CTX, token)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, u.ArangoKey(), verified.ArangoKey())
+	assert.True(t, verified.Verified())
+
+	u.Load(CTX)
+	assert.True(t, u.Verified())
+
+	// Verifying twice is harmless
+	_, err = VerifyEmail(CTX, token)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = u.SendVerificationEmail(CTX)
+	assert.Error(t, err)
+
+	// A new address has to be verified again, and links sent to the old one stop working
+	err = u.Update(CTX, Updates{"name": "", "username": "", "email": "verifier2@gruff.org"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.False(t, u.Verified())
+	u.Load(CTX)
+	assert.Equal(t, "verifier2@gruff.org", u.Email)
+	assert.False(t, u.Verified())
+
+	_, err = VerifyEmail(CTX, token)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_EMAIL_TOKEN_INVALID, err.Subcode())
+	CTX.RequestAt = nil
+
+	// Users can't verify themselves
+	err = u.Update(CTX, Updates{"name": "", "username": "", "email": "", "verified": time.Now()})
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_PERMISSION_ERROR, err.Code())
+	CTX.RequestAt = nil
+}
+
+func TestScoreRequiresVerifiedEmail(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	REQUIRE_VERIFIED_VOTERS = true
+	defer func() { REQUIRE_VERIFIED_VOTERS = false }()
+
+	u := User{Name: "Unverified", Username: "Unverified", Email: "unverified@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	c := Claim{Title: "Verified voters only"}
+	err = c.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = u.Score(CTX, &c, 0.8)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_PERMISSION_ERROR, err.Code())
+	assert.Equal(t, ERROR_SUBCODE_EMAIL_UNVERIFIED, err.Subcode())
+	CTX.RequestAt = nil
+
+	score, err := u.ScoreFor(CTX, &c)
+	assert.NoError(t, err)
+	assert.Nil(t, score)
+	CTX.RequestAt = nil
+
+	u.EmailVerifiedAt = &u.CreatedAt
+	err = u.Score(CTX, &c, 0.8)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+}
@@ -21,6 +21,7 @@ const ERROR_CODE_BUSINESS_ERROR int = 400
 const ERROR_CODE_UNAUTHORIZED_ERROR int = 401
 const ERROR_CODE_PERMISSION_ERROR int = 403
 const ERROR_CODE_NOT_FOUND int = 404
+const ERROR_CODE_TOO_MANY_REQUESTS int = 429
 const ERROR_CODE_SERVER_ERROR int = 500
 
 const ERROR_SUBCODE_UNDEFINED_IGNORE int = -1000
@@ -34,6 +35,13 @@ const ERROR_SUBCODE_EMAIL_FORMAT int = -2006
 const ERROR_SUBCODE_PASSWORD_LENGTH int = -2007
 const ERROR_SUBCODE_PASSWORD_FORMAT int = -2008
 const ERROR_SUBCODE_CREDENTIALS_INVALID int = -2009
+const ERROR_SUBCODE_LOGIN_THROTTLED int = -2010
+const ERROR_SUBCODE_EMAIL_TOKEN_INVALID int = -2011
+const ERROR_SUBCODE_EMAIL_UNVERIFIED int = -2012
+const ERROR_SUBCODE_RESET_TOKEN_INVALID int = -2013
+const ERROR_SUBCODE_RATE_LIMITED int = -2014
+const ERROR_SUBCODE_USER_BANNED int = -2015
+const ERROR_SUBCODE_RESET_THROTTLED int = -2016
 
 type CoreError struct {
 	ErrCode     int
@@ -104,6 +112,10 @@ func NewNotFoundError(msg string, opts ...interface{}) Error {
 	return newElipsisError(ERROR_CODE_NOT_FOUND, msg, opts...)
 }
 
+func NewTooManyRequestsError(msg string, opts ...interface{}) Error {
+	return newElipsisError(ERROR_CODE_TOO_MANY_REQUESTS, msg, opts...)
+}
+
 func NewServerError(msg string, opts ...interface{}) Error {
 	if strings.Contains(msg, "uix_users_email") {
 		msg = "Email is already in use"
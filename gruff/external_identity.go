@@ -0,0 +1,577 @@
+package gruff
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+)
+
+/*
+ * An ExternalIdentity links a User to their account with an external identity provider.
+ *
+ * Signing in through a provider starts with an ExternalLogin, which remembers the state,
+ * nonce and PKCE verifier sent along with the user until the provider sends them back.
+ * Each ExternalLogin can only be finished once, and only for a few minutes.
+ *
+ * The user's browser is sent to the provider and back without the registered client that
+ * started the sign in, so the tokens can't be handed over directly. Instead, the browser is
+ * sent back to the client with a LoginCode, which only that client can exchange for tokens,
+ * only once, and only for LOGIN_CODE_EXPIRATION.
+ *
+ * The first time someone signs in with an identity, it is linked to:
+ *   - the user who started the sign in, if they were already signed in
+ *   - otherwise, the user with the same email address, if both the provider and gruff have verified it
+ *   - otherwise, a brand new user
+ */
+
+const EXTERNAL_LOGIN_EXPIRATION time.Duration = 10 * time.Minute
+const LOGIN_CODE_EXPIRATION time.Duration = 1 * time.Minute
+
+type ExternalIdentity struct {
+	Key       string    `json:"_key"`
+	UserKey   string    `json:"user"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"sub"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"start"`
+}
+
+// ArangoObject interface
+
+func (i ExternalIdentity) CollectionName() string {
+	return "external_identities"
+}
+
+func (i ExternalIdentity) ArangoKey() string {
+	return i.Key
+}
+
+func (i ExternalIdentity) ArangoID() string {
+	return fmt.Sprintf("%s/%s", i.CollectionName(), i.ArangoKey())
+}
+
+func (i ExternalIdentity) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (i *ExternalIdentity) Create(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(i)
+	if err != nil {
+		return err
+	}
+	i.PrepareForCreate(ctx)
+	if _, err := col.CreateDocument(ctx.Context, i); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (i *ExternalIdentity) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("External identities cannot be modified")
+}
+
+func (i *ExternalIdentity) Delete(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(i)
+	if err != nil {
+		return err
+	}
+	if _, err := col.RemoveDocument(ctx.Context, i.ArangoKey()); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (i *ExternalIdentity) PrepareForCreate(ctx *ServerContext) {
+	i.Key = externalIdentityKey(i.Provider, i.Subject)
+	i.CreatedAt = ctx.RequestTime()
+}
+
+func (i *ExternalIdentity) PrepareForDelete(ctx *ServerContext) {
+}
+
+// Subjects can contain any characters, so they are hashed to make a valid key
+func externalIdentityKey(provider, subject string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(provider+"\n"+subject)))
+}
+
+type ExternalLogin struct {
+	Key          string     `json:"_key"`
+	Provider     string     `json:"provider"`
+	Nonce        string     `json:"nonce"`
+	CodeVerifier string     `json:"verifier"`
+	UserKey      string     `json:"user,omitempty"`
+	ClientKey    string     `json:"client"`
+	RedirectURI  string     `json:"redirectUri"`
+	CreatedAt    time.Time  `json:"start"`
+	ExpiresAt    time.Time  `json:"expires"`
+	EndedAt      *time.Time `json:"end,omitempty"`
+}
+
+// ArangoObject interface
+
+func (l ExternalLogin) CollectionName() string {
+	return "external_logins"
+}
+
+func (l ExternalLogin) ArangoKey() string {
+	return l.Key
+}
+
+func (l ExternalLogin) ArangoID() string {
+	return fmt.Sprintf("%s/%s", l.CollectionName(), l.ArangoKey())
+}
+
+func (l ExternalLogin) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (l *ExternalLogin) Create(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(l)
+	if err != nil {
+		return err
+	}
+	l.PrepareForCreate(ctx)
+	if _, err := col.CreateDocument(ctx.Context, l); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (l *ExternalLogin) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("External logins cannot be modified")
+}
+
+func (l *ExternalLogin) Delete(ctx *ServerContext) Error {
+	l.PrepareForDelete(ctx)
+	col, err := ctx.Arango.CollectionFor(l)
+	if err != nil {
+		return err
+	}
+	if _, err := col.UpdateDocument(ctx.Context, l.ArangoKey(), Updates{"end": l.EndedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (l *ExternalLogin) PrepareForCreate(ctx *ServerContext) {
+	l.CreatedAt = ctx.RequestTime()
+	l.ExpiresAt = l.CreatedAt.Add(EXTERNAL_LOGIN_EXPIRATION)
+	l.EndedAt = nil
+}
+
+func (l *ExternalLogin) PrepareForDelete(ctx *ServerContext) {
+	l.EndedAt = support.TimePtr(ctx.RequestTime())
+}
+
+// Ends the login unless it has already been ended, returning true if this call ended it
+func (l *ExternalLogin) end(ctx *ServerContext) (bool, Error) {
+	l.PrepareForDelete(ctx)
+	bindVars := BindVars{
+		"key": l.Key,
+		"end": l.EndedAt,
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                               FILTER obj._key == @key
+                                  AND obj.end == null
+                               UPDATE obj WITH { end: @end } IN %s
+                               RETURN NEW._key`,
+		l.CollectionName(),
+		l.CollectionName())
+	cursor, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return false, NewServerError(err.Error())
+	}
+	return cursor.HasMore(), nil
+}
+
+// Returns the client's redirect URI with the given parameters added to its query
+func (l ExternalLogin) RedirectWith(params url.Values) string {
+	u, err := url.Parse(l.RedirectURI)
+	if err != nil {
+		return l.RedirectURI
+	}
+	query := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			query.Add(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+type LoginCode struct {
+	Key       string     `json:"_key"`
+	UserKey   string     `json:"user"`
+	ClientKey string     `json:"client"`
+	CreatedAt time.Time  `json:"start"`
+	ExpiresAt time.Time  `json:"expires"`
+	EndedAt   *time.Time `json:"end,omitempty"`
+}
+
+// ArangoObject interface
+
+func (lc LoginCode) CollectionName() string {
+	return "login_codes"
+}
+
+func (lc LoginCode) ArangoKey() string {
+	return lc.Key
+}
+
+func (lc LoginCode) ArangoID() string {
+	return fmt.Sprintf("%s/%s", lc.CollectionName(), lc.ArangoKey())
+}
+
+func (lc LoginCode) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (lc *LoginCode) Create(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(lc)
+	if err != nil {
+		return err
+	}
+	lc.PrepareForCreate(ctx)
+	if _, err := col.CreateDocument(ctx.Context, lc); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (lc *LoginCode) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Login codes cannot be modified")
+}
+
+func (lc *LoginCode) Delete(ctx *ServerContext) Error {
+	lc.PrepareForDelete(ctx)
+	col, err := ctx.Arango.CollectionFor(lc)
+	if err != nil {
+		return err
+	}
+	if _, err := col.UpdateDocument(ctx.Context, lc.ArangoKey(), Updates{"end": lc.EndedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (lc *LoginCode) PrepareForCreate(ctx *ServerContext) {
+	lc.CreatedAt = ctx.RequestTime()
+	lc.ExpiresAt = lc.CreatedAt.Add(LOGIN_CODE_EXPIRATION)
+	lc.EndedAt = nil
+}
+
+func (lc *LoginCode) PrepareForDelete(ctx *ServerContext) {
+	lc.EndedAt = support.TimePtr(ctx.RequestTime())
+}
+
+// Ends the code unless it has already been ended, returning true if this call ended it
+func (lc *LoginCode) end(ctx *ServerContext) (bool, Error) {
+	lc.PrepareForDelete(ctx)
+	bindVars := BindVars{
+		"key": lc.Key,
+		"end": lc.EndedAt,
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                               FILTER obj._key == @key
+                                  AND obj.end == null
+                               UPDATE obj WITH { end: @end } IN %s
+                               RETURN NEW._key`,
+		lc.CollectionName(),
+		lc.CollectionName())
+	cursor, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return false, NewServerError(err.Error())
+	}
+	return cursor.HasMore(), nil
+}
+
+// Business methods
+
+// Starts signing in through the named provider for the registered client, which will get
+// the user back at the redirect URI. Returns the URL to send the user to and the state
+// the provider will send back. If a user is already signed in, the identity will be linked to them.
+func StartExternalLogin(ctx *ServerContext, providerName, clientID, redirectURI string) (string, string, Error) {
+	provider, err := IdentityProviderNamed(providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	client, err := LoadClient(ctx, clientID)
+	if err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return "", "", NewBusinessError("Client: is not a registered client;")
+		}
+		return "", "", err
+	}
+	if !client.AllowsRedirect(redirectURI) {
+		return "", "", NewBusinessError("Redirect URI: must be on one of the client's origins;")
+	}
+
+	state, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		return "", "", err
+	}
+
+	login := ExternalLogin{
+		Key:          hashOpaqueToken(state),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserKey:      ctx.UserContext.ArangoKey(),
+		ClientKey:    client.Key,
+		RedirectURI:  redirectURI,
+	}
+	if err := login.Create(ctx); err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, challenge)
+	return authURL, state, err
+}
+
+// Finishes signing in through the named provider with the state and code it sent back,
+// returning the user linked to the identity it vouches for. The sign in is returned too,
+// once it is known, so that the user can be sent back to its client even if it failed.
+func FinishExternalLogin(ctx *ServerContext, providerName, state, code string) (User, ExternalLogin, Error) {
+	u := User{}
+	login := ExternalLogin{}
+	invalid := NewUnauthorizedError("This sign in is invalid or has expired")
+
+	provider, err := IdentityProviderNamed(providerName)
+	if err != nil {
+		return u, login, err
+	}
+	if state == "" {
+		return u, login, invalid
+	}
+
+	if err := LoadArangoObject(ctx, &login, hashOpaqueToken(state)); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return u, ExternalLogin{}, invalid
+		}
+		return u, ExternalLogin{}, err
+	}
+	if login.Provider != provider.Name() || login.EndedAt != nil || !login.ExpiresAt.After(ctx.RequestTime()) {
+		return u, ExternalLogin{}, invalid
+	}
+	ended, err := login.end(ctx)
+	if err != nil {
+		return u, ExternalLogin{}, err
+	}
+	if !ended {
+		return u, ExternalLogin{}, invalid
+	}
+	if code == "" {
+		return u, login, invalid
+	}
+
+	claims, err := provider.Exchange(code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		return u, login, err
+	}
+
+	u, err = userForExternalIdentity(ctx, provider.Name(), claims, login.UserKey)
+	if err != nil {
+		return u, login, err
+	}
+	return u, login, u.CheckBanned()
+}
+
+// Issues a code that the client which started the sign in can exchange for the user's tokens
+func IssueLoginCode(ctx *ServerContext, u User, login ExternalLogin) (string, Error) {
+	code, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	lc := LoginCode{
+		Key:       hashOpaqueToken(code),
+		UserKey:   u.ArangoKey(),
+		ClientKey: login.ClientKey,
+	}
+	if err := lc.Create(ctx); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Uses up the login code, returning the user who signed in, as long as the code was issued to the client
+func ExchangeLoginCode(ctx *ServerContext, code string, client Client) (User, Error) {
+	u := User{}
+	invalid := NewUnauthorizedError("This sign in is invalid or has expired")
+
+	if code == "" || client.Key == "" {
+		return u, invalid
+	}
+	lc := LoginCode{}
+	if err := LoadArangoObject(ctx, &lc, hashOpaqueToken(code)); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return u, invalid
+		}
+		return u, err
+	}
+	if lc.ClientKey != client.Key || lc.EndedAt != nil || !lc.ExpiresAt.After(ctx.RequestTime()) {
+		return u, invalid
+	}
+	ended, err := lc.end(ctx)
+	if err != nil {
+		return u, err
+	}
+	if !ended {
+		return u, invalid
+	}
+
+	u.Key = lc.UserKey
+	if err := u.Load(ctx); err != nil {
+		return u, err
+	}
+	if u.DeletedAt != nil {
+		return u, invalid
+	}
+	return u, u.CheckBanned()
+}
+
+func userForExternalIdentity(ctx *ServerContext, provider string, claims ExternalClaims, signedInUserKey string) (User, Error) {
+	u := User{}
+
+	identity := ExternalIdentity{}
+	err := LoadArangoObject(ctx, &identity, externalIdentityKey(provider, claims.Subject))
+	if err == nil {
+		if signedInUserKey != "" && signedInUserKey != identity.UserKey {
+			return u, NewBusinessError("This identity is already linked to a different account")
+		}
+		u.Key = identity.UserKey
+		if err := u.Load(ctx); err != nil {
+			return u, err
+		}
+		if u.DeletedAt != nil {
+			return u, NewUnauthorizedError("Unauthorized")
+		}
+		return u, nil
+	} else if err.Code() != ERROR_CODE_NOT_FOUND {
+		return u, err
+	}
+
+	switch {
+	case signedInUserKey != "":
+		u.Key = signedInUserKey
+		if err := u.Load(ctx); err != nil {
+			return u, err
+		}
+	case claims.Email != "" && claims.EmailVerified:
+		u.Email = claims.Email
+		if err := u.Load(ctx); err != nil && err.Code() != ERROR_CODE_NOT_FOUND {
+			return u, err
+		}
+		// An unverified address might have been claimed by someone else
+		if u.ArangoKey() != "" && (!u.Verified() || u.DeletedAt != nil) {
+			return u, NewBusinessError("An account with this email address already exists. Please sign in to it to link this identity.", ERROR_SUBCODE_EMAIL_TAKEN)
+		}
+	}
+
+	if u.ArangoKey() == "" {
+		created, err := createUserForExternalIdentity(ctx, claims)
+		if err != nil {
+			return u, err
+		}
+		u = created
+	}
+
+	identity = ExternalIdentity{
+		UserKey:  u.ArangoKey(),
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := identity.Create(ctx); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9-_]+`)
+
+func createUserForExternalIdentity(ctx *ServerContext, claims ExternalClaims) (User, Error) {
+	base := claims.PreferredUsername
+	if base == "" && claims.Email != "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+	base = strings.TrimLeft(usernameInvalidChars.ReplaceAllString(base, ""), "-_")
+	if len(base) < 3 {
+		base = "user"
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	username, err := availableUsername(ctx, base)
+	if err != nil {
+		return User{}, err
+	}
+
+	// Nobody knows the password, but the user can always set one by resetting it
+	password, err := newOpaqueToken()
+	if err != nil {
+		return User{}, err
+	}
+
+	name := claims.Name
+	if len(name) < 3 || len(name) > 50 {
+		name = username
+	}
+
+	u := User{
+		Name:     name,
+		Username: username,
+		Email:    claims.Email,
+		Password: password[:32],
+	}
+	if err := u.Create(ctx); err != nil {
+		return u, err
+	}
+
+	if claims.EmailVerified && claims.Email != "" {
+		col, err := ctx.Arango.CollectionFor(&u)
+		if err != nil {
+			return u, err
+		}
+		u.EmailVerifiedAt = support.TimePtr(ctx.RequestTime())
+		if _, err := col.UpdateDocument(ctx.Context, u.ArangoKey(), Updates{"verified": u.EmailVerifiedAt}); err != nil {
+			return u, NewServerError(err.Error())
+		}
+	}
+	return u, nil
+}
+
+// Returns the given username, or the first free one made by adding a number to it
+func availableUsername(ctx *ServerContext, base string) (string, Error) {
+	for i := 1; i <= 100; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s%d", base, i)
+		}
+		existing := User{Username: username}
+		if err := existing.Load(ctx); err != nil {
+			if err.Code() == ERROR_CODE_NOT_FOUND {
+				return username, nil
+			}
+			return "", err
+		}
+	}
+	return "", NewBusinessError("Couldn't find a free username", ERROR_SUBCODE_USERNAME_TAKEN)
+}
@@ -0,0 +1,195 @@
+package gruff
+
+import (
+	"testing"
+
+	"github.com/GruffDebate/server/gruff/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRedirectURI string = "https://www.gruff.org/auth/callback"
+
+func registerTestClient() {
+	RegisterClient(Client{Key: "gruff-web", Name: "Gruff", AllowedOrigins: []string{"https://www.gruff.org"}})
+}
+
+func unregisterTestClient() {
+	delete(REGISTERED_CLIENTS, "gruff-web")
+	clearClientCache()
+}
+
+// Signs in through the mock provider as the user it describes, returning the gruff user it is linked to
+func externalLogin(t *testing.T, mock *oidctest.Provider, claims map[string]interface{}) (User, Error) {
+	authURL, state, err := StartExternalLogin(CTX, "mock", "gruff-web", testRedirectURI)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	redirect, rerr := mock.Authorize(authURL, claims)
+	assert.NoError(t, rerr)
+	assert.Equal(t, state, redirect.Query().Get("state"))
+
+	u, login, err := FinishExternalLogin(CTX, "mock", state, redirect.Query().Get("code"))
+	CTX.RequestAt = nil
+	assert.Equal(t, testRedirectURI, login.RedirectURI)
+	return u, err
+}
+
+func TestExternalLogin(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	mock := oidctest.NewProvider("gruff")
+	defer mock.Close()
+	RegisterIdentityProvider(mockOIDCProvider(mock))
+	defer delete(IDENTITY_PROVIDERS, "mock")
+	registerTestClient()
+	defer unregisterTestClient()
+
+	_, _, err := StartExternalLogin(CTX, "nonexistent", "gruff-web", testRedirectURI)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+
+	// Users can only be sent back to a registered client
+	_, _, err = StartExternalLogin(CTX, "mock", "nonexistent", testRedirectURI)
+	assert.Error(t, err)
+	_, _, err = StartExternalLogin(CTX, "mock", "gruff-web", "https://evil.com/auth/callback")
+	assert.Error(t, err)
+
+	// Not signed in, so the first sign in creates a new user
+	CTX.UserContext = User{}
+	student := map[string]interface{}{
+		"sub":                "s1234567",
+		"email":              "student@campus.edu",
+		"email_verified":     true,
+		"name":               "Stu Dent",
+		"preferred_username": "stu.dent",
+	}
+	u, err := externalLogin(t, mock, student)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, u.ArangoKey())
+	assert.Equal(t, "Stu Dent", u.Name)
+	assert.Equal(t, "student", u.Username)
+	assert.Equal(t, "student@campus.edu", u.Email)
+	assert.True(t, u.Verified())
+
+	// After that, it signs in to the same user
+	again, err := externalLogin(t, mock, student)
+	assert.NoError(t, err)
+	assert.Equal(t, u.ArangoKey(), again.ArangoKey())
+
+	// Usernames don't collide
+	other, err := externalLogin(t, mock, map[string]interface{}{
+		"sub":                "s7654321",
+		"preferred_username": "studENT",
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, u.ArangoKey(), other.ArangoKey())
+	assert.Equal(t, "studENT2", other.Username)
+	assert.False(t, other.Verified())
+
+	// Each sign in can only be finished once
+	authURL, state, err := StartExternalLogin(CTX, "mock", "gruff-web", testRedirectURI)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	redirect, _ := mock.Authorize(authURL, student)
+	_, _, err = FinishExternalLogin(CTX, "mock", state, redirect.Query().Get("code"))
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	_, _, err = FinishExternalLogin(CTX, "mock", state, redirect.Query().Get("code"))
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+	CTX.RequestAt = nil
+
+	_, _, err = FinishExternalLogin(CTX, "mock", "made-up-state", "made-up-code")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+	CTX.RequestAt = nil
+}
+
+func TestExternalLoginLinksExistingUser(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	mock := oidctest.NewProvider("gruff")
+	defer mock.Close()
+	RegisterIdentityProvider(mockOIDCProvider(mock))
+	defer delete(IDENTITY_PROVIDERS, "mock")
+	registerTestClient()
+	defer unregisterTestClient()
+
+	mailer := &recordingMailer{}
+	MAILER = mailer
+	defer func() { MAILER = LogMailer{} }()
+
+	verified := User{Name: "Already Here", Username: "AlreadyHere", Email: "here@campus.edu", Password: "123456"}
+	err := verified.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = verified.SendVerificationEmail(CTX)
+	assert.NoError(t, err)
+	_, err = VerifyEmail(CTX, tokenFromEmail(mailer.sent[0]))
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	unverified := User{Name: "Squatter", Username: "Squatter", Email: "squatted@campus.edu", Password: "123456"}
+	err = unverified.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Users with the same verified email address are linked
+	CTX.UserContext = User{}
+	u, err := externalLogin(t, mock, map[string]interface{}{"sub": "here", "email": "here@campus.edu", "email_verified": true})
+	assert.NoError(t, err)
+	assert.Equal(t, verified.ArangoKey(), u.ArangoKey())
+
+	// But not if either side hasn't verified it
+	_, err = externalLogin(t, mock, map[string]interface{}{"sub": "squatted", "email": "squatted@campus.edu", "email_verified": true})
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_EMAIL_TAKEN, err.Subcode())
+
+	// Signed in users link the identity to themselves, whatever its email address
+	CTX.UserContext = unverified
+	u, err = externalLogin(t, mock, map[string]interface{}{"sub": "squatted", "email": "squatted@campus.edu", "email_verified": true})
+	assert.NoError(t, err)
+	assert.Equal(t, unverified.ArangoKey(), u.ArangoKey())
+
+	// An identity can't be linked to two users
+	CTX.UserContext = unverified
+	_, err = externalLogin(t, mock, map[string]interface{}{"sub": "here", "email": "here@campus.edu", "email_verified": true})
+	assert.Error(t, err)
+}
+
+func TestExchangeLoginCode(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	registerTestClient()
+	defer unregisterTestClient()
+	client, err := LoadClient(CTX, "gruff-web")
+	assert.NoError(t, err)
+
+	login := ExternalLogin{ClientKey: client.Key, RedirectURI: testRedirectURI}
+	code, err := IssueLoginCode(CTX, DEFAULT_USER, login)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Only the client that started the sign in can use the code
+	_, err = ExchangeLoginCode(CTX, code, Client{Key: "someone-else"})
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+	CTX.RequestAt = nil
+
+	u, err := ExchangeLoginCode(CTX, code, client)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_USER.ArangoKey(), u.ArangoKey())
+	CTX.RequestAt = nil
+
+	// And only once
+	_, err = ExchangeLoginCode(CTX, code, client)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+	CTX.RequestAt = nil
+
+	_, err = ExchangeLoginCode(CTX, "made-up-code", client)
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package gruff
+
+import (
+	"fmt"
+)
+
+// A Follow is an edge that goes from a User to a Claim or Argument they want to hear about.
+// Followers are notified when the item gets a new argument for or against it, a new premise,
+// a new context or a new version.
+//
+// Users follow what they create or score automatically, and can follow or unfollow anything else.
+// Follows point at whichever version was current when the user followed the item,
+// and are matched to later versions by their shared ID, so they don't have to be copied
+// every time a new version is created.
+type Follow struct {
+	Edge
+}
+
+// ArangoObject interface
+
+func (f Follow) CollectionName() string {
+	return "follows"
+}
+
+func (f Follow) ArangoKey() string {
+	return f.Key
+}
+
+func (f Follow) ArangoID() string {
+	return fmt.Sprintf("%s/%s", f.CollectionName(), f.ArangoKey())
+}
+
+func (f Follow) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (f *Follow) Create(ctx *ServerContext) Error {
+	return CreateArangoObject(ctx, f)
+}
+
+func (f *Follow) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("This item cannot be modified")
+}
+
+func (f *Follow) Delete(ctx *ServerContext) Error {
+	return DeleteArangoObject(ctx, f)
+}
+
+// Business methods
+
+// Follows the claim or argument, unless the user already does
+func (u User) Follow(ctx *ServerContext, target ArangoObject) Error {
+	existing, err := u.FollowFor(ctx, target)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+
+	f := Follow{Edge: Edge{
+		From: u.ArangoID(),
+		To:   target.ArangoID(),
+	}}
+	return f.Create(ctx)
+}
+
+func (u User) Unfollow(ctx *ServerContext, target ArangoObject) Error {
+	existing, err := u.FollowFor(ctx, target)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return NewNotFoundError("You are not following this item")
+	}
+	return existing.Delete(ctx)
+}
+
+// Returns the user's follow of any version of the claim or argument, or nil if they don't follow it
+func (u User) FollowFor(ctx *ServerContext, target ArangoObject) (*Follow, Error) {
+	vm, err := GetVersionedModel(target)
+	if err != nil {
+		return nil, err
+	}
+
+	f := Follow{}
+	bindVars := BindVars{
+		"user":   u.ArangoID(),
+		"target": vm.ID,
+	}
+	query := fmt.Sprintf(`FOR targ IN %s
+                                 FILTER targ.id == @target
+                                 FOR obj IN %s
+                                   FILTER obj._to == targ._id
+                                      AND obj._from == @user
+                                      AND obj.end == null
+                                   LIMIT 1
+                                   RETURN obj`,
+		target.CollectionName(),
+		Follow{}.CollectionName(),
+	)
+	if err := FindArangoObject(ctx, query, bindVars, &f); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Returns the Arango IDs of the users following any version of the claim or argument
+func Followers(ctx *ServerContext, target ArangoObject) ([]string, Error) {
+	followers := []string{}
+
+	vm, err := GetVersionedModel(target)
+	if err != nil {
+		return followers, err
+	}
+
+	bindVars := BindVars{
+		"target": vm.ID,
+	}
+	query := fmt.Sprintf(`FOR targ IN %s
+                               FILTER targ.id == @target
+                               FOR obj IN %s
+                                 FILTER obj._to == targ._id
+                                    AND obj.end == null
+                                 RETURN DISTINCT obj._from`,
+		target.CollectionName(),
+		Follow{}.CollectionName(),
+	)
+	cursor, dberr := ctx.Arango.DB.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if dberr != nil {
+		return followers, NewServerError(dberr.Error())
+	}
+	for cursor.HasMore() {
+		var follower string
+		if _, dberr := cursor.ReadDocument(ctx.Context, &follower); dberr != nil {
+			return followers, NewServerError(dberr.Error())
+		}
+		followers = append(followers, follower)
+	}
+	return followers, nil
+}
+
+// Makes the current user follow what they just created or scored
+func autoFollow(ctx *ServerContext, target ArangoObject) Error {
+	if ctx.UserContext.ArangoKey() == "" {
+		return nil
+	}
+	return ctx.UserContext.Follow(ctx, target)
+}
@@ -0,0 +1,172 @@
+package gruff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowUnfollow(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "A claim worth keeping an eye on"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	watcher := User{Name: "Watcher", Username: "follow_watcher", Email: "follow_watcher@gruff.org", Password: "123456"}
+	err = watcher.Create(CTX)
+	assert.NoError(t, err)
+
+	follow, err := watcher.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.Nil(t, follow)
+
+	err = watcher.Unfollow(CTX, &claim)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+
+	err = watcher.Follow(CTX, &claim)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = watcher.Follow(CTX, &claim)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	follow, err = watcher.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.NotNil(t, follow)
+	assert.Equal(t, watcher.ArangoID(), follow.From)
+	assert.Equal(t, claim.ArangoID(), follow.To)
+
+	// Creators follow what they create
+	followers, err := Followers(CTX, &claim)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{DEFAULT_USER.ArangoID(), watcher.ArangoID()}, followers)
+
+	// Follows carry over to new versions
+	err = claim.Update(CTX, Updates{"desc": "Now with a description"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = claim.Load(CTX)
+	assert.NoError(t, err)
+
+	follow, err = watcher.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.NotNil(t, follow)
+
+	err = watcher.Unfollow(CTX, &claim)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	follow, err = watcher.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.Nil(t, follow)
+
+	followers, err = Followers(CTX, &claim)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{DEFAULT_USER.ArangoID()}, followers)
+}
+
+func TestScoringFollows(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	author := User{Name: "Author", Username: "follow_author", Email: "follow_author@gruff.org", Password: "123456"}
+	err := author.Create(CTX)
+	assert.NoError(t, err)
+	CTX.UserContext = author
+
+	claim := Claim{Title: "A claim to be scored"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	CTX.UserContext = DEFAULT_USER
+
+	follow, err := DEFAULT_USER.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.Nil(t, follow)
+
+	err = DEFAULT_USER.Score(CTX, &claim, 0.7)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	follow, err = DEFAULT_USER.FollowFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.NotNil(t, follow)
+}
+
+func TestNotifyFollowers(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "A claim that everyone is watching"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	watcher := User{Name: "Watcher", Username: "notify_watcher", Email: "notify_watcher@gruff.org", Password: "123456"}
+	err = watcher.Create(CTX)
+	assert.NoError(t, err)
+	err = watcher.Follow(CTX, &claim)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	curator := User{Name: "Curator", Username: "notify_curator", Email: "notify_curator@gruff.org", Password: "123456", Curator: true}
+	err = curator.Create(CTX)
+	assert.NoError(t, err)
+	CTX.UserContext = curator
+
+	context := Context{ShortName: "Watched Context", Title: "A context for a watched claim", URL: "https://en.wikipedia.org/wiki/Context"}
+	err = context.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = claim.AddContext(CTX, context)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	arg := Argument{TargetClaimID: &claim.ID, Title: "A rebuttal of the watched claim", Pro: false}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// The creator stops hearing about the claim once they unfollow it
+	err = DEFAULT_USER.Unfollow(CTX, &claim)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = claim.ConvertToMultiPremise(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = claim.Load(CTX)
+	assert.NoError(t, err)
+
+	premise := Claim{Title: "A premise added to the watched claim"}
+	err = claim.AddPremise(CTX, &premise)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	creatorTypes := notificationTypes(t, DEFAULT_USER)
+	assert.Equal(t, []int{NOTIFICATION_TYPE_NEW_ARGUMENT, NOTIFICATION_TYPE_NEW_CONTEXT}, creatorTypes)
+
+	watcherTypes := notificationTypes(t, watcher)
+	assert.Contains(t, watcherTypes, NOTIFICATION_TYPE_NEW_CONTEXT)
+	assert.Contains(t, watcherTypes, NOTIFICATION_TYPE_NEW_ARGUMENT)
+	assert.Contains(t, watcherTypes, NOTIFICATION_TYPE_NEW_VERSION)
+	assert.Contains(t, watcherTypes, NOTIFICATION_TYPE_NEW_PREMISE)
+
+	// Nobody is notified of their own changes
+	assert.Empty(t, notificationTypes(t, curator))
+}
+
+func notificationTypes(t *testing.T, u User) []int {
+	notifications, err := u.Notifications(CTX, ArangoQueryParameters{}, false)
+	assert.NoError(t, err)
+	types := []int{}
+	for _, n := range notifications {
+		types = append(types, n.Type)
+	}
+	return types
+}
@@ -2,11 +2,13 @@ package gruff
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
 const JWT_ISS = "gruff"
@@ -21,6 +23,11 @@ func IssueJWToken(uid string, roles []string, exp time.Time) (string, error) {
 	claims["user"] = uid
 	claims["roles"] = roles
 	claims["exp"] = exp.Unix()
+	// The ID and issue time allow a token to be revoked before it expires.
+	// The issue time has microseconds, so that a token issued just after its user's
+	// tokens were revoked isn't mistaken for one issued in the same second before.
+	claims["jti"] = uuid.New().String()
+	claims["iat"] = float64(time.Now().UnixNano()/int64(time.Microsecond)) / 1e6
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -34,13 +41,24 @@ func IssueJWToken(uid string, roles []string, exp time.Time) (string, error) {
 	return tokenString, nil
 }
 
-func IssueJWTTokenForEmail(uid uint, email string, exp time.Time) (string, error) {
+// Returns when the token with these claims was issued
+func JWTokenIssuedAt(claims jwt.MapClaims) time.Time {
+	iat, _ := claims["iat"].(float64)
+	return time.Unix(0, int64(math.Round(iat*1e6))*int64(time.Microsecond))
+}
+
+// Email tokens are signed with their own key, and carry a purpose
+// so that a token sent for one reason can't be used for another
+const JWT_PURPOSE_VERIFY_EMAIL = "verify"
+
+func IssueJWTTokenForEmail(uid string, email string, purpose string, exp time.Time) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	claims := jwt.MapClaims{}
 	claims["iss"] = JWT_ISS
 	claims["user"] = uid
 	claims["email"] = email
+	claims["purpose"] = purpose
 	claims["exp"] = exp.Unix()
 	token.Claims = claims
 	secretKey := []byte(os.Getenv("JWT_KEY_EMAIL"))
@@ -53,6 +71,26 @@ func IssueJWTTokenForEmail(uid uint, email string, exp time.Time) (string, error
 	return tokenString, nil
 }
 
+// Returns the user ID and email address in a valid, unexpired email token issued for the given purpose
+func VerifyJWTTokenForEmail(tokenString, purpose string) (string, string, error) {
+	token, err := VerifyJWTToken(tokenString, os.Getenv("JWT_KEY_EMAIL"))
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["iss"] != JWT_ISS || claims["purpose"] != purpose {
+		return "", "", fmt.Errorf("Token is invalid")
+	}
+	uid, _ := claims["user"].(string)
+	email, _ := claims["email"].(string)
+	if uid == "" || email == "" {
+		return "", "", fmt.Errorf("Token is invalid")
+	}
+
+	return uid, email, nil
+}
+
 func JWTTokenExpirationDate() time.Time {
 	var err error
 	var jwtHours int
@@ -64,6 +102,17 @@ func JWTTokenExpirationDate() time.Time {
 	return (time.Now().Add(jwtDuration)).Round(time.Millisecond)
 }
 
+func RefreshTokenExpirationDate() time.Time {
+	var err error
+	var refreshHours int
+	refreshHours, err = strconv.Atoi(os.Getenv("REFRESH_TOKEN_EXPIRATION"))
+	if err != nil {
+		refreshHours = 720
+	}
+	refreshDuration := time.Duration(refreshHours) * time.Hour
+	return (time.Now().Add(refreshDuration)).Round(time.Millisecond)
+}
+
 func VerifyJWTToken(tokenString, secretKey string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -0,0 +1,220 @@
+package gruff
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+	arango "github.com/arangodb/go-driver"
+)
+
+/*
+ * A LoginThrottle counts the failed sign in attempts for an account or an IP address.
+ * It also counts the password resets requested for an email address, all of which are
+ * treated as failures, so that nobody can flood an inbox with reset links.
+ *
+ * Once the free attempts are used up, each further failure locks out the account
+ * (or address) for twice as long as the one before, up to LOGIN_LOCKOUT_MAX.
+ * Failures are forgotten after LOGIN_THROTTLE_WINDOW without any new ones,
+ * and the account's failures are forgotten as soon as someone signs in to it.
+ */
+
+const LOGIN_THROTTLE_ACCOUNT string = "account"
+const LOGIN_THROTTLE_IP string = "ip"
+const LOGIN_THROTTLE_PASSWORD_RESET string = "reset"
+
+const LOGIN_THROTTLE_ACCOUNT_ATTEMPTS int = 5
+const LOGIN_THROTTLE_IP_ATTEMPTS int = 20
+const LOGIN_THROTTLE_PASSWORD_RESET_ATTEMPTS int = 3
+const LOGIN_THROTTLE_WINDOW time.Duration = 24 * time.Hour
+const LOGIN_LOCKOUT_BASE time.Duration = 30 * time.Second
+const LOGIN_LOCKOUT_MAX time.Duration = 1 * time.Hour
+
+type LoginThrottle struct {
+	Key         string     `json:"_key"`
+	Scope       string     `json:"scope"`
+	Failures    int        `json:"failures"`
+	LastFailure *time.Time `json:"last,omitempty"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// ArangoObject interface
+
+func (t LoginThrottle) CollectionName() string {
+	return "login_throttles"
+}
+
+func (t LoginThrottle) ArangoKey() string {
+	return t.Key
+}
+
+func (t LoginThrottle) ArangoID() string {
+	return fmt.Sprintf("%s/%s", t.CollectionName(), t.ArangoKey())
+}
+
+func (t LoginThrottle) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+// Saves the throttle, replacing any earlier state for the same account or address
+func (t *LoginThrottle) Create(ctx *ServerContext) Error {
+	bindVars := BindVars{
+		"key":         t.Key,
+		"scope":       t.Scope,
+		"failures":    t.Failures,
+		"last":        t.LastFailure,
+		"lockedUntil": t.LockedUntil,
+	}
+	query := fmt.Sprintf(`UPSERT { _key: @key }
+                               INSERT { _key: @key, scope: @scope, failures: @failures, last: @last, lockedUntil: @lockedUntil }
+                               REPLACE { _key: @key, scope: @scope, failures: @failures, last: @last, lockedUntil: @lockedUntil }
+                               IN %s`,
+		t.CollectionName())
+	if _, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (t *LoginThrottle) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Login throttles cannot be modified")
+}
+
+// Throttles are removed outright, rather than soft-deleted
+func (t *LoginThrottle) Delete(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(t)
+	if err != nil {
+		return err
+	}
+	if _, err := col.RemoveDocument(ctx.Context, t.ArangoKey()); err != nil {
+		if arango.IsNotFound(err) {
+			return nil
+		}
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (t *LoginThrottle) PrepareForCreate(ctx *ServerContext) {
+}
+
+func (t *LoginThrottle) PrepareForDelete(ctx *ServerContext) {
+}
+
+// Business methods
+
+// Loads the throttle for the given account (identified by email), IP address, or password reset email,
+// or returns an empty one if there have been no recent failures
+func LoadLoginThrottle(ctx *ServerContext, scope, identifier string) (LoginThrottle, Error) {
+	// Identifiers are hashed, since emails and IPv6 addresses aren't always valid keys
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(identifier))))
+	t := LoginThrottle{
+		Key:   fmt.Sprintf("%s-%x", scope, hash),
+		Scope: scope,
+	}
+
+	saved := LoginThrottle{}
+	if err := LoadArangoObject(ctx, &saved, t.Key); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return t, nil
+		}
+		return t, err
+	}
+
+	if saved.LastFailure != nil && ctx.RequestTime().Sub(*saved.LastFailure) > LOGIN_THROTTLE_WINDOW {
+		return t, nil
+	}
+	return saved, nil
+}
+
+func (t LoginThrottle) FreeAttempts() int {
+	switch t.Scope {
+	case LOGIN_THROTTLE_IP:
+		return LOGIN_THROTTLE_IP_ATTEMPTS
+	case LOGIN_THROTTLE_PASSWORD_RESET:
+		return LOGIN_THROTTLE_PASSWORD_RESET_ATTEMPTS
+	}
+	return LOGIN_THROTTLE_ACCOUNT_ATTEMPTS
+}
+
+// Returns how much longer the throttle is locked, or zero if it isn't
+func (t LoginThrottle) LockedFor(now time.Time) time.Duration {
+	if t.LockedUntil == nil || !t.LockedUntil.After(now) {
+		return 0
+	}
+	return t.LockedUntil.Sub(now)
+}
+
+// Counts a failed attempt, locking the throttle once the free attempts are used up
+func (t *LoginThrottle) RecordFailure(ctx *ServerContext) Error {
+	now := ctx.RequestTime()
+	t.Failures++
+	t.LastFailure = support.TimePtr(now)
+	if lockout := LoginLockout(t.Failures, t.FreeAttempts()); lockout > 0 {
+		t.LockedUntil = support.TimePtr(now.Add(lockout))
+	}
+	return t.Create(ctx)
+}
+
+// Returns how long to lock out further attempts after the given number of failures,
+// doubling with every failure past the free attempts
+func LoginLockout(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	exponent := float64(failures - free - 1)
+	lockout := float64(LOGIN_LOCKOUT_BASE) * math.Pow(2, exponent)
+	if lockout > float64(LOGIN_LOCKOUT_MAX) {
+		return LOGIN_LOCKOUT_MAX
+	}
+	return time.Duration(lockout)
+}
+
+// Returns the time remaining before another sign in attempt is allowed
+// for the given email and IP address, or zero if it is allowed now
+func LoginLockedFor(ctx *ServerContext, email, ip string) (time.Duration, Error) {
+	account, err := LoadLoginThrottle(ctx, LOGIN_THROTTLE_ACCOUNT, email)
+	if err != nil {
+		return 0, err
+	}
+	address, err := LoadLoginThrottle(ctx, LOGIN_THROTTLE_IP, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	now := ctx.RequestTime()
+	wait := account.LockedFor(now)
+	if addressWait := address.LockedFor(now); addressWait > wait {
+		wait = addressWait
+	}
+	return wait, nil
+}
+
+func RecordLoginFailure(ctx *ServerContext, email, ip string) Error {
+	account, err := LoadLoginThrottle(ctx, LOGIN_THROTTLE_ACCOUNT, email)
+	if err != nil {
+		return err
+	}
+	if err := account.RecordFailure(ctx); err != nil {
+		return err
+	}
+
+	address, err := LoadLoginThrottle(ctx, LOGIN_THROTTLE_IP, ip)
+	if err != nil {
+		return err
+	}
+	return address.RecordFailure(ctx)
+}
+
+// Forgets the failed attempts on the account, but not those from the IP address,
+// so that signing in to one account doesn't help guess the passwords of others
+func RecordLoginSuccess(ctx *ServerContext, email string) Error {
+	account, err := LoadLoginThrottle(ctx, LOGIN_THROTTLE_ACCOUNT, email)
+	if err != nil {
+		return err
+	}
+	return account.Delete(ctx)
+}
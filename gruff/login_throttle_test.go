@@ -0,0 +1,87 @@
+package gruff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLockout(t *testing.T) {
+	assert.Equal(t, time.Duration(0), LoginLockout(0, 5))
+	assert.Equal(t, time.Duration(0), LoginLockout(5, 5))
+	assert.Equal(t, LOGIN_LOCKOUT_BASE, LoginLockout(6, 5))
+	assert.Equal(t, 2*LOGIN_LOCKOUT_BASE, LoginLockout(7, 5))
+	assert.Equal(t, 4*LOGIN_LOCKOUT_BASE, LoginLockout(8, 5))
+	assert.Equal(t, LOGIN_LOCKOUT_MAX, LoginLockout(50, 5))
+}
+
+func TestLoginThrottleLockedFor(t *testing.T) {
+	now := time.Now()
+
+	throttle := LoginThrottle{}
+	assert.Equal(t, time.Duration(0), throttle.LockedFor(now))
+
+	until := now.Add(time.Minute)
+	throttle.LockedUntil = &until
+	assert.Equal(t, time.Minute, throttle.LockedFor(now))
+	assert.Equal(t, time.Duration(0), throttle.LockedFor(now.Add(2*time.Minute)))
+}
+
+func TestLoginThrottling(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	email := "Throttled@gruff.org"
+	ip := "10.0.0.1"
+
+	for i := 0; i < LOGIN_THROTTLE_ACCOUNT_ATTEMPTS; i++ {
+		wait, err := LoginLockedFor(CTX, email, ip)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+
+		err = RecordLoginFailure(CTX, email, ip)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+	}
+
+	wait, err := LoginLockedFor(CTX, email, ip)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	err = RecordLoginFailure(CTX, email, ip)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Emails are not case sensitive
+	wait, err = LoginLockedFor(CTX, "throttled@gruff.org", "10.0.0.2")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.True(t, wait > 0)
+	assert.True(t, wait <= LOGIN_LOCKOUT_BASE)
+
+	// The address has plenty of attempts left for other accounts
+	wait, err = LoginLockedFor(CTX, "someone.else@gruff.org", ip)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, time.Duration(0), wait)
+
+	address, err := LoadLoginThrottle(CTX, LOGIN_THROTTLE_IP, ip)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, LOGIN_THROTTLE_ACCOUNT_ATTEMPTS+1, address.Failures)
+
+	err = RecordLoginSuccess(CTX, email)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	wait, err = LoginLockedFor(CTX, email, ip)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, time.Duration(0), wait)
+
+	address, err = LoadLoginThrottle(CTX, LOGIN_THROTTLE_IP, ip)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, LOGIN_THROTTLE_ACCOUNT_ATTEMPTS+1, address.Failures)
+}
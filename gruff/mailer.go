@@ -0,0 +1,97 @@
+package gruff
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Emails are delivered through whichever Mailer is configured in MAILER,
+ * so that development and test environments don't need a real mail server.
+ */
+
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(email Email) Error
+}
+
+var MAILER Mailer = LogMailer{}
+
+// A LogMailer writes emails to a file instead of sending them, or to stdout if it has no Path
+type LogMailer struct {
+	Path string
+}
+
+var logMailerMutex sync.Mutex
+
+func (m LogMailer) Send(email Email) Error {
+	entry := fmt.Sprintf("----- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().UTC().Format(time.RFC3339),
+		email.To,
+		email.Subject,
+		email.Body)
+
+	if m.Path == "" {
+		fmt.Print(entry)
+		return nil
+	}
+
+	logMailerMutex.Lock()
+	defer logMailerMutex.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return NewServerError(err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(entry); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+// An SMTPMailer sends plain text emails through an SMTP server,
+// authenticating only if it has a Username
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(email Email) Error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// Header values can't be allowed to smuggle in extra headers
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	headers := []string{
+		fmt.Sprintf("From: %s", clean.Replace(m.From)),
+		fmt.Sprintf("To: %s", clean.Replace(email.To)),
+		fmt.Sprintf("Subject: %s", clean.Replace(email.Subject)),
+		fmt.Sprintf("Date: %s", time.Now().Format(time.RFC1123Z)),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	msg := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.Replace(email.Body, "\n", "\r\n", -1)
+
+	addr := net.JoinHostPort(m.Host, m.Port)
+	if err := smtp.SendMail(addr, auth, m.From, []string{email.To}, []byte(msg)); err != nil {
+		return NewServerError(fmt.Sprintf("Couldn't send email: %s", err.Error()))
+	}
+	return nil
+}
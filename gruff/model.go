@@ -333,3 +333,37 @@ func ClearTransientData(item interface{}, m map[string]interface{}) (map[string]
 
 	return data, nil
 }
+
+// Returns a copy of the data without the values of any fields that are tagged settable:"false",
+// including those of embedded structs
+func ClearUnsettableData(item interface{}, m map[string]interface{}) (map[string]interface{}, Error) {
+	data := map[string]interface{}{}
+
+	for key, value := range m {
+		data[key] = value
+	}
+
+	t := reflect.TypeOf(item)
+	if t == nil {
+		return data, NewServerError("Cannot clear values on a nil item")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	clearUnsettableFields(t, data)
+
+	return data, nil
+}
+
+func clearUnsettableFields(t reflect.Type, data map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			clearUnsettableFields(f.Type, data)
+			continue
+		}
+		if f.Tag.Get("settable") == "false" {
+			delete(data, support.JsonName(f))
+		}
+	}
+}
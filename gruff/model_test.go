@@ -228,3 +228,23 @@ func TestClearTransientData(t *testing.T) {
 	assert.Equal(t, nil, data["proargs"])
 	assert.Equal(t, nil, data["conargs"])
 }
+
+func TestClearUnsettableData(t *testing.T) {
+	m := Updates{
+		"_key":         "not-the-key",
+		"id":           "not-the-id",
+		"creator":      "users/someone-else",
+		"title":        "Title of a thing",
+		"truthRU":      1.0,
+		"distribution": map[string]interface{}{"controversy": 1.0},
+	}
+
+	data, err := ClearUnsettableData(&Claim{}, m)
+	assert.NoError(t, err)
+	assert.Equal(t, Updates{"title": "Title of a thing"}, Updates(data))
+	assert.Equal(t, 6, len(m))
+
+	data, err = ClearUnsettableData(Argument{}, Updates{"strengthRU": 1.0, "distribution": map[string]interface{}{}, "pro": true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"pro": true}, data)
+}
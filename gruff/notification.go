@@ -1,111 +1,275 @@
 package gruff
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/GruffDebate/server/support"
-	"github.com/jinzhu/gorm"
+	"github.com/google/uuid"
 )
 
+/*
+ * Notifications tell users about changes made by other people to the claims and arguments
+ * they follow: new arguments for or against them, new premises and contexts, arguments
+ * being moved, and new versions.
+ *
+ * Each notification belongs to the user with the Arango ID in UserID. Nobody is notified
+ * of their own changes.
+ */
+
 const OBJECT_TYPE_CLAIM int = 1
 const OBJECT_TYPE_ARGUMENT int = 2
+const OBJECT_TYPE_CONTEXT int = 3
 
 const NOTIFICATION_TYPE_MOVED int = 1
 const NOTIFICATION_TYPE_PARENT_MOVED int = 2
 const NOTIFICATION_TYPE_NEW_ARGUMENT int = 3
+const NOTIFICATION_TYPE_NEW_VERSION int = 4
+const NOTIFICATION_TYPE_NEW_PREMISE int = 5
+const NOTIFICATION_TYPE_NEW_CONTEXT int = 6
 
 type Notification struct {
-	Model
-	UserID   uint64      `json:"userId" sql:"not null"`
-	Type     int         `json:"type" sql:"not null"`
-	ItemID   *string     `json:"itemId,omitempty" sql:"type:uuid"`
-	ItemType *int        `json:"itemType"`
-	Item     interface{} `json:"item,omitempty" gorm:"-"`
-	OldID    *string     `json:"oldId,omitempty" sql:"type:uuid"`
-	OldType  *int        `json:"oldType"`
-	NewID    *string     `json:"newId,omitempty" sql:"type:uuid"`
-	NewType  *int        `json:"newType"`
-	Viewed   bool        `json:"viewed" sql:"not null"`
+	Key       string    `json:"_key"`
+	UserID    string    `json:"user"`
+	Type      int       `json:"type"`
+	ItemID    *string   `json:"itemId,omitempty"`
+	ItemType  *int      `json:"itemType,omitempty"`
+	OldID     *string   `json:"oldId,omitempty"`
+	OldType   *int      `json:"oldType,omitempty"`
+	NewID     *string   `json:"newId,omitempty"`
+	NewType   *int      `json:"newType,omitempty"`
+	Viewed    bool      `json:"viewed"`
+	CreatedAt time.Time `json:"start"`
 }
 
-func (n Notification) ValidateForCreate() Error {
-	return ValidateStruct(n)
+// ArangoObject interface
+
+func (n Notification) CollectionName() string {
+	return "notifications"
 }
 
-func (n Notification) ValidateForUpdate() Error {
-	return n.ValidateForCreate()
+func (n Notification) ArangoKey() string {
+	return n.Key
 }
 
-func (n Notification) ValidateField(f string) Error {
-	return ValidateStructField(n, f)
+func (n Notification) ArangoID() string {
+	return fmt.Sprintf("%s/%s", n.CollectionName(), n.ArangoKey())
 }
 
-func NotifyArgumentMoved(ctx *ServerContext, userId uint64, argId string, oldTargetId string, oldTargetType int) Error {
-	/*
-		n := Notification{
-			UserID:   userId,
-			Type:     NOTIFICATION_TYPE_MOVED,
-			ItemID:   &argId,
-			ItemType: support.IntPtr(OBJECT_TYPE_ARGUMENT),
-			OldID:    &oldTargetId,
-			OldType:  support.IntPtr(oldTargetType),
-		}
-	*/
-	/*
-		if err := ctx.Database.Create(&n).Error; err != nil {
-			return NewServerError(err.Error())
-		}
-	*/
+func (n Notification) DefaultQueryParameters() ArangoQueryParameters {
+	return ArangoQueryParameters{
+		Sort:  support.StringPtr("obj.start DESC"),
+		Limit: support.IntPtr(50),
+	}
+}
+
+func (n *Notification) Create(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(n)
+	if err != nil {
+		return err
+	}
+	n.PrepareForCreate(ctx)
+	if _, err := col.CreateDocument(ctx.Context, n); err != nil {
+		return NewServerError(err.Error())
+	}
 	return nil
 }
 
-func NotifyParentArgumentMoved(ctx *ServerContext, userId uint64, parentArgId string, oldTargetId string, oldTargetType int) Error {
-	/*
-		n := Notification{
-			UserID:   userId,
-			Type:     NOTIFICATION_TYPE_PARENT_MOVED,
-			ItemID:   &parentArgId,
-			ItemType: support.IntPtr(OBJECT_TYPE_ARGUMENT),
-			OldID:    &oldTargetId,
-			OldType:  support.IntPtr(oldTargetType),
-		}
-	*/
-	/*
-		if err := ctx.Database.Create(&n).Error; err != nil {
-			return NewServerError(err.Error())
+func (n *Notification) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Notifications can only be marked as viewed")
+}
+
+func (n *Notification) Delete(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(n)
+	if err != nil {
+		return err
+	}
+	if _, err := col.RemoveDocument(ctx.Context, n.ArangoKey()); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (n *Notification) PrepareForCreate(ctx *ServerContext) {
+	n.Key = uuid.New().String()
+	n.CreatedAt = ctx.RequestTime()
+	n.Viewed = false
+}
+
+func (n *Notification) PrepareForDelete(ctx *ServerContext) {
+}
+
+// Business methods
+
+// Saves the notification, unless it would tell the user about their own change
+func (n *Notification) send(ctx *ServerContext) Error {
+	if n.UserID == "" || n.UserID == ctx.UserContext.ArangoID() {
+		return nil
+	}
+	return n.Create(ctx)
+}
+
+// Sends a copy of the notification to everyone following the item
+func (n Notification) sendToFollowers(ctx *ServerContext, item ArangoObject) Error {
+	followers, err := Followers(ctx, item)
+	if err != nil {
+		return err
+	}
+	for _, follower := range followers {
+		notification := n
+		notification.UserID = follower
+		if err := notification.send(ctx); err != nil {
+			return err
 		}
-	*/
+	}
 	return nil
 }
 
-func NotifyNewArgument(ctx ServerContext, userId uint64, item interface{}, newArg Argument) Error {
+// Tells the argument's followers that it was moved away from its old target
+func NotifyArgumentMoved(ctx *ServerContext, arg Argument, oldTargetID string, oldTargetType int) Error {
+	n := Notification{
+		Type:     NOTIFICATION_TYPE_MOVED,
+		ItemID:   &arg.ID,
+		ItemType: support.IntPtr(OBJECT_TYPE_ARGUMENT),
+		OldID:    &oldTargetID,
+		OldType:  support.IntPtr(oldTargetType),
+	}
+	return n.sendToFollowers(ctx, &arg)
+}
+
+// Tells the followers of an argument for or against another argument that the other argument was moved
+func NotifyParentArgumentMoved(ctx *ServerContext, arg Argument, parentArgID string, oldTargetID string, oldTargetType int) Error {
+	n := Notification{
+		Type:     NOTIFICATION_TYPE_PARENT_MOVED,
+		ItemID:   &parentArgID,
+		ItemType: support.IntPtr(OBJECT_TYPE_ARGUMENT),
+		OldID:    &oldTargetID,
+		OldType:  support.IntPtr(oldTargetType),
+	}
+	return n.sendToFollowers(ctx, &arg)
+}
+
+// Tells the followers of a claim or argument that there is a new argument for or against it
+func NotifyNewArgument(ctx *ServerContext, item ArangoObject, newArg Argument) Error {
 	n := Notification{
-		UserID:  userId,
 		Type:    NOTIFICATION_TYPE_NEW_ARGUMENT,
 		NewID:   &newArg.ID,
 		NewType: support.IntPtr(OBJECT_TYPE_ARGUMENT),
 	}
-	if claim, ok := item.(Claim); ok {
-		n.ItemID = &claim.ID
+	n.setItem(item)
+	return n.sendToFollowers(ctx, item)
+}
+
+// Tells the followers of a multi-premise claim that it has a new premise
+func NotifyNewPremise(ctx *ServerContext, claim Claim, premise Claim) Error {
+	n := Notification{
+		Type:     NOTIFICATION_TYPE_NEW_PREMISE,
+		ItemID:   &claim.ID,
+		ItemType: support.IntPtr(OBJECT_TYPE_CLAIM),
+		NewID:    &premise.ID,
+		NewType:  support.IntPtr(OBJECT_TYPE_CLAIM),
+	}
+	return n.sendToFollowers(ctx, &claim)
+}
+
+// Tells the followers of a claim that it was placed in a new context
+func NotifyNewContext(ctx *ServerContext, claim Claim, context Context) Error {
+	n := Notification{
+		Type:     NOTIFICATION_TYPE_NEW_CONTEXT,
+		ItemID:   &claim.ID,
+		ItemType: support.IntPtr(OBJECT_TYPE_CLAIM),
+		NewID:    support.StringPtr(context.ArangoKey()),
+		NewType:  support.IntPtr(OBJECT_TYPE_CONTEXT),
+	}
+	return n.sendToFollowers(ctx, &claim)
+}
+
+// Tells the followers of a claim or argument that someone made a new version of it
+func NotifyNewVersion(ctx *ServerContext, item ArangoObject, oldVersionKey string) Error {
+	n := Notification{
+		Type:  NOTIFICATION_TYPE_NEW_VERSION,
+		OldID: &oldVersionKey,
+		NewID: support.StringPtr(item.ArangoKey()),
+	}
+	n.setItem(item)
+	n.OldType = n.ItemType
+	n.NewType = n.ItemType
+	return n.sendToFollowers(ctx, item)
+}
+
+func (n *Notification) setItem(item ArangoObject) {
+	switch target := item.(type) {
+	case *Claim:
+		n.ItemID = &target.ID
 		n.ItemType = support.IntPtr(OBJECT_TYPE_CLAIM)
-	} else if arg, ok := item.(Argument); ok {
-		n.ItemID = &arg.ID
+	case *Argument:
+		n.ItemID = &target.ID
 		n.ItemType = support.IntPtr(OBJECT_TYPE_ARGUMENT)
 	}
-	/*
-		if err := ctx.Database.Create(&n).Error; err != nil {
-			return NewServerError(err.Error())
-		}
-	*/
-	return nil
 }
 
-// Scopes
+// Returns the user's notifications, newest first, optionally only those they haven't viewed yet
+func (u User) Notifications(ctx *ServerContext, params ArangoQueryParameters, unreadOnly bool) ([]Notification, Error) {
+	notifications := []Notification{}
+	params = Notification{}.DefaultQueryParameters().Merge(params)
+	params.Return = support.StringPtr("obj")
+
+	bindVars := BindVars{
+		"user": u.ArangoID(),
+	}
+	filter := "FILTER obj.user == @user"
+	if unreadOnly {
+		filter += " AND obj.viewed == false"
+	}
+	query := params.Apply(fmt.Sprintf("FOR obj IN %s %s", Notification{}.CollectionName(), filter))
+	err := FindArangoObjects(ctx, query, bindVars, &notifications)
+	return notifications, err
+}
 
-func FindArgumentMovedNotifications(db *gorm.DB) *gorm.DB {
-	return db.Where("type = ?", NOTIFICATION_TYPE_MOVED).
-		Where("item_type = ?", OBJECT_TYPE_ARGUMENT)
+// Returns how many of the user's notifications they haven't viewed yet
+func (u User) UnreadNotificationCount(ctx *ServerContext) (int, Error) {
+	bindVars := BindVars{
+		"user": u.ArangoID(),
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                               FILTER obj.user == @user
+                                  AND obj.viewed == false
+                               COLLECT WITH COUNT INTO length
+                               RETURN length`,
+		Notification{}.CollectionName())
+	cursor, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return 0, NewServerError(err.Error())
+	}
+	var count int
+	if _, err := cursor.ReadDocument(ctx.Context, &count); err != nil {
+		return 0, NewServerError(err.Error())
+	}
+	return count, nil
 }
 
-func FindParentArgumentMovedNotifications(db *gorm.DB) *gorm.DB {
-	return db.Where("type = ?", NOTIFICATION_TYPE_PARENT_MOVED).
-		Where("item_type = ?", OBJECT_TYPE_ARGUMENT)
+// Marks the user's notification with the given key as viewed
+func (u User) MarkNotificationViewed(ctx *ServerContext, key string) (Notification, Error) {
+	n := Notification{}
+	if err := LoadArangoObject(ctx, &n, key); err != nil {
+		return n, err
+	}
+	// Other users' notifications are treated as though they don't exist
+	if n.UserID != u.ArangoID() {
+		return Notification{}, NewNotFoundError("Not Found")
+	}
+	if n.Viewed {
+		return n, nil
+	}
+
+	col, err := ctx.Arango.CollectionFor(&n)
+	if err != nil {
+		return n, err
+	}
+	n.Viewed = true
+	if _, err := col.UpdateDocument(ctx.Context, n.ArangoKey(), Updates{"viewed": true}); err != nil {
+		return n, NewServerError(err.Error())
+	}
+	return n, nil
 }
@@ -0,0 +1,217 @@
+package gruff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyNewArgument(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "Somebody should argue about this claim"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Nobody is notified of their own arguments
+	own := Argument{TargetClaimID: &claim.ID, Title: "I'll argue with myself, then", Pro: true}
+	err = own.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	notifications, err := DEFAULT_USER.Notifications(CTX, ArangoQueryParameters{}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(notifications))
+
+	other := User{Name: "Other User", Username: "other_notified", Email: "other_notified@gruff.org", Password: "123456"}
+	err = other.Create(CTX)
+	assert.NoError(t, err)
+	CTX.UserContext = other
+
+	arg := Argument{TargetClaimID: &claim.ID, Title: "Someone else's argument against it", Pro: false}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	subArg := Argument{TargetArgumentID: &own.ID, Title: "And against the first argument too", Pro: false}
+	err = subArg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	notifications, err = DEFAULT_USER.Notifications(CTX, ArangoQueryParameters{}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(notifications))
+	assert.Equal(t, DEFAULT_USER.ArangoID(), notifications[0].UserID)
+	assert.Equal(t, NOTIFICATION_TYPE_NEW_ARGUMENT, notifications[0].Type)
+	assert.Equal(t, own.ID, *notifications[0].ItemID)
+	assert.Equal(t, OBJECT_TYPE_ARGUMENT, *notifications[0].ItemType)
+	assert.Equal(t, subArg.ID, *notifications[0].NewID)
+	assert.Equal(t, claim.ID, *notifications[1].ItemID)
+	assert.Equal(t, OBJECT_TYPE_CLAIM, *notifications[1].ItemType)
+	assert.Equal(t, arg.ID, *notifications[1].NewID)
+	assert.False(t, notifications[1].Viewed)
+
+	notifications, err = other.Notifications(CTX, ArangoQueryParameters{}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(notifications))
+}
+
+func TestNotifyArgumentMoved(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "The claim the argument starts on"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	newTarget := Claim{Title: "The claim the argument is moved to"}
+	err = newTarget.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	arg := Argument{TargetClaimID: &claim.ID, Title: "The argument that gets moved", Pro: true}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	other := User{Name: "Other User", Username: "other_mover", Email: "other_mover@gruff.org", Password: "123456"}
+	err = other.Create(CTX)
+	assert.NoError(t, err)
+	CTX.UserContext = other
+
+	subArg := Argument{TargetArgumentID: &arg.ID, Title: "An argument about the one that gets moved", Pro: true}
+	err = subArg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	curator := User{Name: "Curator", Username: "notified_curator", Email: "notified_curator@gruff.org", Password: "123456", Curator: true}
+	err = curator.Create(CTX)
+	assert.NoError(t, err)
+	CTX.UserContext = curator
+
+	err = arg.MoveTo(CTX, &newTarget, false)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	notifications, err := DEFAULT_USER.Notifications(CTX, ArangoQueryParameters{}, true)
+	assert.NoError(t, err)
+	// One for the other user's argument, one for the move, and one for the new target
+	assert.Equal(t, 3, len(notifications))
+
+	moved := notifications[0]
+	added := notifications[1]
+	if moved.Type != NOTIFICATION_TYPE_MOVED {
+		moved, added = added, moved
+	}
+	assert.Equal(t, NOTIFICATION_TYPE_MOVED, moved.Type)
+	assert.Equal(t, arg.ID, *moved.ItemID)
+	assert.Equal(t, claim.ID, *moved.OldID)
+	assert.Equal(t, OBJECT_TYPE_CLAIM, *moved.OldType)
+
+	// The new target's creator learns about it as a new argument
+	assert.Equal(t, NOTIFICATION_TYPE_NEW_ARGUMENT, added.Type)
+	assert.Equal(t, newTarget.ID, *added.ItemID)
+	assert.Equal(t, arg.ID, *added.NewID)
+
+	assert.Equal(t, NOTIFICATION_TYPE_NEW_ARGUMENT, notifications[2].Type)
+	assert.Equal(t, subArg.ID, *notifications[2].NewID)
+
+	notifications, err = other.Notifications(CTX, ArangoQueryParameters{}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(notifications))
+	assert.Equal(t, NOTIFICATION_TYPE_PARENT_MOVED, notifications[0].Type)
+	assert.Equal(t, arg.ID, *notifications[0].ItemID)
+	assert.Equal(t, claim.ID, *notifications[0].OldID)
+}
+
+func TestNotifyNewClaimVersion(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "A claim that someone else will edit"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	origKey := claim.ArangoKey()
+
+	// Editing your own claim doesn't notify anyone
+	err = claim.Update(CTX, Updates{"desc": "Edited by its creator"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	count, err := DEFAULT_USER.UnreadNotificationCount(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	curator := User{Name: "Curator", Username: "version_curator", Email: "version_curator@gruff.org", Password: "123456", Curator: true}
+	err = curator.Create(CTX)
+	assert.NoError(t, err)
+	CTX.UserContext = curator
+
+	err = claim.Load(CTX)
+	assert.NoError(t, err)
+	oldKey := claim.ArangoKey()
+	assert.NotEqual(t, origKey, oldKey)
+
+	err = claim.Update(CTX, Updates{"desc": "Edited by a curator"})
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = claim.Load(CTX)
+	assert.NoError(t, err)
+
+	notifications, err := DEFAULT_USER.Notifications(CTX, ArangoQueryParameters{}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(notifications))
+	assert.Equal(t, NOTIFICATION_TYPE_NEW_VERSION, notifications[0].Type)
+	assert.Equal(t, claim.ID, *notifications[0].ItemID)
+	assert.Equal(t, oldKey, *notifications[0].OldID)
+	assert.Equal(t, claim.ArangoKey(), *notifications[0].NewID)
+}
+
+func TestMarkNotificationViewed(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	other := User{Name: "Other User", Username: "other_viewer", Email: "other_viewer@gruff.org", Password: "123456"}
+	err := other.Create(CTX)
+	assert.NoError(t, err)
+
+	n1 := Notification{UserID: DEFAULT_USER.ArangoID(), Type: NOTIFICATION_TYPE_MOVED}
+	err = n1.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	n2 := Notification{UserID: DEFAULT_USER.ArangoID(), Type: NOTIFICATION_TYPE_NEW_ARGUMENT}
+	err = n2.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	count, err := DEFAULT_USER.UnreadNotificationCount(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Other users' notifications can't be found
+	_, err = other.MarkNotificationViewed(CTX, n1.ArangoKey())
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+
+	n, err := DEFAULT_USER.MarkNotificationViewed(CTX, n1.ArangoKey())
+	assert.NoError(t, err)
+	assert.True(t, n.Viewed)
+
+	count, err = DEFAULT_USER.UnreadNotificationCount(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	unread, err := DEFAULT_USER.Notifications(CTX, ArangoQueryParameters{}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(unread))
+	assert.Equal(t, n2.ArangoKey(), unread[0].ArangoKey())
+
+	all, err := DEFAULT_USER.Notifications(CTX, ArangoQueryParameters{}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(all))
+}
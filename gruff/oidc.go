@@ -0,0 +1,340 @@
+package gruff
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+/*
+ * Users can sign in through external identity providers, such as a university's single sign-on.
+ *
+ * An IdentityProvider sends the user off to authenticate, then trades the authorization code
+ * it sends back for the claims it makes about the user. OIDCProvider implements this
+ * for any OpenID Connect provider, using the authorization code flow with PKCE.
+ */
+
+type IdentityProvider interface {
+	Name() string
+	// Returns the URL to send the user to, to authenticate with the provider
+	AuthCodeURL(state, nonce, codeChallenge string) (string, Error)
+	// Trades the authorization code returned by the provider for its claims about the user
+	Exchange(code, codeVerifier, nonce string) (ExternalClaims, Error)
+}
+
+// The claims an identity provider makes about a user
+type ExternalClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+var IDENTITY_PROVIDERS map[string]IdentityProvider = map[string]IdentityProvider{}
+
+func RegisterIdentityProvider(p IdentityProvider) {
+	IDENTITY_PROVIDERS[p.Name()] = p
+}
+
+func IdentityProviderNamed(name string) (IdentityProvider, Error) {
+	p, ok := IDENTITY_PROVIDERS[name]
+	if !ok {
+		return nil, NewNotFoundError(fmt.Sprintf("There is no identity provider named %s", name))
+	}
+	return p, nil
+}
+
+// Returns a random PKCE code verifier, and the S256 challenge derived from it
+func NewPKCE() (string, string, Error) {
+	verifier, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, PKCEChallenge(verifier), nil
+}
+
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// OpenID Connect
+
+type OIDCProvider struct {
+	ProviderName string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// Defaults to a client with a short timeout
+	Client *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.ProviderName
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) (string, Error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+func (p *OIDCProvider) Exchange(code, codeVerifier, nonce string) (ExternalClaims, Error) {
+	claims := ExternalClaims{}
+
+	d, err := p.discover()
+	if err != nil {
+		return claims, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, rerr := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if rerr != nil {
+		return claims, NewServerError(rerr.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, rerr := p.client().Do(req)
+	if rerr != nil {
+		return claims, NewServerError(fmt.Sprintf("Couldn't reach the identity provider: %s", rerr.Error()))
+	}
+	defer resp.Body.Close()
+
+	tokens := oidcTokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return claims, NewServerError(fmt.Sprintf("Couldn't read the identity provider's response: %s", err.Error()))
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return claims, NewUnauthorizedError(fmt.Sprintf("The identity provider rejected the sign in: %s", tokens.Error))
+	}
+
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+// Checks the ID token's signature, issuer, audience, expiry and nonce, and returns its claims
+func (p *OIDCProvider) verifyIDToken(idToken, nonce string) (ExternalClaims, Error) {
+	claims := ExternalClaims{}
+	invalid := NewUnauthorizedError("The identity provider's ID token is invalid")
+
+	token, perr := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(kid)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	})
+	if perr != nil || !token.Valid {
+		return claims, invalid
+	}
+
+	mc := token.Claims.(jwt.MapClaims)
+	if mc["iss"] != p.Issuer || mc["nonce"] != nonce || !audienceIncludes(mc["aud"], p.ClientID) {
+		return claims, invalid
+	}
+	// Expiry is only checked by the parser if it's present
+	if _, ok := mc["exp"]; !ok {
+		return claims, invalid
+	}
+
+	raw, _ := json.Marshal(mc)
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.Subject == "" {
+		return claims, invalid
+	}
+	return claims, nil
+}
+
+func audienceIncludes(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (p *OIDCProvider) getJSON(u string, dest interface{}) Error {
+	resp, err := p.client().Get(u)
+	if err != nil {
+		return NewServerError(fmt.Sprintf("Couldn't reach the identity provider: %s", err.Error()))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return NewServerError(fmt.Sprintf("The identity provider returned %d for %s", resp.StatusCode, u))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return NewServerError(fmt.Sprintf("Couldn't read the identity provider's response: %s", err.Error()))
+	}
+	return nil
+}
+
+// Fetches the provider's configuration the first time it's needed
+func (p *OIDCProvider) discover() (*oidcDiscovery, Error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := oidcDiscovery{}
+	if err := p.getJSON(strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.Issuer {
+		return nil, NewServerError(fmt.Sprintf("The identity provider's issuer is %s, not %s", d.Issuer, p.Issuer))
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, NewServerError("The identity provider's configuration is incomplete")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// Returns the provider's signing key with the given ID, fetching its keys again
+// if it's one we haven't seen, since providers rotate their keys from time to time
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := p.getJSON(d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, nerr := base64.RawURLEncoding.DecodeString(k.N)
+		e, eerr := base64.RawURLEncoding.DecodeString(k.E)
+		if nerr != nil || eerr != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key: %s", kid)
+}
+
+// Returns the key with the given ID or, if the token didn't name one, the provider's only key
+func (p *OIDCProvider) findKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// Returns the names of the registered identity providers, in order
+func IdentityProviderNames() []string {
+	names := []string{}
+	for name := range IDENTITY_PROVIDERS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
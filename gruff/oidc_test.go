@@ -0,0 +1,131 @@
+package gruff
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"github.com/GruffDebate/server/gruff/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockOIDCProvider(mock *oidctest.Provider) *OIDCProvider {
+	return &OIDCProvider{
+		ProviderName: "mock",
+		Issuer:       mock.Issuer,
+		ClientID:     mock.ClientID,
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/mock/callback",
+	}
+}
+
+func TestNewPKCE(t *testing.T) {
+	verifier, challenge, err := NewPKCE()
+	assert.NoError(t, err)
+	assert.True(t, len(verifier) >= 43)
+
+	sum := sha256.Sum256([]byte(verifier))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), challenge)
+	assert.Equal(t, challenge, PKCEChallenge(verifier))
+}
+
+func TestOIDCProviderAuthCodeURL(t *testing.T) {
+	mock := oidctest.NewProvider("gruff")
+	defer mock.Close()
+	p := mockOIDCProvider(mock)
+
+	authURL, err := p.AuthCodeURL("the-state", "the-nonce", "the-challenge")
+	assert.NoError(t, err)
+
+	u, _ := url.Parse(authURL)
+	assert.Equal(t, mock.Issuer+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	q := u.Query()
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "gruff", q.Get("client_id"))
+	assert.Equal(t, p.RedirectURL, q.Get("redirect_uri"))
+	assert.Equal(t, "openid email profile", q.Get("scope"))
+	assert.Equal(t, "the-state", q.Get("state"))
+	assert.Equal(t, "the-nonce", q.Get("nonce"))
+	assert.Equal(t, "the-challenge", q.Get("code_challenge"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	// The issuer has to match exactly
+	wrong := mockOIDCProvider(mock)
+	wrong.Issuer = mock.Issuer + "/"
+	_, err = wrong.AuthCodeURL("the-state", "the-nonce", "the-challenge")
+	assert.Error(t, err)
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	mock := oidctest.NewProvider("gruff")
+	defer mock.Close()
+	p := mockOIDCProvider(mock)
+
+	student := map[string]interface{}{
+		"sub":            "s1234567",
+		"email":          "student@campus.edu",
+		"email_verified": true,
+		"name":           "Stu Dent",
+	}
+	authorize := func(nonce, challenge string) string {
+		authURL, err := p.AuthCodeURL("state", nonce, challenge)
+		assert.NoError(t, err)
+		redirect, aerr := mock.Authorize(authURL, student)
+		assert.NoError(t, aerr)
+		return redirect.Query().Get("code")
+	}
+
+	verifier, challenge, _ := NewPKCE()
+	code := authorize("nonce", challenge)
+	claims, err := p.Exchange(code, verifier, "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, ExternalClaims{Subject: "s1234567", Email: "student@campus.edu", EmailVerified: true, Name: "Stu Dent"}, claims)
+
+	// Codes can only be used once
+	_, err = p.Exchange(code, verifier, "nonce")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+
+	// The code is useless without the verifier
+	code = authorize("nonce", challenge)
+	_, err = p.Exchange(code, "not-the-verifier", "nonce")
+	assert.Error(t, err)
+
+	// The ID token has to be for this sign in
+	code = authorize("nonce", challenge)
+	_, err = p.Exchange(code, verifier, "another-nonce")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+}
+
+func TestOIDCProviderVerifyIDToken(t *testing.T) {
+	mock := oidctest.NewProvider("gruff")
+	defer mock.Close()
+	p := mockOIDCProvider(mock)
+
+	claims, err := p.verifyIDToken(mock.IDToken(map[string]interface{}{"sub": "abc", "nonce": "n"}), "n")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", claims.Subject)
+
+	_, err = p.verifyIDToken(mock.IDToken(map[string]interface{}{"sub": "abc", "nonce": "n", "aud": "someone-else"}), "n")
+	assert.Error(t, err)
+
+	_, err = p.verifyIDToken(mock.IDToken(map[string]interface{}{"sub": "abc", "nonce": "n", "aud": []string{"someone-else", "gruff"}}), "n")
+	assert.NoError(t, err)
+
+	_, err = p.verifyIDToken(mock.IDToken(map[string]interface{}{"sub": "abc", "nonce": "n", "iss": "https://impostor.example.com"}), "n")
+	assert.Error(t, err)
+
+	_, err = p.verifyIDToken(mock.IDToken(map[string]interface{}{"sub": "abc", "nonce": "n", "exp": 1}), "n")
+	assert.Error(t, err)
+
+	_, err = p.verifyIDToken(mock.IDToken(map[string]interface{}{"nonce": "n"}), "n")
+	assert.Error(t, err)
+
+	// Tokens signed by anyone else are rejected
+	impostor := oidctest.NewProvider("gruff")
+	defer impostor.Close()
+	_, err = p.verifyIDToken(impostor.IDToken(map[string]interface{}{"sub": "abc", "nonce": "n", "iss": mock.Issuer}), "n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,233 @@
+// Package oidctest runs a local OpenID Connect provider, for testing sign in
+// through external identity providers without a real one.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const KEY_ID = "oidctest"
+
+// A Provider signs in whoever asks, as the user described by its Claims
+type Provider struct {
+	Server   *httptest.Server
+	Issuer   string
+	ClientID string
+	// The claims about the user to put in ID tokens, such as sub, email and name
+	Claims map[string]interface{}
+
+	key   *rsa.PrivateKey
+	mutex sync.Mutex
+	codes map[string]authorization
+}
+
+type authorization struct {
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	Claims        map[string]interface{}
+}
+
+// Starts a provider for the given client ID. Close it when done.
+func NewProvider(clientID string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &Provider{
+		ClientID: clientID,
+		Claims:   map[string]interface{}{},
+		key:      key,
+		codes:    map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorizeEndpoint)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	p.Issuer = p.Server.URL
+
+	return p
+}
+
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// Signs in the user described by the given claims, as if they had followed the authorization URL,
+// and returns the URL the provider sends them back to
+func (p *Provider) Authorize(authURL string, claims map[string]interface{}) (*url.URL, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+
+	if q.Get("response_type") != "code" {
+		return nil, fmt.Errorf("unsupported response_type %q", q.Get("response_type"))
+	}
+	if q.Get("client_id") != p.ClientID {
+		return nil, fmt.Errorf("unknown client_id %q", q.Get("client_id"))
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		return nil, fmt.Errorf("PKCE with S256 is required")
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("redirect_uri") == "" {
+		return nil, fmt.Errorf("invalid redirect_uri %q", q.Get("redirect_uri"))
+	}
+
+	code := randomString()
+	p.mutex.Lock()
+	p.codes[code] = authorization{
+		RedirectURI:   q.Get("redirect_uri"),
+		Nonce:         q.Get("nonce"),
+		CodeChallenge: q.Get("code_challenge"),
+		Claims:        claims,
+	}
+	p.mutex.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	return redirect, nil
+}
+
+// Returns an ID token for the given claims, signed with the provider's key
+func (p *Provider) IDToken(claims map[string]interface{}) string {
+	now := time.Now()
+	mc := jwt.MapClaims{
+		"iss": p.Issuer,
+		"aud": p.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		mc[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, mc)
+	token.Header["kid"] = KEY_ID
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// Signs in as the user described by the provider's Claims straight away
+func (p *Provider) authorizeEndpoint(w http.ResponseWriter, r *http.Request) {
+	redirect, err := p.Authorize(p.Issuer+r.URL.RequestURI(), p.Claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if user, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(user)
+	}
+	if clientID != p.ClientID || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mutex.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mutex.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if !ok || auth.RedirectURI != r.PostForm.Get("redirect_uri") || auth.CodeChallenge != challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{}
+	for k, v := range auth.Claims {
+		claims[k] = v
+	}
+	if auth.Nonce != "" {
+		claims["nonce"] = auth.Nonce
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     p.IDToken(claims),
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kid": KEY_ID,
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,241 @@
+package gruff
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+)
+
+/*
+ * A user who has forgotten their password can have a PasswordReset token emailed to them.
+ *
+ * Like refresh tokens, reset tokens are opaque random strings of which only a hash is stored.
+ * Each one expires after PASSWORD_RESET_EXPIRATION and can only be used once. Resetting
+ * the password ends all of the user's other outstanding resets, and signs them out everywhere.
+ */
+
+const PASSWORD_RESET_EXPIRATION time.Duration = 1 * time.Hour
+
+type PasswordReset struct {
+	Key       string     `json:"_key"`
+	UserKey   string     `json:"user"`
+	CreatedAt time.Time  `json:"start"`
+	ExpiresAt time.Time  `json:"expires"`
+	EndedAt   *time.Time `json:"end,omitempty"`
+}
+
+// ArangoObject interface
+
+func (r PasswordReset) CollectionName() string {
+	return "password_resets"
+}
+
+func (r PasswordReset) ArangoKey() string {
+	return r.Key
+}
+
+func (r PasswordReset) ArangoID() string {
+	return fmt.Sprintf("%s/%s", r.CollectionName(), r.ArangoKey())
+}
+
+func (r PasswordReset) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (r *PasswordReset) Create(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(r)
+	if err != nil {
+		return err
+	}
+	r.PrepareForCreate(ctx)
+	if _, err := col.CreateDocument(ctx.Context, r); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (r *PasswordReset) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Password resets cannot be modified")
+}
+
+// Ends this reset, so that its token can't be used again
+func (r *PasswordReset) Delete(ctx *ServerContext) Error {
+	r.PrepareForDelete(ctx)
+	col, err := ctx.Arango.CollectionFor(r)
+	if err != nil {
+		return err
+	}
+	if _, err := col.UpdateDocument(ctx.Context, r.ArangoKey(), Updates{"end": r.EndedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (r *PasswordReset) PrepareForCreate(ctx *ServerContext) {
+	r.CreatedAt = ctx.RequestTime()
+	r.ExpiresAt = r.CreatedAt.Add(PASSWORD_RESET_EXPIRATION)
+	r.EndedAt = nil
+}
+
+func (r *PasswordReset) PrepareForDelete(ctx *ServerContext) {
+	r.EndedAt = support.TimePtr(ctx.RequestTime())
+}
+
+// Business methods
+
+// Emails a password reset link to the user with the given email address.
+// Unknown addresses are silently ignored, so that accounts can't be discovered this way.
+// Looking up the account takes longer than ignoring an unknown address,
+// so this should only run once whoever asked has had their response.
+func RequestPasswordReset(ctx *ServerContext, email string) Error {
+	if strings.TrimSpace(email) == "" {
+		return NewBusinessError("Email: non zero value required;")
+	}
+
+	u := User{Email: email}
+	if err := u.Load(ctx); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return nil
+		}
+		return err
+	}
+	if u.DeletedAt != nil {
+		return nil
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	reset := PasswordReset{
+		Key:     hashOpaqueToken(token),
+		UserKey: u.ArangoKey(),
+	}
+	if err := reset.Create(ctx); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/password-reset?token=%s", strings.TrimRight(os.Getenv("GRUFF_URL"), "/"), url.QueryEscape(token))
+	message := Email{
+		To:      u.Email,
+		Subject: fmt.Sprintf("Reset your password for %s", os.Getenv("GRUFF_NAME")),
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, follow this link to choose a new one:\n\n%s\n\nThe link expires in %d minutes. If you didn't ask for this, you can ignore this email.\n",
+			u.Name,
+			link,
+			int(PASSWORD_RESET_EXPIRATION.Minutes())),
+	}
+	return MAILER.Send(message)
+}
+
+// Returns the time remaining before another password reset may be requested
+// for the given email address, or zero if it is allowed now
+func PasswordResetLockedFor(ctx *ServerContext, email string) (time.Duration, Error) {
+	throttle, err := LoadLoginThrottle(ctx, LOGIN_THROTTLE_PASSWORD_RESET, email)
+	if err != nil {
+		return 0, err
+	}
+	return throttle.LockedFor(ctx.RequestTime()), nil
+}
+
+// Counts a password reset requested for the email address, whether or not it belongs to an account
+func RecordPasswordResetRequest(ctx *ServerContext, email string) Error {
+	throttle, err := LoadLoginThrottle(ctx, LOGIN_THROTTLE_PASSWORD_RESET, email)
+	if err != nil {
+		return err
+	}
+	return throttle.RecordFailure(ctx)
+}
+
+// Sets a new password for the user the token was issued to, returning that user.
+// The token is used up, and all of the user's other resets and sessions are ended.
+func ResetPassword(ctx *ServerContext, token, password string) (User, Error) {
+	u := User{}
+	invalid := NewBusinessError("This password reset link is invalid or has expired", ERROR_SUBCODE_RESET_TOKEN_INVALID)
+
+	if token == "" {
+		return u, invalid
+	}
+	reset := PasswordReset{}
+	if err := LoadArangoObject(ctx, &reset, hashOpaqueToken(token)); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return u, invalid
+		}
+		return u, err
+	}
+	if reset.EndedAt != nil || !reset.ExpiresAt.After(ctx.RequestTime()) {
+		return u, invalid
+	}
+
+	u.Key = reset.UserKey
+	if err := u.Load(ctx); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return u, invalid
+		}
+		return u, err
+	}
+	if u.DeletedAt != nil {
+		return u, invalid
+	}
+
+	// Check the new password before using up the token, so that a typo doesn't waste it
+	u.Password = password
+	if err := u.ValidateField("Password"); err != nil {
+		u.Password = ""
+		return u, err
+	}
+
+	ended, err := reset.end(ctx)
+	if err != nil {
+		return u, err
+	}
+	if !ended {
+		return u, invalid
+	}
+
+	if err := u.setPassword(ctx); err != nil {
+		return u, err
+	}
+
+	bindVars := BindVars{
+		"user": u.ArangoKey(),
+	}
+	if err := DeleteArangoObjects(ctx, reset.CollectionName(), "obj.user == @user", bindVars); err != nil {
+		return u, err
+	}
+	if err := u.RevokeTokens(ctx); err != nil {
+		return u, err
+	}
+
+	// Whoever can read the user's email can sign in, so there's no need to keep them locked out
+	if err := RecordLoginSuccess(ctx, u.Email); err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// Ends the reset unless it has already been ended, returning true if this call ended it
+func (r *PasswordReset) end(ctx *ServerContext) (bool, Error) {
+	r.PrepareForDelete(ctx)
+	bindVars := BindVars{
+		"key": r.Key,
+		"end": r.EndedAt,
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                               FILTER obj._key == @key
+                                  AND obj.end == null
+                               UPDATE obj WITH { end: @end } IN %s
+                               RETURN NEW._key`,
+		r.CollectionName(),
+		r.CollectionName())
+	cursor, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return false, NewServerError(err.Error())
+	}
+	return cursor.HasMore(), nil
+}
@@ -0,0 +1,130 @@
+package gruff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResetPassword(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	mailer := &recordingMailer{}
+	MAILER = mailer
+	defer func() { MAILER = LogMailer{} }()
+
+	u := User{Name: "Forgetful", Username: "Forgetful", Email: "forgetful@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Unknown addresses get no email, but no error either
+	err = RequestPasswordReset(CTX, "nobody@gruff.org")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Len(t, mailer.sent, 0)
+
+	err = RequestPasswordReset(CTX, "Forgetful@gruff.org")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = RequestPasswordReset(CTX, "forgetful@gruff.org")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Len(t, mailer.sent, 2)
+	first := tokenFromEmail(mailer.sent[0])
+	second := tokenFromEmail(mailer.sent[1])
+	assert.NotEmpty(t, first)
+	assert.NotEqual(t, first, second)
+
+	_, err = ResetPassword(CTX, "not-a-token", "654321")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_RESET_TOKEN_INVALID, err.Subcode())
+	CTX.RequestAt = nil
+
+	// An invalid password doesn't use up the token
+	_, err = ResetPassword(CTX, first, "123")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_PASSWORD_LENGTH, err.Subcode())
+	CTX.RequestAt = nil
+
+	user, err := ResetPassword(CTX, first, "654321")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, u.ArangoKey(), user.ArangoKey())
+	assert.NotNil(t, user.TokensRevokedAt)
+
+	u.Load(CTX)
+	ok, _ := u.VerifyPassword(CTX, "654321")
+	assert.True(t, ok)
+	ok, _ = u.VerifyPassword(CTX, "123456")
+	assert.False(t, ok)
+
+	// Each token can only be used once, and using one ends the others
+	_, err = ResetPassword(CTX, first, "abcdef")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_RESET_TOKEN_INVALID, err.Subcode())
+	CTX.RequestAt = nil
+
+	_, err = ResetPassword(CTX, second, "abcdef")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_RESET_TOKEN_INVALID, err.Subcode())
+	CTX.RequestAt = nil
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Name: "Slowpoke", Username: "Slowpoke", Email: "slowpoke@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	token, err := newOpaqueToken()
+	assert.NoError(t, err)
+	reset := PasswordReset{Key: hashOpaqueToken(token), UserKey: u.ArangoKey()}
+	err = reset.Create(CTX)
+	assert.NoError(t, err)
+
+	later := reset.CreatedAt.Add(PASSWORD_RESET_EXPIRATION)
+	CTX.RequestAt = &later
+	_, err = ResetPassword(CTX, token, "654321")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_RESET_TOKEN_INVALID, err.Subcode())
+	CTX.RequestAt = nil
+}
+
+func TestPasswordResetThrottle(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	email := "impatient@gruff.org"
+	for i := 0; i < LOGIN_THROTTLE_PASSWORD_RESET_ATTEMPTS; i++ {
+		err := RecordPasswordResetRequest(CTX, email)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+	}
+
+	wait, err := PasswordResetLockedFor(CTX, email)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, time.Duration(0), wait)
+
+	err = RecordPasswordResetRequest(CTX, email)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	wait, err = PasswordResetLockedFor(CTX, "Impatient@gruff.org")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.True(t, wait > 0)
+	assert.True(t, wait <= LOGIN_LOCKOUT_BASE)
+
+	// Resets don't count against signing in, nor the other way around
+	wait, err = LoginLockedFor(CTX, email, "10.0.0.1")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, time.Duration(0), wait)
+}
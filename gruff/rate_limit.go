@@ -0,0 +1,219 @@
+package gruff
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+ * Requests are rate limited with token buckets. Each bucket holds up to Capacity tokens,
+ * and is refilled at a steady Capacity tokens every Per. Every request takes a token,
+ * and once a bucket is empty, requests have to wait for it to refill.
+ *
+ * Buckets are kept in a RateLimitStore. MemoryRateLimitStore keeps them in the server's memory,
+ * which is fine for a single server. When several servers share the load, ArangoRateLimitStore
+ * keeps them in the database, so that they all draw on the same buckets.
+ */
+
+const RATE_LIMIT_READ string = "read"
+const RATE_LIMIT_WRITE string = "write"
+const RATE_LIMIT_SCORE string = "score"
+
+type RateLimit struct {
+	Capacity int
+	Per      time.Duration
+}
+
+// Returns true if the limit actually limits anything
+func (l RateLimit) Enabled() bool {
+	return l.Capacity > 0 && l.Per > 0
+}
+
+// Tokens added to a bucket per millisecond
+func (l RateLimit) rate() float64 {
+	return float64(l.Capacity) / float64(l.Per/time.Millisecond)
+}
+
+// The limits for each kind of request. Kinds without a limit aren't limited.
+var RATE_LIMITS map[string]RateLimit = map[string]RateLimit{}
+
+type RateLimitStore interface {
+	// Takes a token from the bucket with the given key, returning how long to wait
+	// for the next one if the bucket is empty
+	Take(ctx *ServerContext, key string, limit RateLimit) (time.Duration, Error)
+}
+
+var RATE_LIMIT_STORE RateLimitStore = NewMemoryRateLimitStore()
+
+func RateLimitStoreNamed(name string) (RateLimitStore, Error) {
+	switch name {
+	case "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "arango":
+		return ArangoRateLimitStore{}, nil
+	}
+	return nil, NewNotFoundError(fmt.Sprintf("There is no rate limit store named %s", name))
+}
+
+// Takes a token for the given kind of request from the bucket with the given key,
+// returning how long to wait for the next one if the bucket is empty
+func TakeRateLimitToken(ctx *ServerContext, kind, key string) (time.Duration, Error) {
+	limit, ok := RATE_LIMITS[kind]
+	if !ok || !limit.Enabled() {
+		return 0, nil
+	}
+	return RATE_LIMIT_STORE.Take(ctx, fmt.Sprintf("%s:%s", kind, key), limit)
+}
+
+// Returns how long it takes to refill a bucket from the given number of tokens to one
+func rateLimitWait(tokens float64, limit RateLimit) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	ms := math.Ceil((1 - tokens) / limit.rate())
+	return time.Duration(ms) * time.Millisecond
+}
+
+// In memory
+
+// Buckets are pruned once there are this many, to keep memory in check
+const MEMORY_RATE_LIMIT_MAX_BUCKETS int = 100000
+
+type MemoryRateLimitStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+	Limit     RateLimit
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx *ServerContext, key string, limit RateLimit) (time.Duration, Error) {
+	return s.take(key, limit, time.Now()), nil
+}
+
+func (s *MemoryRateLimitStore) take(key string, limit RateLimit, now time.Time) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= MEMORY_RATE_LIMIT_MAX_BUCKETS {
+			s.prune(now)
+		}
+		b = &memoryBucket{Tokens: float64(limit.Capacity), UpdatedAt: now}
+		s.buckets[key] = b
+	}
+	b.Limit = limit
+	b.refill(now)
+
+	if b.Tokens < 1 {
+		return rateLimitWait(b.Tokens, limit)
+	}
+	b.Tokens--
+	return 0
+}
+
+func (b *memoryBucket) refill(now time.Time) {
+	elapsed := float64(now.Sub(b.UpdatedAt) / time.Millisecond)
+	if elapsed > 0 {
+		b.Tokens = math.Min(float64(b.Limit.Capacity), b.Tokens+elapsed*b.Limit.rate())
+		b.UpdatedAt = now
+	}
+}
+
+// Forgets the buckets that have filled up again, since they're the same as new ones
+func (s *MemoryRateLimitStore) prune(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.Tokens >= float64(b.Limit.Capacity) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// In the database
+
+// A RateLimitBucket is a token bucket stored in the database by ArangoRateLimitStore.
+// Its times are in milliseconds, as told by the database server's clock,
+// so that the servers sharing it needn't agree on the time.
+type RateLimitBucket struct {
+	Key       string  `json:"_key"`
+	Tokens    float64 `json:"tokens"`
+	UpdatedAt int64   `json:"updated"`
+	Allowed   bool    `json:"allowed"`
+}
+
+// ArangoObject interface
+
+func (b RateLimitBucket) CollectionName() string {
+	return "rate_limits"
+}
+
+func (b RateLimitBucket) ArangoKey() string {
+	return b.Key
+}
+
+func (b RateLimitBucket) ArangoID() string {
+	return fmt.Sprintf("%s/%s", b.CollectionName(), b.ArangoKey())
+}
+
+func (b RateLimitBucket) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (b *RateLimitBucket) Create(ctx *ServerContext) Error {
+	return NewServerError("Rate limit buckets are only changed by taking tokens")
+}
+
+func (b *RateLimitBucket) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Rate limit buckets are only changed by taking tokens")
+}
+
+func (b *RateLimitBucket) Delete(ctx *ServerContext) Error {
+	return NewServerError("Rate limit buckets are only changed by taking tokens")
+}
+
+func (b *RateLimitBucket) PrepareForCreate(ctx *ServerContext) {
+}
+
+func (b *RateLimitBucket) PrepareForDelete(ctx *ServerContext) {
+}
+
+type ArangoRateLimitStore struct{}
+
+// Refills the bucket and takes a token in a single query, so that two servers
+// can't both take the last one
+func (s ArangoRateLimitStore) Take(ctx *ServerContext, key string, limit RateLimit) (time.Duration, Error) {
+	bindVars := BindVars{
+		"key":      hashOpaqueToken(key),
+		"capacity": float64(limit.Capacity),
+		"rate":     limit.rate(),
+	}
+	refilled := "MIN([@capacity, OLD.tokens + MAX([0, now - OLD.updated]) * @rate])"
+	query := fmt.Sprintf(`LET now = DATE_NOW()
+                               UPSERT { _key: @key }
+                               INSERT { _key: @key, tokens: @capacity - 1, updated: now, allowed: true }
+                               UPDATE { tokens: %[1]s >= 1 ? %[1]s - 1 : %[1]s, updated: now, allowed: %[1]s >= 1 }
+                               IN %[2]s
+                               RETURN NEW`,
+		refilled,
+		RateLimitBucket{}.CollectionName())
+
+	b := RateLimitBucket{}
+	if err := FindArangoObject(ctx, query, bindVars, &b); err != nil {
+		return 0, err
+	}
+	if b.Allowed {
+		return 0, nil
+	}
+	return rateLimitWait(b.Tokens, limit), nil
+}
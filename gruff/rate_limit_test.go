@@ -0,0 +1,94 @@
+package gruff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Capacity: 3, Per: time.Minute}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), store.take("user:abc", limit, now))
+	}
+	assert.Equal(t, 20*time.Second, store.take("user:abc", limit, now))
+
+	// Other buckets are unaffected
+	assert.Equal(t, time.Duration(0), store.take("user:def", limit, now))
+
+	// A token is added every 20 seconds
+	assert.Equal(t, 5*time.Second, store.take("user:abc", limit, now.Add(15*time.Second)))
+	assert.Equal(t, time.Duration(0), store.take("user:abc", limit, now.Add(20*time.Second)))
+	assert.Equal(t, 20*time.Second, store.take("user:abc", limit, now.Add(20*time.Second)))
+
+	// But the bucket never holds more than its capacity
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), store.take("user:abc", limit, later))
+	}
+	assert.True(t, store.take("user:abc", limit, later) > 0)
+
+	store.prune(later.Add(time.Hour))
+	assert.Len(t, store.buckets, 0)
+}
+
+func TestRateLimitStoreNamed(t *testing.T) {
+	store, err := RateLimitStoreNamed("memory")
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryRateLimitStore{}, store)
+
+	store, err = RateLimitStoreNamed("arango")
+	assert.NoError(t, err)
+	assert.IsType(t, ArangoRateLimitStore{}, store)
+
+	_, err = RateLimitStoreNamed("redis")
+	assert.Error(t, err)
+}
+
+func TestTakeRateLimitToken(t *testing.T) {
+	defer func() {
+		RATE_LIMITS = map[string]RateLimit{}
+		RATE_LIMIT_STORE = NewMemoryRateLimitStore()
+	}()
+
+	// Kinds of requests without limits aren't limited
+	RATE_LIMITS = map[string]RateLimit{RATE_LIMIT_WRITE: {Capacity: 1, Per: time.Minute}}
+	for i := 0; i < 5; i++ {
+		wait, err := TakeRateLimitToken(CTX, RATE_LIMIT_READ, "user:abc")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+
+	wait, err := TakeRateLimitToken(CTX, RATE_LIMIT_WRITE, "user:abc")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, err = TakeRateLimitToken(CTX, RATE_LIMIT_WRITE, "user:abc")
+	assert.NoError(t, err)
+	assert.True(t, wait > 0)
+}
+
+func TestArangoRateLimitStore(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	store := ArangoRateLimitStore{}
+	limit := RateLimit{Capacity: 2, Per: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		wait, err := store.Take(CTX, "ip:10.0.0.1", limit)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+	wait, err := store.Take(CTX, "ip:10.0.0.1", limit)
+	assert.NoError(t, err)
+	assert.True(t, wait > 29*time.Minute)
+	assert.True(t, wait <= 30*time.Minute)
+
+	wait, err = store.Take(CTX, "ip:2001:db8::1", limit)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+}
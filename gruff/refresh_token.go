@@ -0,0 +1,336 @@
+package gruff
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+	"github.com/google/uuid"
+)
+
+/*
+ * Access tokens (JWTs) are short-lived, and are renewed by trading in a RefreshToken.
+ *
+ * Refresh tokens are opaque random strings, of which only a hash is stored.
+ * Each one can only be used once: using it ends it and issues its replacement
+ * in the same family. If an ended token is ever presented again, it must have
+ * been stolen, so the whole family is revoked and the user has to sign in again.
+ *
+ * Access tokens can be cut off before they expire by adding their ID to the
+ * revocation list, or by revoking all of a user's tokens at once.
+ */
+
+const REFRESH_TOKEN_BYTES int = 32
+
+type RefreshToken struct {
+	Key       string     `json:"_key"`
+	UserKey   string     `json:"user"`
+	Family    string     `json:"family"`
+	CreatedAt time.Time  `json:"start"`
+	ExpiresAt time.Time  `json:"expires"`
+	EndedAt   *time.Time `json:"end,omitempty"`
+}
+
+// ArangoObject interface
+
+func (t RefreshToken) CollectionName() string {
+	return "refresh_tokens"
+}
+
+func (t RefreshToken) ArangoKey() string {
+	return t.Key
+}
+
+func (t RefreshToken) ArangoID() string {
+	return fmt.Sprintf("%s/%s", t.CollectionName(), t.ArangoKey())
+}
+
+func (t RefreshToken) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (t *RefreshToken) Create(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(t)
+	if err != nil {
+		return err
+	}
+	t.PrepareForCreate(ctx)
+	if _, err := col.CreateDocument(ctx.Context, t); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (t *RefreshToken) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Refresh tokens cannot be modified")
+}
+
+// Ends this token, so that it can't be used again
+func (t *RefreshToken) Delete(ctx *ServerContext) Error {
+	t.PrepareForDelete(ctx)
+	col, err := ctx.Arango.CollectionFor(t)
+	if err != nil {
+		return err
+	}
+	if _, err := col.UpdateDocument(ctx.Context, t.ArangoKey(), Updates{"end": t.EndedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (t *RefreshToken) PrepareForCreate(ctx *ServerContext) {
+	t.CreatedAt = ctx.RequestTime()
+	t.EndedAt = nil
+	if t.Family == "" {
+		t.Family = uuid.New().String()
+	}
+}
+
+func (t *RefreshToken) PrepareForDelete(ctx *ServerContext) {
+	t.EndedAt = support.TimePtr(ctx.RequestTime())
+}
+
+// A RevokedToken lists the ID of an access token that must no longer be accepted.
+// It only needs to be kept until the token would have expired anyway.
+type RevokedToken struct {
+	Key       string    `json:"_key"`
+	UserKey   string    `json:"user"`
+	ExpiresAt time.Time `json:"expires"`
+}
+
+// ArangoObject interface
+
+func (t RevokedToken) CollectionName() string {
+	return "revoked_tokens"
+}
+
+func (t RevokedToken) ArangoKey() string {
+	return t.Key
+}
+
+func (t RevokedToken) ArangoID() string {
+	return fmt.Sprintf("%s/%s", t.CollectionName(), t.ArangoKey())
+}
+
+func (t RevokedToken) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+func (t *RevokedToken) Create(ctx *ServerContext) Error {
+	bindVars := BindVars{
+		"key":     t.Key,
+		"user":    t.UserKey,
+		"expires": t.ExpiresAt,
+	}
+	query := fmt.Sprintf(`UPSERT { _key: @key }
+                               INSERT { _key: @key, user: @user, expires: @expires }
+                               UPDATE {}
+                               IN %s`,
+		t.CollectionName())
+	if _, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (t *RevokedToken) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Revoked tokens cannot be modified")
+}
+
+func (t *RevokedToken) Delete(ctx *ServerContext) Error {
+	return NewServerError("Revoked tokens cannot be reinstated")
+}
+
+func (t *RevokedToken) PrepareForCreate(ctx *ServerContext) {
+}
+
+func (t *RevokedToken) PrepareForDelete(ctx *ServerContext) {
+}
+
+// Business methods
+
+// Opaque tokens are random strings, of which only a hash is ever stored
+func newOpaqueToken() (string, Error) {
+	b := make([]byte, REFRESH_TOKEN_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", NewServerError(err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashOpaqueToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// Issues a new refresh token for the user, in the given family (or a new one if family is empty)
+func IssueRefreshToken(ctx *ServerContext, u User, family string) (string, Error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	rt := RefreshToken{
+		Key:       hashOpaqueToken(token),
+		UserKey:   u.ArangoKey(),
+		Family:    family,
+		ExpiresAt: RefreshTokenExpirationDate(),
+	}
+	if err := rt.Create(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func loadRefreshToken(ctx *ServerContext, token string) (RefreshToken, Error) {
+	rt := RefreshToken{}
+	if token == "" {
+		return rt, NewUnauthorizedError("Unauthorized")
+	}
+	if err := LoadArangoObject(ctx, &rt, hashOpaqueToken(token)); err != nil {
+		if err.Code() == ERROR_CODE_NOT_FOUND {
+			return rt, NewUnauthorizedError("Unauthorized")
+		}
+		return rt, err
+	}
+	return rt, nil
+}
+
+// Trades in a refresh token for its replacement, returning the user it belongs to.
+// Presenting a token that was already used revokes its whole family.
+func UseRefreshToken(ctx *ServerContext, token string) (User, string, Error) {
+	u := User{}
+
+	rt, err := loadRefreshToken(ctx, token)
+	if err != nil {
+		return u, "", err
+	}
+
+	if rt.EndedAt != nil {
+		if err := RevokeRefreshTokens(ctx, "family", rt.Family); err != nil {
+			return u, "", err
+		}
+		return u, "", NewUnauthorizedError("Unauthorized")
+	}
+	if !rt.ExpiresAt.After(ctx.RequestTime()) {
+		return u, "", NewUnauthorizedError("Unauthorized")
+	}
+
+	u.Key = rt.UserKey
+	if err := u.Load(ctx); err != nil {
+		return u, "", NewUnauthorizedError("Unauthorized")
+	}
+	if u.DeletedAt != nil {
+		return u, "", NewUnauthorizedError("Unauthorized")
+	}
+	if err := u.CheckBanned(); err != nil {
+		return u, "", err
+	}
+
+	ended, err := rt.end(ctx)
+	if err != nil {
+		return u, "", err
+	}
+	if !ended {
+		// Someone else traded it in first
+		if err := RevokeRefreshTokens(ctx, "family", rt.Family); err != nil {
+			return u, "", err
+		}
+		return u, "", NewUnauthorizedError("Unauthorized")
+	}
+
+	replacement, err := IssueRefreshToken(ctx, u, rt.Family)
+	if err != nil {
+		return u, "", err
+	}
+	return u, replacement, nil
+}
+
+// Ends the token unless it has already been ended, returning true if this call ended it,
+// so that a token presented twice at the same time can still only be used once
+func (t *RefreshToken) end(ctx *ServerContext) (bool, Error) {
+	t.PrepareForDelete(ctx)
+	bindVars := BindVars{
+		"key": t.Key,
+		"end": t.EndedAt,
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                               FILTER obj._key == @key
+                                  AND obj.end == null
+                               UPDATE obj WITH { end: @end } IN %s
+                               RETURN NEW._key`,
+		t.CollectionName(),
+		t.CollectionName())
+	cursor, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return false, NewServerError(err.Error())
+	}
+	return cursor.HasMore(), nil
+}
+
+// Revokes the family of the given refresh token, so that neither it nor its replacements can be used
+func RevokeRefreshToken(ctx *ServerContext, token string) Error {
+	rt, err := loadRefreshToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	return RevokeRefreshTokens(ctx, "family", rt.Family)
+}
+
+// Ends all the active refresh tokens with the given value for the given field ("family" or "user")
+func RevokeRefreshTokens(ctx *ServerContext, field, value string) Error {
+	bindVars := BindVars{
+		"value": value,
+	}
+	filter := fmt.Sprintf("obj.%s == @value", field)
+	return DeleteArangoObjects(ctx, RefreshToken{}.CollectionName(), filter, bindVars)
+}
+
+// Adds the access token's ID to the revocation list
+func RevokeJWToken(ctx *ServerContext, jti, userKey string, exp time.Time) Error {
+	if jti == "" {
+		return nil
+	}
+	rt := RevokedToken{
+		Key:       jti,
+		UserKey:   userKey,
+		ExpiresAt: exp,
+	}
+	return rt.Create(ctx)
+}
+
+func IsJWTokenRevoked(ctx *ServerContext, jti string) (bool, Error) {
+	col, err := ctx.Arango.CollectionFor(&RevokedToken{})
+	if err != nil {
+		return false, err
+	}
+	exists, dberr := col.DocumentExists(ctx.Context, jti)
+	if dberr != nil {
+		return false, NewServerError(dberr.Error())
+	}
+	return exists, nil
+}
+
+// Rejects every access and refresh token issued to this user until now
+func (u *User) RevokeTokens(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(u)
+	if err != nil {
+		return err
+	}
+
+	u.TokensRevokedAt = support.TimePtr(ctx.RequestTime())
+	if _, err := col.UpdateDocument(ctx.Context, u.ArangoKey(), Updates{"tokensRevokedAt": u.TokensRevokedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+
+	return RevokeRefreshTokens(ctx, "user", u.ArangoKey())
+}
+
+// Returns true if a token issued at the given time was issued before the user's tokens were revoked.
+// Tokens only record when they were issued to the microsecond.
+func (u User) TokenRevoked(issuedAt time.Time) bool {
+	return u.TokensRevokedAt != nil && !issuedAt.After(u.TokensRevokedAt.Truncate(time.Microsecond))
+}
@@ -0,0 +1,144 @@
+package gruff
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUseRefreshToken(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Username: "Refresher", Email: "refresher@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	first, err := IssueRefreshToken(CTX, u, "")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.NotEmpty(t, first)
+
+	user, second, err := UseRefreshToken(CTX, first)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, u.ArangoKey(), user.ArangoKey())
+	assert.NotEmpty(t, second)
+	assert.NotEqual(t, first, second)
+
+	// Using a token again gives away that it was stolen, so the whole family is revoked
+	_, _, err = UseRefreshToken(CTX, first)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+	CTX.RequestAt = nil
+
+	_, _, err = UseRefreshToken(CTX, second)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+	CTX.RequestAt = nil
+
+	_, _, err = UseRefreshToken(CTX, "not-a-real-token")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_UNAUTHORIZED_ERROR, err.Code())
+	CTX.RequestAt = nil
+
+	// Signing out revokes a family, too
+	third, err := IssueRefreshToken(CTX, u, "")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = RevokeRefreshToken(CTX, third)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	_, _, err = UseRefreshToken(CTX, third)
+	assert.Error(t, err)
+	CTX.RequestAt = nil
+}
+
+func TestRevokeJWToken(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	revoked, err := IsJWTokenRevoked(CTX, "some-token-id")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	err = RevokeJWToken(CTX, "some-token-id", "some-user", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	// Revoking twice is harmless
+	err = RevokeJWToken(CTX, "some-token-id", "some-user", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	revoked, err = IsJWTokenRevoked(CTX, "some-token-id")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestUserRevokeTokens(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Username: "Banned", Email: "banned@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.False(t, u.TokenRevoked(time.Now()))
+
+	refresh, err := IssueRefreshToken(CTX, u, "")
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	issuedAt := time.Now().Add(-time.Minute)
+	err = u.RevokeTokens(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.True(t, u.TokenRevoked(issuedAt))
+	assert.False(t, u.TokenRevoked(time.Now().Add(time.Minute)))
+
+	saved := User{}
+	saved.Key = u.Key
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.True(t, saved.TokenRevoked(issuedAt))
+
+	_, _, err = UseRefreshToken(CTX, refresh)
+	assert.Error(t, err)
+	CTX.RequestAt = nil
+
+	// Signing in again straight away works, even within the same second
+	token, terr := IssueJWToken(saved.Key, saved.RoleNames(), time.Now().Add(time.Hour))
+	assert.NoError(t, terr)
+	parsed, terr := VerifyJWTToken(token, os.Getenv("JWT_KEY_SIGNIN"))
+	assert.NoError(t, terr)
+	assert.False(t, saved.TokenRevoked(JWTokenIssuedAt(parsed.Claims.(jwt.MapClaims))))
+}
+
+func TestUserTokenRevoked(t *testing.T) {
+	u := User{}
+	assert.False(t, u.TokenRevoked(time.Unix(0, 0)))
+
+	u.TokensRevokedAt = support.TimePtr(time.Unix(1000, 500000000))
+	assert.True(t, u.TokenRevoked(time.Unix(0, 0)))
+	assert.True(t, u.TokenRevoked(time.Unix(1000, 0)))
+	assert.True(t, u.TokenRevoked(time.Unix(1000, 500000000)))
+	assert.False(t, u.TokenRevoked(time.Unix(1000, 501000000)))
+	assert.False(t, u.TokenRevoked(time.Unix(1001, 0)))
+}
+
+func TestJWTokenIssuedAt(t *testing.T) {
+	token, err := IssueJWToken("abc", nil, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	parsed, err := VerifyJWTToken(token, os.Getenv("JWT_KEY_SIGNIN"))
+	assert.NoError(t, err)
+
+	issuedAt := JWTokenIssuedAt(parsed.Claims.(jwt.MapClaims))
+	assert.WithinDuration(t, time.Now(), issuedAt, time.Second)
+
+	// Tokens issued before the issue time had microseconds still work
+	assert.Equal(t, time.Unix(1000, 0), JWTokenIssuedAt(jwt.MapClaims{"iat": float64(1000)}))
+}
@@ -0,0 +1,410 @@
+package gruff
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	arango "github.com/arangodb/go-driver"
+)
+
+/*
+ * What a user may do is decided by the permissions of the roles they have been granted.
+ *
+ * Every logged in user has the "user" role. The built-in roles are defined in DEFAULT_ROLES,
+ * but any role (built-in or not) can be defined or redefined by saving a Role,
+ * whose permissions then replace the built-in ones.
+ *
+ * The old Curator and Admin flags on User still grant the curator and admin roles,
+ * and are kept in step with them when those roles are granted or revoked.
+ *
+ * Users with the role.grant permission can only grant or revoke roles whose permissions
+ * they have themselves, so only admins can hand out "*".
+ */
+
+const PERMISSION_ALL string = "*"
+
+const PERMISSION_CLAIM_EDIT_ANY string = "claim.edit.any"
+const PERMISSION_CLAIM_DELETE_ANY string = "claim.delete.any"
+const PERMISSION_ARGUMENT_EDIT_ANY string = "argument.edit.any"
+const PERMISSION_ARGUMENT_DELETE_ANY string = "argument.delete.any"
+const PERMISSION_ARGUMENT_MOVE string = "argument.move"
+const PERMISSION_CONTEXT_EDIT string = "context.edit"
+const PERMISSION_CONTEXT_DELETE string = "context.delete"
+const PERMISSION_USER_VIEW_ANY string = "user.view.any"
+const PERMISSION_USER_EDIT_ANY string = "user.edit.any"
+const PERMISSION_USER_DELETE string = "user.delete"
+const PERMISSION_USER_BAN string = "user.ban"
+const PERMISSION_ROLE_GRANT string = "role.grant"
+const PERMISSION_ROLE_EDIT string = "role.edit"
+const PERMISSION_SCORE_QUEUE_VIEW string = "score.queue.view"
+const PERMISSION_CLIENT_EDIT string = "client.edit"
+
+const ROLE_USER string = "user"
+const ROLE_CURATOR string = "curator"
+const ROLE_ADMIN string = "admin"
+
+var DEFAULT_ROLES map[string][]string = map[string][]string{
+	ROLE_USER: []string{},
+	ROLE_CURATOR: []string{
+		PERMISSION_CLAIM_EDIT_ANY,
+		PERMISSION_CLAIM_DELETE_ANY,
+		PERMISSION_ARGUMENT_EDIT_ANY,
+		PERMISSION_ARGUMENT_DELETE_ANY,
+		PERMISSION_ARGUMENT_MOVE,
+		PERMISSION_CONTEXT_EDIT,
+		PERMISSION_CONTEXT_DELETE,
+		PERMISSION_USER_VIEW_ANY,
+		PERMISSION_USER_EDIT_ANY,
+		PERMISSION_USER_DELETE,
+	},
+	ROLE_ADMIN: []string{
+		PERMISSION_ALL,
+	},
+}
+
+type Role struct {
+	Key         string   `json:"_key"`
+	Description string   `json:"desc,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+var roleNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+var permissionRegexp = regexp.MustCompile(`^(\*|[a-z][a-z0-9_-]*(\.[a-z][a-z0-9_-]*)*)$`)
+
+// ArangoObject interface
+
+func (r Role) CollectionName() string {
+	return "roles"
+}
+
+func (r Role) ArangoKey() string {
+	return r.Key
+}
+
+func (r Role) ArangoID() string {
+	return fmt.Sprintf("%s/%s", r.CollectionName(), r.ArangoKey())
+}
+
+func (r Role) DefaultQueryParameters() ArangoQueryParameters {
+	return DEFAULT_QUERY_PARAMETERS
+}
+
+// Saves the role, replacing any earlier definition with the same name
+func (r *Role) Create(ctx *ServerContext) Error {
+	r.PrepareForCreate(ctx)
+	if err := r.ValidateForCreate(); err != nil {
+		return err
+	}
+
+	bindVars := BindVars{
+		"key":         r.Key,
+		"desc":        r.Description,
+		"permissions": r.Permissions,
+	}
+	query := fmt.Sprintf(`UPSERT { _key: @key }
+                               INSERT { _key: @key, desc: @desc, permissions: @permissions }
+                               REPLACE { _key: @key, desc: @desc, permissions: @permissions }
+                               IN %s`,
+		r.CollectionName())
+	if _, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (r *Role) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Roles are replaced, rather than modified")
+}
+
+// Removes the stored definition, so that a built-in role goes back to its default permissions
+func (r *Role) Delete(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(r)
+	if err != nil {
+		return err
+	}
+	if _, err := col.RemoveDocument(ctx.Context, r.ArangoKey()); err != nil {
+		if arango.IsNotFound(err) {
+			return NewNotFoundError("Not Found")
+		}
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (r *Role) PrepareForCreate(ctx *ServerContext) {
+	if r.Permissions == nil {
+		r.Permissions = []string{}
+	}
+	sort.Strings(r.Permissions)
+}
+
+func (r *Role) PrepareForDelete(ctx *ServerContext) {
+}
+
+// Validator
+
+func (r Role) ValidateForCreate() Error {
+	if !roleNameRegexp.MatchString(r.Key) {
+		return NewBusinessError("Name: must be 2 to 50 lowercase letters, digits, dashes or underscores, starting with a letter;")
+	}
+	for _, p := range r.Permissions {
+		if !permissionRegexp.MatchString(p) {
+			return NewBusinessError(fmt.Sprintf("Permissions: %q is not a valid permission;", p))
+		}
+	}
+	return nil
+}
+
+// Business methods
+
+// Returns the roles with the given names, using the built-in definitions of any that haven't been saved.
+// Names that are neither saved nor built-in are left out.
+func LoadRoles(ctx *ServerContext, names []string) ([]Role, Error) {
+	roles := []Role{}
+	if len(names) == 0 {
+		return roles, nil
+	}
+
+	saved := []Role{}
+	bindVars := BindVars{
+		"names": names,
+	}
+	query := fmt.Sprintf("FOR obj IN %s FILTER obj._key IN @names RETURN obj", Role{}.CollectionName())
+	if err := FindArangoObjects(ctx, query, bindVars, &saved); err != nil {
+		return roles, err
+	}
+	byName := map[string]Role{}
+	for _, r := range saved {
+		byName[r.Key] = r
+	}
+
+	for _, name := range names {
+		if r, ok := byName[name]; ok {
+			roles = append(roles, r)
+		} else if perms, ok := DEFAULT_ROLES[name]; ok {
+			roles = append(roles, Role{Key: name, Permissions: perms})
+		}
+	}
+	return roles, nil
+}
+
+// Returns every saved role, along with the built-in roles that haven't been redefined
+func ListRoles(ctx *ServerContext) ([]Role, Error) {
+	roles := []Role{}
+	query := fmt.Sprintf("FOR obj IN %s SORT obj._key RETURN obj", Role{}.CollectionName())
+	if err := FindArangoObjects(ctx, query, BindVars{}, &roles); err != nil {
+		return roles, err
+	}
+
+	saved := map[string]bool{}
+	for _, r := range roles {
+		saved[r.Key] = true
+	}
+	for name, perms := range DEFAULT_ROLES {
+		if !saved[name] {
+			roles = append(roles, Role{Key: name, Permissions: perms})
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Key < roles[j].Key })
+	return roles, nil
+}
+
+// Returns the names of all the roles the user has
+func (u User) RoleNames() []string {
+	names := []string{}
+	if u.ArangoKey() == "" {
+		return names
+	}
+
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(ROLE_USER)
+	if u.Curator {
+		add(ROLE_CURATOR)
+	}
+	if u.Admin {
+		add(ROLE_ADMIN)
+	}
+	for _, name := range u.Roles {
+		add(name)
+	}
+	return names
+}
+
+func (u User) HasRole(name string) bool {
+	for _, n := range u.RoleNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns all of the permissions granted to the user by their roles
+func (u User) Permissions(ctx *ServerContext) (map[string]bool, Error) {
+	perms := map[string]bool{}
+	roles, err := LoadRoles(ctx, u.RoleNames())
+	if err != nil {
+		return perms, err
+	}
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			perms[p] = true
+		}
+	}
+	return perms, nil
+}
+
+// Returns true if one of the user's roles grants them the given permission
+func (u User) Can(ctx *ServerContext, permission string) (bool, Error) {
+	if u.ArangoKey() == "" {
+		return false, nil
+	}
+	perms, err := u.Permissions(ctx)
+	if err != nil {
+		return false, err
+	}
+	return perms[PERMISSION_ALL] || perms[permission], nil
+}
+
+// Returns true if the user may grant or revoke the named role: they need the role.grant permission,
+// along with every permission the role would give
+func (u User) CanGrantRole(ctx *ServerContext, name string) (bool, Error) {
+	if u.ArangoKey() == "" {
+		return false, nil
+	}
+	perms, err := u.Permissions(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !perms[PERMISSION_ALL] && !perms[PERMISSION_ROLE_GRANT] {
+		return false, nil
+	}
+
+	roles, err := LoadRoles(ctx, []string{name})
+	if err != nil {
+		return false, err
+	}
+	for _, r := range roles {
+		for _, p := range r.Permissions {
+			if !perms[PERMISSION_ALL] && !perms[p] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// Returns true if the user may save the given definition of a role, or delete it if it has no permissions.
+// They need the role.edit permission, along with every permission the role has now and would have after.
+// Since the built-in roles are held by everyone or granted outside of GrantRole, they can only be
+// changed by those who could grant them.
+func (u User) CanSaveRole(ctx *ServerContext, role Role) (bool, Error) {
+	if u.ArangoKey() == "" {
+		return false, nil
+	}
+	perms, err := u.Permissions(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !perms[PERMISSION_ALL] && !perms[PERMISSION_ROLE_EDIT] {
+		return false, nil
+	}
+
+	if defaults, ok := DEFAULT_ROLES[role.Key]; ok {
+		can, err := u.CanGrantRole(ctx, role.Key)
+		if err != nil || !can {
+			return false, err
+		}
+		role.Permissions = append(role.Permissions, defaults...)
+	}
+
+	existing, err := LoadRoles(ctx, []string{role.Key})
+	if err != nil {
+		return false, err
+	}
+	for _, r := range existing {
+		role.Permissions = append(role.Permissions, r.Permissions...)
+	}
+
+	for _, p := range role.Permissions {
+		if !perms[PERMISSION_ALL] && !perms[p] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Gives the user the named role, which must either be built in or saved
+func (u *User) GrantRole(ctx *ServerContext, name string) Error {
+	roles, err := LoadRoles(ctx, []string{name})
+	if err != nil {
+		return err
+	}
+	if len(roles) == 0 {
+		return NewNotFoundError(fmt.Sprintf("There is no role named %s", name))
+	}
+	if name == ROLE_USER || u.HasRole(name) {
+		return nil
+	}
+
+	updates := Updates{
+		"roles": append(append([]string{}, u.Roles...), name),
+	}
+	switch name {
+	case ROLE_CURATOR:
+		updates["curator"] = true
+	case ROLE_ADMIN:
+		updates["admin"] = true
+	}
+	return u.updateRoles(ctx, updates)
+}
+
+// Takes the named role away from the user
+func (u *User) RevokeRole(ctx *ServerContext, name string) Error {
+	if name == ROLE_USER {
+		return NewBusinessError("Every user has the user role")
+	}
+
+	roles := []string{}
+	for _, n := range u.Roles {
+		if n != name {
+			roles = append(roles, n)
+		}
+	}
+	updates := Updates{
+		"roles": roles,
+	}
+	switch name {
+	case ROLE_CURATOR:
+		updates["curator"] = false
+	case ROLE_ADMIN:
+		updates["admin"] = false
+	}
+	return u.updateRoles(ctx, updates)
+}
+
+func (u *User) updateRoles(ctx *ServerContext, updates Updates) Error {
+	col, err := ctx.Arango.CollectionFor(u)
+	if err != nil {
+		return err
+	}
+	if _, err := col.UpdateDocument(ctx.Context, u.ArangoKey(), updates); err != nil {
+		return NewServerError(err.Error())
+	}
+
+	u.Roles = updates["roles"].([]string)
+	if curator, ok := updates["curator"].(bool); ok {
+		u.Curator = curator
+	}
+	if admin, ok := updates["admin"].(bool); ok {
+		u.Admin = admin
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package gruff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRoleNames(t *testing.T) {
+	u := User{}
+	assert.Equal(t, []string{}, u.RoleNames())
+
+	u.Key = "somebody"
+	assert.Equal(t, []string{ROLE_USER}, u.RoleNames())
+
+	u.Curator = true
+	u.Roles = []string{"moderator", ROLE_CURATOR}
+	assert.Equal(t, []string{ROLE_USER, ROLE_CURATOR, "moderator"}, u.RoleNames())
+	assert.True(t, u.HasRole("moderator"))
+	assert.False(t, u.HasRole(ROLE_ADMIN))
+}
+
+func TestRoleValidateForCreate(t *testing.T) {
+	assert.NoError(t, Role{Key: "moderator", Permissions: []string{"claim.edit.any", "user.ban"}}.ValidateForCreate())
+	assert.NoError(t, Role{Key: "superuser", Permissions: []string{PERMISSION_ALL}}.ValidateForCreate())
+	assert.Error(t, Role{Key: "Moderator"}.ValidateForCreate())
+	assert.Error(t, Role{Key: "m"}.ValidateForCreate())
+	assert.Error(t, Role{Key: "moderator", Permissions: []string{"claim..edit"}}.ValidateForCreate())
+	assert.Error(t, Role{Key: "moderator", Permissions: []string{""}}.ValidateForCreate())
+}
+
+func TestUserCan(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	anon := User{}
+	can, err := anon.Can(CTX, PERMISSION_CLAIM_EDIT_ANY)
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	u := User{Name: "Role Player", Username: "RolePlayer", Email: "roleplayer@gruff.org", Password: "123456"}
+	err = u.Create(CTX)
+	assert.NoError(t, err)
+
+	can, err = u.Can(CTX, PERMISSION_CLAIM_EDIT_ANY)
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	// Built-in roles work without being saved
+	u.Curator = true
+	can, err = u.Can(CTX, PERMISSION_CLAIM_EDIT_ANY)
+	assert.NoError(t, err)
+	assert.True(t, can)
+	can, err = u.Can(CTX, PERMISSION_USER_BAN)
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	u.Curator = false
+	u.Admin = true
+	can, err = u.Can(CTX, PERMISSION_USER_BAN)
+	assert.NoError(t, err)
+	assert.True(t, can)
+	u.Admin = false
+
+	// New roles can be defined...
+	moderator := Role{Key: "moderator", Permissions: []string{PERMISSION_USER_BAN, PERMISSION_ARGUMENT_MOVE}}
+	err = moderator.Create(CTX)
+	assert.NoError(t, err)
+
+	u.Roles = []string{"moderator", "nonexistent"}
+	can, err = u.Can(CTX, PERMISSION_USER_BAN)
+	assert.NoError(t, err)
+	assert.True(t, can)
+	can, err = u.Can(CTX, PERMISSION_CLAIM_EDIT_ANY)
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	// ...and built-in ones redefined
+	curator := Role{Key: ROLE_CURATOR, Permissions: []string{PERMISSION_CONTEXT_EDIT}}
+	err = curator.Create(CTX)
+	assert.NoError(t, err)
+
+	u.Roles = []string{}
+	u.Curator = true
+	can, err = u.Can(CTX, PERMISSION_CLAIM_EDIT_ANY)
+	assert.NoError(t, err)
+	assert.False(t, can)
+	can, err = u.Can(CTX, PERMISSION_CONTEXT_EDIT)
+	assert.NoError(t, err)
+	assert.True(t, can)
+
+	roles, err := ListRoles(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(roles))
+	assert.Equal(t, ROLE_ADMIN, roles[0].Key)
+	assert.Equal(t, ROLE_CURATOR, roles[1].Key)
+	assert.Equal(t, []string{PERMISSION_CONTEXT_EDIT}, roles[1].Permissions)
+	assert.Equal(t, "moderator", roles[2].Key)
+	assert.Equal(t, ROLE_USER, roles[3].Key)
+
+	// Deleting a built-in role's definition brings back its defaults
+	err = curator.Delete(CTX)
+	assert.NoError(t, err)
+	can, err = u.Can(CTX, PERMISSION_CLAIM_EDIT_ANY)
+	assert.NoError(t, err)
+	assert.True(t, can)
+}
+
+func TestUserGrantRole(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Name: "Promoted", Username: "Promoted", Email: "promoted@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+
+	err = u.GrantRole(CTX, "nonexistent")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+
+	err = u.GrantRole(CTX, ROLE_CURATOR)
+	assert.NoError(t, err)
+	assert.True(t, u.Curator)
+
+	saved := User{}
+	saved.Key = u.Key
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.True(t, saved.Curator)
+	assert.Equal(t, []string{ROLE_CURATOR}, saved.Roles)
+
+	// Granting a role twice changes nothing
+	err = saved.GrantRole(CTX, ROLE_CURATOR)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ROLE_CURATOR}, saved.Roles)
+
+	err = saved.RevokeRole(CTX, ROLE_USER)
+	assert.Error(t, err)
+
+	err = saved.RevokeRole(CTX, ROLE_CURATOR)
+	assert.NoError(t, err)
+	saved = User{}
+	saved.Key = u.Key
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.False(t, saved.Curator)
+	assert.Empty(t, saved.Roles)
+	assert.Equal(t, []string{ROLE_USER}, saved.RoleNames())
+
+	// Users can't grant themselves roles
+	CTX.UserContext = saved
+	err = saved.Update(CTX, Updates{"name": "", "username": "", "email": "", "admin": true})
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_PERMISSION_ERROR, err.Code())
+	err = saved.Update(CTX, Updates{"name": "", "username": "", "email": "", "roles": []string{ROLE_ADMIN}})
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_PERMISSION_ERROR, err.Code())
+}
+
+func TestUserCanGrantRole(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	granter := Role{Key: "granter", Permissions: []string{PERMISSION_ROLE_GRANT, PERMISSION_ARGUMENT_MOVE}}
+	err := granter.Create(CTX)
+	assert.NoError(t, err)
+	mover := Role{Key: "mover", Permissions: []string{PERMISSION_ARGUMENT_MOVE}}
+	err = mover.Create(CTX)
+	assert.NoError(t, err)
+
+	u := User{}
+	u.PrepareForCreate(CTX)
+	can, err := u.CanGrantRole(CTX, "mover")
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	u.Roles = []string{"granter"}
+	can, err = u.CanGrantRole(CTX, "mover")
+	assert.NoError(t, err)
+	assert.True(t, can)
+	can, err = u.CanGrantRole(CTX, "granter")
+	assert.NoError(t, err)
+	assert.True(t, can)
+
+	// Nobody can hand out permissions they don't have
+	can, err = u.CanGrantRole(CTX, ROLE_CURATOR)
+	assert.NoError(t, err)
+	assert.False(t, can)
+	can, err = u.CanGrantRole(CTX, ROLE_ADMIN)
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	// Not even by giving a role everything
+	everything := Role{Key: "everything", Permissions: []string{PERMISSION_ALL}}
+	err = everything.Create(CTX)
+	assert.NoError(t, err)
+	can, err = u.CanGrantRole(CTX, "everything")
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	u.Admin = true
+	can, err = u.CanGrantRole(CTX, ROLE_ADMIN)
+	assert.NoError(t, err)
+	assert.True(t, can)
+	can, err = u.CanGrantRole(CTX, "everything")
+	assert.NoError(t, err)
+	assert.True(t, can)
+}
+
+func TestUserCanSaveRole(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	editor := Role{Key: "editor", Permissions: []string{PERMISSION_ROLE_EDIT, PERMISSION_ARGUMENT_MOVE}}
+	err := editor.Create(CTX)
+	assert.NoError(t, err)
+	banner := Role{Key: "banner", Permissions: []string{PERMISSION_USER_BAN}}
+	err = banner.Create(CTX)
+	assert.NoError(t, err)
+
+	u := User{}
+	u.PrepareForCreate(CTX)
+	can, err := u.CanSaveRole(CTX, Role{Key: "mover", Permissions: []string{PERMISSION_ARGUMENT_MOVE}})
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	u.Roles = []string{"editor"}
+	can, err = u.CanSaveRole(CTX, Role{Key: "mover", Permissions: []string{PERMISSION_ARGUMENT_MOVE}})
+	assert.NoError(t, err)
+	assert.True(t, can)
+
+	// Nobody can define a role with permissions they don't have
+	can, err = u.CanSaveRole(CTX, Role{Key: "mover", Permissions: []string{PERMISSION_ALL}})
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	// Or take over a role whose permissions they don't have
+	can, err = u.CanSaveRole(CTX, Role{Key: "banner", Permissions: []string{PERMISSION_ARGUMENT_MOVE}})
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	// Or change the roles built into everyone's account
+	can, err = u.CanSaveRole(CTX, Role{Key: ROLE_USER, Permissions: []string{PERMISSION_ARGUMENT_MOVE}})
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	u.Admin = true
+	can, err = u.CanSaveRole(CTX, Role{Key: ROLE_USER, Permissions: []string{PERMISSION_ARGUMENT_MOVE}})
+	assert.NoError(t, err)
+	assert.True(t, can)
+	can, err = u.CanSaveRole(CTX, Role{Key: "banner", Permissions: []string{PERMISSION_ALL}})
+	assert.NoError(t, err)
+	assert.True(t, can)
+}
+
+func TestArgumentUserCanMove(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	owner := User{}
+	owner.PrepareForCreate(CTX)
+	arg := Argument{}
+	arg.CreatedByID = owner.ArangoID()
+	move := Updates{"targetClaimId": "somewhere"}
+
+	CTX.UserContext = owner
+	can, err := arg.UserCanUpdate(CTX, move)
+	assert.NoError(t, err)
+	assert.True(t, can)
+
+	other := User{}
+	other.PrepareForCreate(CTX)
+	CTX.UserContext = other
+	can, err = arg.UserCanUpdate(CTX, move)
+	assert.NoError(t, err)
+	assert.False(t, can)
+
+	mover := Role{Key: "mover", Permissions: []string{PERMISSION_ARGUMENT_MOVE}}
+	err = mover.Create(CTX)
+	assert.NoError(t, err)
+	other.Roles = []string{"mover"}
+	CTX.UserContext = other
+	can, err = arg.UserCanUpdate(CTX, move)
+	assert.NoError(t, err)
+	assert.True(t, can)
+
+	// Moving isn't editing
+	can, err = arg.UserCanUpdate(CTX, Updates{"title": "Edited by someone else"})
+	assert.NoError(t, err)
+	assert.False(t, can)
+}
@@ -0,0 +1,264 @@
+package gruff
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+ * A ScoreAggregator combines the opinions of individual users (their UserScores)
+ * into the flat score of a Claim or Argument.
+ *
+ * The aggregator is chosen per deployment by setting SCORE_AGGREGATOR,
+ * which defaults to a plain mean of all the votes.
+ */
+
+// A Vote is a single UserScore, along with what is known about the user who cast it
+type Vote struct {
+	Score    float32   `json:"score"`
+	Cast     time.Time `json:"cast"`
+	Joined   time.Time `json:"joined"`
+	Verified bool      `json:"verified"`
+}
+
+type ScoreAggregator interface {
+	// Returns false if the votes do not add up to a score, in which case the default score should be used
+	Aggregate(votes []Vote) (float32, bool)
+}
+
+// A QueryAggregator can also be computed by the database, so that the votes don't need to be loaded
+type QueryAggregator interface {
+	ScoreAggregator
+	// Returns an AQL aggregate expression over the UserScore edges, which are referred to as "obj"
+	AggregateExpression() string
+}
+
+var SCORE_AGGREGATOR ScoreAggregator = MeanAggregator{}
+
+const SCORE_AGGREGATOR_MEAN string = "mean"
+const SCORE_AGGREGATOR_MEDIAN string = "median"
+const SCORE_AGGREGATOR_TRIMMED string = "trimmed"
+const SCORE_AGGREGATOR_ACCOUNT_AGE string = "age"
+const SCORE_AGGREGATOR_VERIFIED string = "verified"
+
+// Returns the aggregator configured under the given name, with its default settings
+func ScoreAggregatorNamed(name string) (ScoreAggregator, Error) {
+	switch name {
+	case SCORE_AGGREGATOR_MEAN, "":
+		return MeanAggregator{}, nil
+	case SCORE_AGGREGATOR_MEDIAN:
+		return MedianAggregator{}, nil
+	case SCORE_AGGREGATOR_TRIMMED:
+		return TrimmedMeanAggregator{Trim: 0.10}, nil
+	case SCORE_AGGREGATOR_ACCOUNT_AGE:
+		return AccountAgeAggregator{Ramp: 30 * 24 * time.Hour}, nil
+	case SCORE_AGGREGATOR_VERIFIED:
+		return VerifiedAggregator{UnverifiedWeight: 0.10}, nil
+	}
+	return nil, NewServerError(fmt.Sprintf("Unknown score aggregator: %s", name))
+}
+
+// The plain average of all votes
+type MeanAggregator struct{}
+
+func (agg MeanAggregator) Aggregate(votes []Vote) (float32, bool) {
+	weights := make([]float32, len(votes))
+	for i := range votes {
+		weights[i] = 1.0
+	}
+	return weightedMean(votes, weights)
+}
+
+func (agg MeanAggregator) AggregateExpression() string {
+	return "AVG(obj.score)"
+}
+
+// The middle vote, or the average of the two middle votes
+type MedianAggregator struct{}
+
+func (agg MedianAggregator) Aggregate(votes []Vote) (float32, bool) {
+	if len(votes) == 0 {
+		return 0.0, false
+	}
+
+	scores := sortedScores(votes)
+	mid := len(scores) / 2
+	if len(scores)%2 == 0 {
+		return (scores[mid-1] + scores[mid]) / 2.0, true
+	}
+	return scores[mid], true
+}
+
+// The average of the votes that remain after discarding
+// the given fraction of the highest and of the lowest votes.
+// Trimming half of the votes or more from each end leaves only the middle, so it is the median.
+type TrimmedMeanAggregator struct {
+	Trim float32
+}
+
+func (agg TrimmedMeanAggregator) Aggregate(votes []Vote) (float32, bool) {
+	if len(votes) == 0 {
+		return 0.0, false
+	}
+	if agg.Trim >= 0.5 {
+		return MedianAggregator{}.Aggregate(votes)
+	}
+	if agg.Trim < 0.0 {
+		agg.Trim = 0.0
+	}
+
+	scores := sortedScores(votes)
+	trim := int(float32(len(scores)) * agg.Trim)
+	scores = scores[trim : len(scores)-trim]
+
+	var total float32
+	for _, s := range scores {
+		total += s
+	}
+	return total / float32(len(scores)), true
+}
+
+// A weighted average, in which each vote counts according to how long the user's account
+// existed before the vote was cast, growing to full weight over the Ramp period,
+// so that a crowd of freshly created accounts can't swing the score
+type AccountAgeAggregator struct {
+	Ramp time.Duration
+}
+
+func (agg AccountAgeAggregator) Aggregate(votes []Vote) (float32, bool) {
+	weights := make([]float32, len(votes))
+	for i, vote := range votes {
+		age := vote.Cast.Sub(vote.Joined)
+		if age >= agg.Ramp {
+			weights[i] = 1.0
+		} else if age > 0 {
+			weights[i] = float32(age) / float32(agg.Ramp)
+		}
+	}
+	return weightedMean(votes, weights)
+}
+
+// A weighted average, in which votes from users without a verified email address
+// count for only a fraction of the votes from verified users
+type VerifiedAggregator struct {
+	UnverifiedWeight float32
+}
+
+func (agg VerifiedAggregator) Aggregate(votes []Vote) (float32, bool) {
+	weights := make([]float32, len(votes))
+	for i, vote := range votes {
+		if vote.Verified {
+			weights[i] = 1.0
+		} else {
+			weights[i] = agg.UnverifiedWeight
+		}
+	}
+	return weightedMean(votes, weights)
+}
+
+func weightedMean(votes []Vote, weights []float32) (float32, bool) {
+	var total, totalWeight float32
+	for i, vote := range votes {
+		total += vote.Score * weights[i]
+		totalWeight += weights[i]
+	}
+	if totalWeight <= 0.0 {
+		return 0.0, false
+	}
+	return total / totalWeight, true
+}
+
+func sortedScores(votes []Vote) []float32 {
+	scores := make([]float32, len(votes))
+	for i, vote := range votes {
+		scores[i] = vote.Score
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i] < scores[j] })
+	return scores
+}
+
+// Aggregates the active votes on the item with the given ID in the given collection,
+// in the database if the aggregator allows it. Returns false if the votes do not add up to a score.
+// The date filter is applied to the UserScore edges, which must be referred to as "obj".
+func AggregateVotes(ctx *ServerContext, agg ScoreAggregator, collectionName, id, dateFilter string, bindVars BindVars) (float32, bool, Error) {
+	qagg, ok := agg.(QueryAggregator)
+	if !ok {
+		votes, err := Votes(ctx, collectionName, id, dateFilter, bindVars)
+		if err != nil {
+			return 0.0, false, err
+		}
+		score, ok := agg.Aggregate(votes)
+		return score, ok, nil
+	}
+
+	results := struct {
+		Num   int      `json:"num"`
+		Score *float64 `json:"score"`
+	}{}
+
+	bindVars["target"] = id
+	query := fmt.Sprintf(`FOR obj IN %s
+                                 FOR t IN %s
+                                   FILTER obj._to == t._id
+                                      AND t.id == @target
+                                   %s
+                                   COLLECT
+                                   AGGREGATE
+                                     num = COUNT(obj),
+                                     score = %s
+                                   RETURN { num, score }`,
+		UserScore{}.CollectionName(),
+		collectionName,
+		dateFilter,
+		qagg.AggregateExpression())
+
+	db := ctx.Arango.DB
+	cursor, err := db.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return 0.0, false, NewServerError(err.Error())
+	}
+	if _, err := cursor.ReadDocument(ctx.Context, &results); err != nil {
+		return 0.0, false, NewServerError(err.Error())
+	}
+	if results.Num == 0 || results.Score == nil {
+		return 0.0, false, nil
+	}
+	return float32(*results.Score), true, nil
+}
+
+// Returns the active votes on the item with the given ID in the given collection.
+// The date filter is applied to the UserScore edges, which must be referred to as "obj".
+func Votes(ctx *ServerContext, collectionName, id, dateFilter string, bindVars BindVars) ([]Vote, Error) {
+	votes := []Vote{}
+
+	bindVars["target"] = id
+	query := fmt.Sprintf(`FOR obj IN %s
+                                 FOR t IN %s
+                                   FILTER obj._to == t._id
+                                      AND t.id == @target
+                                   %s
+                                   FOR u IN %s
+                                     FILTER u._id == obj._from
+                                     RETURN { score: obj.score, cast: obj.start, joined: u.start, verified: u.verified != null }`,
+		UserScore{}.CollectionName(),
+		collectionName,
+		dateFilter,
+		User{}.CollectionName())
+
+	db := ctx.Arango.DB
+	cursor, err := db.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return votes, NewServerError(err.Error())
+	}
+	for cursor.HasMore() {
+		vote := Vote{}
+		if _, err := cursor.ReadDocument(ctx.Context, &vote); err != nil {
+			return votes, NewServerError(err.Error())
+		}
+		votes = append(votes, vote)
+	}
+	return votes, nil
+}
@@ -0,0 +1,172 @@
+package gruff
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func votesFor(scores ...float32) []Vote {
+	votes := make([]Vote, len(scores))
+	for i, s := range scores {
+		votes[i] = Vote{Score: s}
+	}
+	return votes
+}
+
+func TestScoreAggregatorNamed(t *testing.T) {
+	agg, err := ScoreAggregatorNamed("")
+	assert.NoError(t, err)
+	assert.Equal(t, MeanAggregator{}, agg)
+
+	agg, err = ScoreAggregatorNamed(SCORE_AGGREGATOR_MEDIAN)
+	assert.NoError(t, err)
+	assert.Equal(t, MedianAggregator{}, agg)
+
+	agg, err = ScoreAggregatorNamed(SCORE_AGGREGATOR_TRIMMED)
+	assert.NoError(t, err)
+	assert.IsType(t, TrimmedMeanAggregator{}, agg)
+
+	agg, err = ScoreAggregatorNamed(SCORE_AGGREGATOR_ACCOUNT_AGE)
+	assert.NoError(t, err)
+	assert.IsType(t, AccountAgeAggregator{}, agg)
+
+	agg, err = ScoreAggregatorNamed(SCORE_AGGREGATOR_VERIFIED)
+	assert.NoError(t, err)
+	assert.IsType(t, VerifiedAggregator{}, agg)
+
+	_, err = ScoreAggregatorNamed("popularity contest")
+	assert.Error(t, err)
+}
+
+func TestMeanAggregator(t *testing.T) {
+	_, ok := MeanAggregator{}.Aggregate([]Vote{})
+	assert.False(t, ok)
+
+	score, ok := MeanAggregator{}.Aggregate(votesFor(0.2, 0.4, 0.9))
+	assert.True(t, ok)
+	assert.InDelta(t, 0.5, score, 0.0001)
+
+	score, ok = MeanAggregator{}.Aggregate(votesFor(0.0))
+	assert.True(t, ok)
+	assert.Equal(t, float32(0.0), score)
+}
+
+func TestMedianAggregator(t *testing.T) {
+	_, ok := MedianAggregator{}.Aggregate([]Vote{})
+	assert.False(t, ok)
+
+	score, ok := MedianAggregator{}.Aggregate(votesFor(0.9, 0.1, 0.3))
+	assert.True(t, ok)
+	assert.Equal(t, float32(0.3), score)
+
+	score, ok = MedianAggregator{}.Aggregate(votesFor(0.9, 0.1, 0.3, 0.5))
+	assert.True(t, ok)
+	assert.InDelta(t, 0.4, score, 0.0001)
+}
+
+func TestTrimmedMeanAggregator(t *testing.T) {
+	agg := TrimmedMeanAggregator{Trim: 0.2}
+
+	_, ok := agg.Aggregate([]Vote{})
+	assert.False(t, ok)
+
+	// Too few votes to trim
+	score, ok := agg.Aggregate(votesFor(0.0, 1.0))
+	assert.True(t, ok)
+	assert.InDelta(t, 0.5, score, 0.0001)
+
+	score, ok = agg.Aggregate(votesFor(0.0, 0.0, 0.4, 0.5, 0.6, 0.4, 0.5, 0.6, 1.0, 1.0))
+	assert.True(t, ok)
+	assert.InDelta(t, 0.5, score, 0.0001)
+
+	// Trimming everything away leaves the median
+	for _, trim := range []float32{0.5, 0.75, 1.0} {
+		score, ok = TrimmedMeanAggregator{Trim: trim}.Aggregate(votesFor(0.9, 0.1, 0.3, 0.5))
+		assert.True(t, ok)
+		assert.InDelta(t, 0.4, score, 0.0001)
+	}
+
+	// Trimming less than nothing trims nothing
+	score, ok = TrimmedMeanAggregator{Trim: -0.2}.Aggregate(votesFor(0.0, 0.2, 1.0))
+	assert.True(t, ok)
+	assert.InDelta(t, 0.4, score, 0.0001)
+
+	score, ok = TrimmedMeanAggregator{Trim: 0.49}.Aggregate(votesFor(0.0, 0.2, 1.0))
+	assert.True(t, ok)
+	assert.InDelta(t, 0.2, score, 0.0001)
+}
+
+func TestAccountAgeAggregator(t *testing.T) {
+	agg := AccountAgeAggregator{Ramp: 10 * 24 * time.Hour}
+	now := time.Now()
+
+	votes := []Vote{
+		{Score: 1.0, Cast: now, Joined: now.Add(-20 * 24 * time.Hour)},
+		{Score: 0.0, Cast: now, Joined: now.Add(-5 * 24 * time.Hour)},
+		{Score: 0.0, Cast: now, Joined: now},
+	}
+	score, ok := agg.Aggregate(votes)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.6667, score, 0.0001)
+
+	// Brand new accounts don't count at all
+	_, ok = agg.Aggregate(votes[2:])
+	assert.False(t, ok)
+}
+
+func TestVerifiedAggregator(t *testing.T) {
+	agg := VerifiedAggregator{UnverifiedWeight: 0.25}
+
+	votes := []Vote{
+		{Score: 1.0, Verified: true},
+		{Score: 0.0, Verified: false},
+		{Score: 0.0, Verified: false},
+	}
+	score, ok := agg.Aggregate(votes)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.6667, score, 0.0001)
+
+	_, ok = agg.Aggregate([]Vote{})
+	assert.False(t, ok)
+}
+
+func TestAggregateVotes(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "The database can take an average"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	bindVars := BindVars{}
+	_, ok, err := AggregateVotes(CTX, MeanAggregator{}, claim.CollectionName(), claim.ID, claim.DateFilter(bindVars), bindVars)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	for i, score := range []float32{0.1, 0.2, 0.9} {
+		u := User{Username: fmt.Sprintf("Averager%d", i)}
+		err = u.Create(CTX)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+
+		err = u.Score(CTX, &claim, score)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+	}
+
+	bindVars = BindVars{}
+	score, ok, err := AggregateVotes(CTX, MeanAggregator{}, claim.CollectionName(), claim.ID, claim.DateFilter(bindVars), bindVars)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.4, score, 0.0001)
+
+	bindVars = BindVars{}
+	score, ok, err = AggregateVotes(CTX, MedianAggregator{}, claim.CollectionName(), claim.ID, claim.DateFilter(bindVars), bindVars)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.2, score, 0.0001)
+}
@@ -0,0 +1,86 @@
+package gruff
+
+import (
+	"math"
+)
+
+/*
+ * A ScoreDistribution summarizes how the votes on a Claim or Argument are spread out,
+ * so that items on which people sharply disagree can be told apart from items
+ * on which everyone is lukewarm, even though both may have an average score of 0.5.
+ *
+ * It is cached on the item whenever its score is updated.
+ */
+
+const SCORE_HISTOGRAM_BUCKETS int = 10
+
+// The number of votes at which an item is considered half as controversial as its spread alone would suggest,
+// so that a couple of opposing votes don't make an item look like a raging debate
+const CONTROVERSY_VOTE_WEIGHT float64 = 5.0
+
+type ScoreDistribution struct {
+	// The number of votes in each tenth of the range from 0 to 1
+	Histogram []int `json:"histogram"`
+	// The population standard deviation of the votes
+	StdDev float32 `json:"stddev"`
+	// Sarle's bimodality coefficient, from 0 to 1. A uniform spread scores 5/9,
+	// and anything above that suggests the votes are gathered around two separate peaks.
+	Bimodality float32 `json:"bimodality"`
+	// From 0 (everyone agrees) to 1 (a large crowd split evenly between the two extremes)
+	Controversy float32 `json:"controversy"`
+}
+
+func NewScoreDistribution(votes []Vote) ScoreDistribution {
+	dist := ScoreDistribution{
+		Histogram: make([]int, SCORE_HISTOGRAM_BUCKETS),
+	}
+	if len(votes) == 0 {
+		return dist
+	}
+
+	n := float64(len(votes))
+	var total float64
+	for _, vote := range votes {
+		total += float64(vote.Score)
+
+		bucket := int(vote.Score * float32(SCORE_HISTOGRAM_BUCKETS))
+		if bucket >= SCORE_HISTOGRAM_BUCKETS {
+			bucket = SCORE_HISTOGRAM_BUCKETS - 1
+		} else if bucket < 0 {
+			bucket = 0
+		}
+		dist.Histogram[bucket]++
+	}
+	mean := total / n
+
+	var variance, m3, m4 float64
+	for _, vote := range votes {
+		diff := float64(vote.Score) - mean
+		variance += diff * diff
+		m3 += diff * diff * diff
+		m4 += diff * diff * diff * diff
+	}
+	variance = variance / n
+	m3 = m3 / n
+	m4 = m4 / n
+
+	dist.StdDev = float32(math.Sqrt(variance))
+	if variance == 0 {
+		return dist
+	}
+
+	// The coefficient is computed from the population moments rather than the sample estimates,
+	// which keeps it between 0 and 1 however few votes there are
+	skewness := m3 / math.Pow(variance, 1.5)
+	kurtosis := m4 / (variance * variance)
+	bimodality := (skewness*skewness + 1) / kurtosis
+	dist.Bimodality = float32(bimodality)
+
+	// The coefficient doesn't care how far apart the peaks are, so it is weighted by the spread of the votes.
+	// Scores are bounded by 0 and 1, so the standard deviation can be at most 0.5,
+	// which only happens when the votes are split evenly between the two extremes.
+	spread := 2.0 * math.Sqrt(variance)
+	dist.Controversy = float32(bimodality * spread * n / (n + CONTROVERSY_VOTE_WEIGHT))
+
+	return dist
+}
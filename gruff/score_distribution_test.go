@@ -0,0 +1,90 @@
+package gruff
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScoreDistribution(t *testing.T) {
+	dist := NewScoreDistribution([]Vote{})
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, dist.Histogram)
+	assert.Equal(t, float32(0.0), dist.StdDev)
+	assert.Equal(t, float32(0.0), dist.Bimodality)
+	assert.Equal(t, float32(0.0), dist.Controversy)
+
+	// Everyone agrees
+	dist = NewScoreDistribution(votesFor(0.7, 0.7, 0.7, 0.7))
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0, 4, 0, 0}, dist.Histogram)
+	assert.InDelta(t, 0.0, dist.StdDev, 0.0001)
+	assert.InDelta(t, 0.0, dist.Bimodality, 0.0001)
+	assert.InDelta(t, 0.0, dist.Controversy, 0.0001)
+
+	// Everyone is lukewarm
+	lukewarm := NewScoreDistribution(votesFor(0.4, 0.5, 0.5, 0.6, 0.4, 0.5, 0.5, 0.6, 0.4, 0.6))
+	assert.Equal(t, []int{0, 0, 0, 0, 3, 4, 3, 0, 0, 0}, lukewarm.Histogram)
+
+	// A crowd split evenly between the extremes
+	split := NewScoreDistribution(votesFor(0.0, 1.0, 0.0, 1.0, 0.0, 1.0, 0.0, 1.0, 0.0, 1.0))
+	assert.Equal(t, []int{5, 0, 0, 0, 0, 0, 0, 0, 0, 5}, split.Histogram)
+	assert.InDelta(t, 0.5, split.StdDev, 0.0001)
+	assert.InDelta(t, 1.0, split.Bimodality, 0.0001)
+	assert.InDelta(t, 0.6667, split.Controversy, 0.0001)
+	assert.True(t, split.Controversy > lukewarm.Controversy)
+
+	// Votes spread evenly across the range are wide apart, but there are no opposing camps
+	spread := NewScoreDistribution(votesFor(0.0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0))
+	assert.InDelta(t, 0.5556, spread.Bimodality, 0.01)
+	assert.True(t, spread.Controversy < split.Controversy)
+
+	// Nor are there when the votes bunch up around a single peak, however far the stragglers are
+	peak := NewScoreDistribution(votesFor(0.0, 0.3, 0.4, 0.5, 0.5, 0.5, 0.5, 0.6, 0.7, 1.0))
+	assert.True(t, peak.Bimodality < spread.Bimodality)
+	assert.True(t, peak.Controversy < spread.Controversy)
+
+	// A couple of opposing votes is not much of a controversy yet
+	pair := NewScoreDistribution(votesFor(0.0, 1.0))
+	assert.InDelta(t, 0.5, pair.StdDev, 0.0001)
+	assert.True(t, pair.Controversy < split.Controversy)
+}
+
+func TestClaimScoreDistribution(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "Reasonable people disagree"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, 10, len(claim.Distribution.Histogram))
+
+	for i, score := range []float32{0.0, 1.0, 0.05, 0.95} {
+		u := User{Username: fmt.Sprintf("Disagreer%d", i)}
+		err = u.Create(CTX)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+
+		err = u.Score(CTX, &claim, score)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+	}
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.LoadFull(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 0, 0, 0, 0, 0, 0, 0, 0, 2}, saved.Distribution.Histogram)
+	assert.True(t, saved.Distribution.Controversy > 0.0)
+
+	calm := Claim{Title: "Nobody minds this one"}
+	err = calm.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	claims := []Claim{}
+	err = FindArangoObjects(CTX, calm.QueryForControversialClaims(ArangoQueryParameters{}), BindVars{}, &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(claims))
+	assert.Equal(t, claim.ID, claims[0].ID)
+}
@@ -0,0 +1,279 @@
+package gruff
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+/*
+ * Since a UserScore is soft-deleted and replaced whenever a user changes their mind
+ * (and whenever the item is versioned), the scores collection holds the full history
+ * of everyone's opinions. A ScoreHistory replays that history one time bucket at a time,
+ * aggregating the votes that were active at the end of each bucket.
+ */
+
+const SCORE_BUCKET_HOUR string = "hour"
+const SCORE_BUCKET_DAY string = "day"
+const SCORE_BUCKET_WEEK string = "week"
+const SCORE_BUCKET_MONTH string = "month"
+
+// Keeps a single request from replaying years of history by the hour
+const SCORE_HISTORY_MAX_BUCKETS int = 1000
+
+// The number of buckets charted when no window is given
+const SCORE_HISTORY_DEFAULT_BUCKETS int = 100
+
+// A ScorePoint is the aggregated score of an item at the end of a time bucket
+type ScorePoint struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Score float32   `json:"score"`
+	// The number of votes that were active at the end of the bucket
+	Votes int `json:"votes"`
+	// The number of new or changed votes cast during the bucket
+	Cast int `json:"cast"`
+}
+
+// A PastVote is a Vote that may since have been changed or withdrawn
+type PastVote struct {
+	Vote
+	User  string     `json:"user"`
+	Ended *time.Time `json:"end"`
+}
+
+func (v PastVote) ActiveAt(t time.Time) bool {
+	return !v.Cast.After(t) && (v.Ended == nil || v.Ended.After(t))
+}
+
+// A ScoreHistorian can chart how its score changed over time, between from and to if they are given
+type ScoreHistorian interface {
+	ScoreHistory(ctx *ServerContext, bucket string, from, to *time.Time) ([]ScorePoint, Error)
+}
+
+func IsScoreHistorian(t reflect.Type) bool {
+	modelType := reflect.TypeOf((*ScoreHistorian)(nil)).Elem()
+	return t.Implements(modelType)
+}
+
+// Returns the start of the bucket containing t
+func BucketStart(bucket string, t time.Time) (time.Time, Error) {
+	t = t.UTC()
+	switch bucket {
+	case SCORE_BUCKET_HOUR:
+		return t.Truncate(time.Hour), nil
+	case SCORE_BUCKET_DAY:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	case SCORE_BUCKET_WEEK:
+		// Weeks start on Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC), nil
+	case SCORE_BUCKET_MONTH:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return t, NewBusinessError(fmt.Sprintf("Bucket: must be one of %s, %s, %s or %s;",
+		SCORE_BUCKET_HOUR, SCORE_BUCKET_DAY, SCORE_BUCKET_WEEK, SCORE_BUCKET_MONTH))
+}
+
+// Returns the start of the bucket after the one that starts at start
+func NextBucket(bucket string, start time.Time) time.Time {
+	switch bucket {
+	case SCORE_BUCKET_HOUR:
+		return start.Add(time.Hour)
+	case SCORE_BUCKET_WEEK:
+		return start.AddDate(0, 0, 7)
+	case SCORE_BUCKET_MONTH:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Returns the start of the bucket before the one that starts at start
+func PreviousBucket(bucket string, start time.Time) time.Time {
+	switch bucket {
+	case SCORE_BUCKET_HOUR:
+		return start.Add(-time.Hour)
+	case SCORE_BUCKET_WEEK:
+		return start.AddDate(0, 0, -7)
+	case SCORE_BUCKET_MONTH:
+		return start.AddDate(0, -1, 0)
+	}
+	return start.AddDate(0, 0, -1)
+}
+
+// Works out which part of an item's history to chart. The window ends at to, or now if it isn't given,
+// and starts at from, or SCORE_HISTORY_DEFAULT_BUCKETS buckets earlier if it isn't given.
+// It never reaches back before the item was created, nor forward past now.
+func ScoreHistoryWindow(bucket string, created, now time.Time, from, to *time.Time) (time.Time, time.Time, Error) {
+	end := now
+	if to != nil && to.Before(now) {
+		end = *to
+	}
+
+	var start time.Time
+	if from != nil {
+		start = *from
+	} else {
+		var err Error
+		start, err = BucketStart(bucket, end)
+		if err != nil {
+			return start, end, err
+		}
+		for i := 1; i < SCORE_HISTORY_DEFAULT_BUCKETS && start.After(created); i++ {
+			start = PreviousBucket(bucket, start)
+		}
+	}
+	if start.Before(created) {
+		start = created
+	}
+
+	if start.After(end) {
+		return start, end, NewBusinessError("From: must be before to, and before now;")
+	}
+	return start, end, nil
+}
+
+// Aggregates the votes into one ScorePoint per bucket, from the bucket containing from
+// until the bucket containing to. The last bucket ends at to, rather than in the future.
+func BuildScoreHistory(votes []PastVote, bucket string, from, to time.Time, agg ScoreAggregator, defaultScore float32) ([]ScorePoint, Error) {
+	history := []ScorePoint{}
+
+	start, err := BucketStart(bucket, from)
+	if err != nil {
+		return history, err
+	}
+
+	// The votes are replayed in the order they were cast, carrying the active ones from one bucket to the next
+	votes = append([]PastVote{}, votes...)
+	sort.SliceStable(votes, func(i, j int) bool {
+		return votes[i].Cast.Before(votes[j].Cast)
+	})
+	continued := continuedVotes(votes)
+
+	active := []int{}
+	next, counted := 0, 0
+	for !start.After(to) {
+		if len(history) >= SCORE_HISTORY_MAX_BUCKETS {
+			return history, NewBusinessError(fmt.Sprintf("Bucket: this window has more than %d %ss;", SCORE_HISTORY_MAX_BUCKETS, bucket))
+		}
+
+		end := NextBucket(bucket, start)
+		if end.After(to) {
+			end = to
+		}
+
+		point := ScorePoint{Start: start, End: end}
+		for ; counted < len(votes) && votes[counted].Cast.Before(end); counted++ {
+			if !votes[counted].Cast.Before(start) && !continued[counted] {
+				point.Cast++
+			}
+		}
+		for ; next < len(votes) && !votes[next].Cast.After(end); next++ {
+			active = append(active, next)
+		}
+
+		current := []Vote{}
+		remaining := active[:0]
+		for _, i := range active {
+			if votes[i].ActiveAt(end) {
+				remaining = append(remaining, i)
+				current = append(current, votes[i].Vote)
+			}
+		}
+		active = remaining
+
+		score, ok := agg.Aggregate(current)
+		if !ok {
+			score = defaultScore
+		}
+		point.Score = score
+		point.Votes = len(current)
+
+		history = append(history, point)
+		start = NextBucket(bucket, start)
+	}
+
+	return history, nil
+}
+
+// Flags the votes that only carry forward an identical vote by the same user,
+// as happens when the item they were cast on is versioned
+func continuedVotes(votes []PastVote) []bool {
+	type ending struct {
+		user  string
+		at    int64
+		score float32
+	}
+	ended := map[ending]bool{}
+	for _, vote := range votes {
+		if vote.Ended != nil {
+			ended[ending{vote.User, vote.Ended.UnixNano(), vote.Score}] = true
+		}
+	}
+
+	continued := make([]bool, len(votes))
+	for i, vote := range votes {
+		continued[i] = ended[ending{vote.User, vote.Cast.UnixNano(), vote.Score}]
+	}
+	return continued
+}
+
+// Charts the history of the item with the given ID in the given collection within the window
+// chosen by ScoreHistoryWindow, loading only the votes that were active during that window
+func chartScoreHistory(ctx *ServerContext, collectionName, id string, created time.Time, bucket string, from, to *time.Time, defaultScore float32) ([]ScorePoint, Error) {
+	start, end, err := ScoreHistoryWindow(bucket, created, ctx.RequestTime(), from, to)
+	if err != nil {
+		return []ScorePoint{}, err
+	}
+	first, err := BucketStart(bucket, start)
+	if err != nil {
+		return []ScorePoint{}, err
+	}
+
+	votes, err := VoteHistory(ctx, collectionName, id, first, end)
+	if err != nil {
+		return []ScorePoint{}, err
+	}
+	return BuildScoreHistory(votes, bucket, start, end, SCORE_AGGREGATOR, defaultScore)
+}
+
+// Returns every vote cast on any version of the item with the given ID in the given collection
+// before to, that was still active at from
+func VoteHistory(ctx *ServerContext, collectionName, id string, from, to time.Time) ([]PastVote, Error) {
+	votes := []PastVote{}
+
+	bindVars := BindVars{
+		"target": id,
+		"from":   from,
+		"to":     to,
+	}
+	query := fmt.Sprintf(`FOR obj IN %s
+                                 FOR t IN %s
+                                   FILTER obj._to == t._id
+                                      AND t.id == @target
+                                      AND obj.start <= @to
+                                      AND (obj.end == null OR obj.end >= @from)
+                                   FOR u IN %s
+                                     FILTER u._id == obj._from
+                                     SORT obj.start ASC
+                                     RETURN { user: obj._from, score: obj.score, cast: obj.start, end: obj.end, joined: u.start, verified: u.verified != null }`,
+		UserScore{}.CollectionName(),
+		collectionName,
+		User{}.CollectionName())
+
+	db := ctx.Arango.DB
+	cursor, err := db.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return votes, NewServerError(err.Error())
+	}
+	for cursor.HasMore() {
+		vote := PastVote{}
+		if _, err := cursor.ReadDocument(ctx.Context, &vote); err != nil {
+			return votes, NewServerError(err.Error())
+		}
+		votes = append(votes, vote)
+	}
+	return votes, nil
+}
@@ -0,0 +1,176 @@
+package gruff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketStart(t *testing.T) {
+	// A Wednesday
+	at := time.Date(2020, time.July, 15, 13, 45, 10, 0, time.UTC)
+
+	start, err := BucketStart(SCORE_BUCKET_HOUR, at)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, time.July, 15, 13, 0, 0, 0, time.UTC), start)
+
+	start, err = BucketStart(SCORE_BUCKET_DAY, at)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, time.July, 15, 0, 0, 0, 0, time.UTC), start)
+
+	start, err = BucketStart(SCORE_BUCKET_WEEK, at)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, time.July, 13, 0, 0, 0, 0, time.UTC), start)
+
+	start, err = BucketStart(SCORE_BUCKET_MONTH, at)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC), start)
+
+	_, err = BucketStart("fortnight", at)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_BUSINESS_ERROR, err.Code())
+}
+
+func TestBuildScoreHistory(t *testing.T) {
+	day1 := time.Date(2020, time.July, 1, 9, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2)
+
+	votes := []PastVote{
+		// Changed their mind on the second day
+		{User: "users/1", Vote: Vote{Score: 0.8, Cast: day1}, Ended: support.TimePtr(day2)},
+		{User: "users/1", Vote: Vote{Score: 0.2, Cast: day2}, Ended: support.TimePtr(day3)},
+		// Carried forward unchanged when the item was versioned on the third day
+		{User: "users/1", Vote: Vote{Score: 0.2, Cast: day3}},
+		{User: "users/2", Vote: Vote{Score: 0.6, Cast: day2}},
+	}
+
+	history, err := BuildScoreHistory(votes, SCORE_BUCKET_DAY, day1, day3.Add(time.Hour), MeanAggregator{}, 0.5)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(history))
+
+	assert.Equal(t, time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC), history[0].Start)
+	assert.Equal(t, time.Date(2020, time.July, 2, 0, 0, 0, 0, time.UTC), history[0].End)
+	assert.InDelta(t, 0.8, history[0].Score, 0.0001)
+	assert.Equal(t, 1, history[0].Votes)
+	assert.Equal(t, 1, history[0].Cast)
+
+	assert.InDelta(t, 0.4, history[1].Score, 0.0001)
+	assert.Equal(t, 2, history[1].Votes)
+	assert.Equal(t, 2, history[1].Cast)
+
+	assert.Equal(t, day3.Add(time.Hour), history[2].End)
+	assert.InDelta(t, 0.4, history[2].Score, 0.0001)
+	assert.Equal(t, 2, history[2].Votes)
+	assert.Equal(t, 0, history[2].Cast)
+
+	// Before anyone voted
+	history, err = BuildScoreHistory(votes, SCORE_BUCKET_HOUR, day1.Add(-2*time.Hour), day1.Add(-time.Minute), MeanAggregator{}, 0.5)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, float32(0.5), history[0].Score)
+	assert.Equal(t, 0, history[0].Votes)
+
+	_, err = BuildScoreHistory(votes, SCORE_BUCKET_HOUR, day1.AddDate(-1, 0, 0), day3, MeanAggregator{}, 0.5)
+	assert.Error(t, err)
+}
+
+func TestBuildScoreHistoryOutOfOrder(t *testing.T) {
+	day1 := time.Date(2020, time.July, 1, 9, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	votes := []PastVote{
+		{User: "users/2", Vote: Vote{Score: 0.6, Cast: day2}},
+		{User: "users/1", Vote: Vote{Score: 0.2, Cast: day2}},
+		{User: "users/1", Vote: Vote{Score: 0.2, Cast: day1}, Ended: support.TimePtr(day2)},
+	}
+
+	history, err := BuildScoreHistory(votes, SCORE_BUCKET_DAY, day1, day2.Add(time.Hour), MeanAggregator{}, 0.5)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.InDelta(t, 0.2, history[0].Score, 0.0001)
+	assert.Equal(t, 1, history[0].Cast)
+	assert.InDelta(t, 0.4, history[1].Score, 0.0001)
+	assert.Equal(t, 2, history[1].Votes)
+	assert.Equal(t, 1, history[1].Cast)
+}
+
+func TestScoreHistoryWindow(t *testing.T) {
+	created := time.Date(2018, time.March, 3, 12, 0, 0, 0, time.UTC)
+	now := time.Date(2020, time.July, 15, 13, 45, 0, 0, time.UTC)
+
+	// The most recent buckets, however long the item has been around
+	from, to, err := ScoreHistoryWindow(SCORE_BUCKET_HOUR, created, now, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, now, to)
+	assert.Equal(t, time.Date(2020, time.July, 11, 10, 0, 0, 0, time.UTC), from)
+	history, err := BuildScoreHistory([]PastVote{}, SCORE_BUCKET_HOUR, from, to, MeanAggregator{}, 0.5)
+	assert.NoError(t, err)
+	assert.Equal(t, SCORE_HISTORY_DEFAULT_BUCKETS, len(history))
+
+	// No further back than the item itself
+	from, _, err = ScoreHistoryWindow(SCORE_BUCKET_MONTH, created, now, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, created, from)
+
+	// The most recent buckets before to
+	end := time.Date(2019, time.January, 10, 0, 0, 0, 0, time.UTC)
+	from, to, err = ScoreHistoryWindow(SCORE_BUCKET_DAY, created, now, nil, &end)
+	assert.NoError(t, err)
+	assert.Equal(t, end, to)
+	assert.Equal(t, time.Date(2018, time.October, 3, 0, 0, 0, 0, time.UTC), from)
+
+	// An explicit window, which can't reach into the future
+	start := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	future := now.AddDate(1, 0, 0)
+	from, to, err = ScoreHistoryWindow(SCORE_BUCKET_DAY, created, now, &start, &future)
+	assert.NoError(t, err)
+	assert.Equal(t, start, from)
+	assert.Equal(t, now, to)
+
+	_, _, err = ScoreHistoryWindow(SCORE_BUCKET_DAY, created, now, &future, nil)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_BUSINESS_ERROR, err.Code())
+
+	_, _, err = ScoreHistoryWindow("fortnight", created, now, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestClaimScoreHistory(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Username: "historian", Email: "historian@gruff.org"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	claim := Claim{Title: "Opinions change"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = u.Score(CTX, &claim, 0.90)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = u.Score(CTX, &claim, 0.30)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	history, err := claim.ScoreHistory(CTX, SCORE_BUCKET_DAY, nil, nil)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.NotEmpty(t, history)
+	latest := history[len(history)-1]
+	assert.InDelta(t, 0.30, latest.Score, 0.0001)
+	assert.Equal(t, 1, latest.Votes)
+
+	missing := Claim{}
+	missing.ID = "not-a-real-id"
+	_, err = missing.ScoreHistory(CTX, SCORE_BUCKET_DAY, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+}
@@ -0,0 +1,348 @@
+package gruff
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+	arango "github.com/arangodb/go-driver"
+)
+
+/*
+ * A ScoreJob asks for the scores of a Claim or Argument to be recalculated
+ * by the ScoreWorker, outside of the request that changed them.
+ *
+ * Jobs are keyed by the item they rescore, so that any number of requests
+ * to rescore the same item are coalesced into a single job until it is processed.
+ * Since items are identified by their ID rather than their key, a job
+ * survives the item being versioned while it is waiting in the queue.
+ *
+ * Several servers may run a ScoreWorker against the same queue. A worker first leases
+ * the jobs it is going to process, so that no other worker picks them up, and only
+ * removes a job once its item has been rescored. If the worker dies along the way,
+ * the lease runs out and another worker picks the job up again.
+ */
+
+// When true, votes and new versions queue a ScoreJob instead of rescoring during the request
+var QUEUE_SCORE_UPDATES bool = false
+
+const SCORE_JOB_MAX_ATTEMPTS int = 5
+
+// How long a worker has to process the jobs it leased before other workers may take them over
+const SCORE_JOB_LEASE time.Duration = 2 * time.Minute
+
+type ScoreJob struct {
+	Key        string    `json:"_key"`
+	Collection string    `json:"collection"`
+	TargetID   string    `json:"targetId"`
+	CreatedAt  time.Time `json:"start"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error,omitempty"`
+	// The lease held by the worker processing the job, and when it runs out,
+	// in milliseconds as told by the database server's clock
+	Lease       string `json:"lease,omitempty"`
+	LeasedUntil int64  `json:"leasedUntil,omitempty"`
+	// Set when the job is queued again while it is leased, so that it gets processed once more
+	Requeued bool `json:"requeued,omitempty"`
+}
+
+type ScoreQueueStatus struct {
+	Depth  int64      `json:"depth"`
+	Oldest *time.Time `json:"oldest"`
+}
+
+// ArangoObject interface
+
+func (j ScoreJob) CollectionName() string {
+	return "score_jobs"
+}
+
+func (j ScoreJob) ArangoKey() string {
+	return j.Key
+}
+
+func (j ScoreJob) ArangoID() string {
+	return fmt.Sprintf("%s/%s", j.CollectionName(), j.ArangoKey())
+}
+
+func (j ScoreJob) DefaultQueryParameters() ArangoQueryParameters {
+	params := ArangoQueryParameters{
+		Sort: support.StringPtr("obj.start ASC"),
+	}
+	return DEFAULT_QUERY_PARAMETERS.Merge(params)
+}
+
+// Adds the job to the queue, unless there is already one waiting for the same item.
+// If that one is being processed, it is marked to be processed again afterwards.
+func (j *ScoreJob) Create(ctx *ServerContext) Error {
+	j.PrepareForCreate(ctx)
+
+	bindVars := BindVars{
+		"key":        j.Key,
+		"collection": j.Collection,
+		"target":     j.TargetID,
+		"start":      j.CreatedAt,
+		"attempts":   j.Attempts,
+		"error":      j.Error,
+	}
+	query := fmt.Sprintf(`UPSERT { _key: @key }
+                               INSERT { _key: @key, collection: @collection, targetId: @target, start: @start, attempts: @attempts, error: @error }
+                               UPDATE { requeued: OLD.lease != null }
+                               IN %s`,
+		j.CollectionName())
+	if _, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (j *ScoreJob) Update(ctx *ServerContext, updates Updates) Error {
+	return NewServerError("Score jobs cannot be modified")
+}
+
+// Jobs are removed from the queue outright, rather than soft-deleted
+func (j *ScoreJob) Delete(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(j)
+	if err != nil {
+		return err
+	}
+	if _, err := col.RemoveDocument(ctx.Context, j.ArangoKey()); err != nil {
+		if arango.IsNotFound(err) {
+			return nil
+		}
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (j *ScoreJob) PrepareForCreate(ctx *ServerContext) {
+	j.Key = fmt.Sprintf("%s-%s", j.Collection, j.TargetID)
+	j.CreatedAt = ctx.RequestTime()
+}
+
+func (j *ScoreJob) PrepareForDelete(ctx *ServerContext) {
+}
+
+// Business methods
+
+func NewScoreJob(target ArangoObject) (ScoreJob, Error) {
+	job := ScoreJob{Collection: target.CollectionName()}
+	switch t := target.(type) {
+	case *Claim:
+		job.TargetID = t.ID
+	case *Argument:
+		job.TargetID = t.ID
+	default:
+		return job, NewServerError("Only claims and arguments can be rescored")
+	}
+	return job, nil
+}
+
+// Rescores the target right away, or queues it for the ScoreWorker if QUEUE_SCORE_UPDATES is set
+func ScheduleScoreUpdate(ctx *ServerContext, target ArangoObject) Error {
+	if !QUEUE_SCORE_UPDATES {
+		if scorer, ok := target.(Scorer); ok {
+			return scorer.UpdateScore(ctx)
+		}
+		return nil
+	}
+
+	job, err := NewScoreJob(target)
+	if err != nil {
+		return err
+	}
+	return job.Create(ctx)
+}
+
+// Leases up to limit of the oldest jobs that aren't already leased, or whose lease has run out
+func LeaseScoreJobs(ctx *ServerContext, limit int) ([]ScoreJob, Error) {
+	jobs := []ScoreJob{}
+	lease, err := newOpaqueToken()
+	if err != nil {
+		return jobs, err
+	}
+	bindVars := BindVars{
+		"limit":    limit,
+		"lease":    lease,
+		"duration": int64(SCORE_JOB_LEASE / time.Millisecond),
+	}
+	query := fmt.Sprintf(`LET now = DATE_NOW()
+                               FOR obj IN %[1]s
+                                 FILTER obj.leasedUntil == null OR obj.leasedUntil < now
+                                 SORT obj.start ASC
+                                 LIMIT @limit
+                                 UPDATE obj WITH { lease: @lease, leasedUntil: now + @duration, requeued: false, attempts: obj.attempts + 1 } IN %[1]s
+                                 RETURN NEW`,
+		ScoreJob{}.CollectionName())
+	err = FindArangoObjects(ctx, query, bindVars, &jobs)
+	return jobs, err
+}
+
+func GetScoreQueueStatus(ctx *ServerContext) (ScoreQueueStatus, Error) {
+	status := ScoreQueueStatus{}
+	query := fmt.Sprintf(`FOR obj IN %s
+                                 COLLECT AGGREGATE depth = COUNT(obj), oldest = MIN(obj.start)
+                                 RETURN { depth, oldest }`,
+		ScoreJob{}.CollectionName())
+
+	cursor, err := ctx.Arango.DB.Query(ctx.Context, query, BindVars{})
+	defer CloseCursor(cursor)
+	if err != nil {
+		return status, NewServerError(err.Error())
+	}
+	if _, err := cursor.ReadDocument(ctx.Context, &status); err != nil {
+		return status, NewServerError(err.Error())
+	}
+	return status, nil
+}
+
+// Recalculates the scores of the job's target, which must have been leased with LeaseScoreJobs.
+// The job stays in the queue until its target has been rescored.
+func (j ScoreJob) Process(ctx *ServerContext) Error {
+	if j.Lease == "" {
+		return NewServerError("Score jobs must be leased before they are processed")
+	}
+
+	err := j.rescore(ctx)
+	if err != nil && err.Code() != ERROR_CODE_NOT_FOUND {
+		if j.Attempts >= SCORE_JOB_MAX_ATTEMPTS {
+			if rerr := j.finish(ctx, true); rerr != nil {
+				return rerr
+			}
+		} else if rerr := j.release(ctx, err); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+
+	// Either the target was rescored, or it has been deleted since the job was queued
+	return j.finish(ctx, false)
+}
+
+// Removes the job from the queue, unless it was queued again while it was being processed
+// (and force is false), in which case it is released to be processed once more
+func (j ScoreJob) finish(ctx *ServerContext, force bool) Error {
+	bindVars := BindVars{
+		"key":   j.Key,
+		"lease": j.Lease,
+		"force": force,
+	}
+	query := fmt.Sprintf(`FOR obj IN %[1]s
+                               FILTER obj._key == @key
+                                  AND obj.lease == @lease
+                                  AND (@force OR obj.requeued != true)
+                               REMOVE obj IN %[1]s
+                               RETURN OLD._key`,
+		j.CollectionName())
+	cursor, dberr := ctx.Arango.DB.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if dberr != nil {
+		return NewServerError(dberr.Error())
+	}
+	if cursor.HasMore() {
+		return nil
+	}
+
+	j.Attempts = 0
+	return j.release(ctx, nil)
+}
+
+// Gives up the job's lease, so that it is processed again, recording the error if there was one
+func (j ScoreJob) release(ctx *ServerContext, cause Error) Error {
+	bindVars := BindVars{
+		"key":      j.Key,
+		"lease":    j.Lease,
+		"attempts": j.Attempts,
+		"error":    j.Error,
+	}
+	if cause != nil {
+		bindVars["error"] = cause.Error()
+	}
+	query := fmt.Sprintf(`FOR obj IN %[1]s
+                               FILTER obj._key == @key
+                                  AND obj.lease == @lease
+                               UPDATE obj WITH { lease: null, leasedUntil: null, attempts: @attempts, error: @error } IN %[1]s
+                               OPTIONS { keepNull: false }`,
+		j.CollectionName())
+	if _, err := ctx.Arango.DB.Query(ctx.Context, query, bindVars); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+func (j ScoreJob) rescore(ctx *ServerContext) Error {
+	switch j.Collection {
+	case Claim{}.CollectionName():
+		claim := Claim{}
+		claim.ID = j.TargetID
+		if err := claim.Load(ctx); err != nil {
+			return err
+		}
+		return claim.UpdateScore(ctx)
+	case Argument{}.CollectionName():
+		arg := Argument{}
+		arg.ID = j.TargetID
+		if err := arg.Load(ctx); err != nil {
+			return err
+		}
+		return arg.UpdateScore(ctx)
+	}
+	return NewServerError(fmt.Sprintf("Unknown score job collection: %s", j.Collection))
+}
+
+// The ScoreWorker processes queued ScoreJobs in the background, oldest first
+type ScoreWorker struct {
+	DB        arango.Database
+	Interval  time.Duration
+	BatchSize int
+}
+
+// Processes jobs until the stop channel is closed, waiting for the interval whenever the queue is empty
+func (w ScoreWorker) Run(stop <-chan struct{}) {
+	for {
+		n, err := w.ProcessBatch()
+		if err != nil {
+			fmt.Println("Error processing score jobs:", err.Error())
+		}
+		if n == 0 || err != nil {
+			select {
+			case <-stop:
+				return
+			case <-time.After(w.Interval):
+			}
+		} else {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Leases and processes up to BatchSize jobs, returning the number that were processed
+func (w ScoreWorker) ProcessBatch() (int, Error) {
+	jobs, err := LeaseScoreJobs(w.context(), w.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		// Each job gets its own context, so that it gets its own request time
+		if err := job.Process(w.context()); err != nil {
+			fmt.Printf("Error rescoring %s %s: %s\n", job.Collection, job.TargetID, err.Error())
+		}
+	}
+	return len(jobs), nil
+}
+
+func (w ScoreWorker) context() *ServerContext {
+	return &ServerContext{
+		Context: context.Background(),
+		Arango: ArangoContext{
+			Context: context.Background(),
+			DB:      w.DB,
+		},
+	}
+}
@@ -0,0 +1,216 @@
+package gruff
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScoreJob(t *testing.T) {
+	claim := Claim{}
+	claim.ID = "claim-id"
+	job, err := NewScoreJob(&claim)
+	assert.NoError(t, err)
+	assert.Equal(t, "claims", job.Collection)
+	assert.Equal(t, "claim-id", job.TargetID)
+
+	arg := Argument{}
+	arg.ID = "arg-id"
+	job, err = NewScoreJob(&arg)
+	assert.NoError(t, err)
+	assert.Equal(t, "arguments", job.Collection)
+	assert.Equal(t, "arg-id", job.TargetID)
+
+	_, err = NewScoreJob(&Context{})
+	assert.Error(t, err)
+
+	assert.True(t, IsArangoObject(reflect.TypeOf(&ScoreJob{})))
+}
+
+func TestScoreQueue(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	QUEUE_SCORE_UPDATES = true
+	defer func() { QUEUE_SCORE_UPDATES = false }()
+
+	u := User{
+		Username: "QueuedVoter",
+	}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{Title: "Votes can wait their turn"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	status, err := GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), status.Depth)
+	assert.Nil(t, status.Oldest)
+
+	err = u.Score(CTX, &claim, 0.90)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Repeated votes on the same claim are coalesced into a single job
+	err = u.Score(CTX, &claim, 0.20)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	status, err = GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), status.Depth)
+	assert.NotNil(t, status.Oldest)
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, saved.Truth)
+
+	worker := ScoreWorker{DB: CTX.Arango.DB, BatchSize: 10}
+	n, err := worker.ProcessBatch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	saved = Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0.20), saved.Truth)
+
+	status, err = GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), status.Depth)
+
+	n, err = worker.ProcessBatch()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestScoreQueuePremises(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	QUEUE_SCORE_UPDATES = true
+	defer func() { QUEUE_SCORE_UPDATES = false }()
+
+	claim := Claim{Title: "Premises can wait their turn too", MultiPremise: true, PremiseRule: PREMISE_RULE_ALL}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	premise := Claim{Title: "The first of several premises"}
+	err = claim.AddPremise(CTX, &premise)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Adding the premise versions the claim, and both rescorings are queued as one job
+	status, err := GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), status.Depth)
+
+	worker := ScoreWorker{DB: CTX.Arango.DB, BatchSize: 10}
+	n, err := worker.ProcessBatch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	err = claim.RemovePremise(CTX, premise.ID)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	status, err = GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), status.Depth)
+}
+
+func TestScoreJobForDeletedItem(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	job := ScoreJob{Collection: Claim{}.CollectionName(), TargetID: "not-a-real-claim"}
+	err := job.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Jobs have to be leased first
+	err = job.Process(CTX)
+	assert.Error(t, err)
+
+	jobs, err := LeaseScoreJobs(CTX, 10)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	err = jobs[0].Process(CTX)
+	assert.NoError(t, err)
+
+	status, err := GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), status.Depth)
+}
+
+func TestLeaseScoreJobs(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	job := ScoreJob{Collection: Claim{}.CollectionName(), TargetID: "not-a-real-claim"}
+	err := job.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	jobs, err := LeaseScoreJobs(CTX, 10)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	leased := jobs[0]
+	assert.NotEmpty(t, leased.Lease)
+	assert.Equal(t, 1, leased.Attempts)
+
+	// Other workers can't take a job while it is leased
+	jobs, err = LeaseScoreJobs(CTX, 10)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 0)
+
+	// A job queued again while it is being processed stays in the queue
+	err = job.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = leased.Process(CTX)
+	assert.NoError(t, err)
+
+	status, err := GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), status.Depth)
+
+	jobs, err = LeaseScoreJobs(CTX, 10)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	leased = jobs[0]
+
+	// A job whose lease has run out is taken over by the next worker
+	_, dberr := CTX.Arango.DB.Query(CTX.Context, "FOR obj IN score_jobs UPDATE obj WITH { leasedUntil: DATE_NOW() - 1 } IN score_jobs", BindVars{})
+	assert.NoError(t, dberr)
+
+	jobs, err = LeaseScoreJobs(CTX, 10)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.NotEqual(t, leased.Lease, jobs[0].Lease)
+
+	// The worker that lost the lease leaves the job alone
+	err = leased.Process(CTX)
+	assert.NoError(t, err)
+
+	status, err = GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), status.Depth)
+
+	err = jobs[0].Process(CTX)
+	assert.NoError(t, err)
+
+	status, err = GetScoreQueueStatus(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), status.Depth)
+}
@@ -2,6 +2,8 @@ package gruff
 
 import (
 	"reflect"
+	"sort"
+	"time"
 )
 
 type Scorer interface {
@@ -13,3 +15,117 @@ func IsScorer(t reflect.Type) bool {
 	modelType := reflect.TypeOf((*Scorer)(nil)).Elem()
 	return t.Implements(modelType)
 }
+
+// Combines a flat score with the roll-up strengths of the pro and con arguments made about it,
+// as described here: https://github.com/canonical-debate-lab/paper#33312_Rollup_Scores
+// Arguments with a combined strength of 1.0 carry as much weight as the flat score,
+// and the more argument strength there is, the more it outweighs the flat score.
+func RollUpScore(flat float32, pro, con []float32) float32 {
+	implied, total := ImpliedScore(pro, con)
+	if total <= 0.0 {
+		return flat
+	}
+
+	weight := total / (total + 1.0)
+	return (1.0-weight)*flat + weight*implied
+}
+
+// Returns the score implied by the strengths of the pro and con arguments alone,
+// along with their combined strength. The implied score is meaningless if there is no strength.
+func ImpliedScore(pro, con []float32) (float32, float32) {
+	var proTotal, conTotal float32
+	for _, s := range pro {
+		proTotal += s
+	}
+	for _, s := range con {
+		conTotal += s
+	}
+
+	total := proTotal + conTotal
+	if total <= 0.0 {
+		return 0.0, total
+	}
+	return proTotal / total, total
+}
+
+// Keeps track of the Claims already rolled up during a single calculation,
+// so that Claims used in several places are only scored once, and cycles are broken
+type rollUpState struct {
+	truths   map[string]float32
+	visiting map[string]bool
+	// The truth implied by the arguments of each Claim rolled up, if they had any strength
+	implied map[string]float32
+	// When set, a user's own votes (by item ID) replace the community's flat scores
+	votes map[string]float32
+}
+
+func newRollUpState() *rollUpState {
+	return &rollUpState{
+		truths:   map[string]float32{},
+		visiting: map[string]bool{},
+		implied:  map[string]float32{},
+	}
+}
+
+// Returns true if the roll-up scores stored on the items at queryAt can be used as they are,
+// rather than rolling up everything below them again. They are kept up to date as scores change,
+// so they can be used for the current state of the debate, unless it is seen from a user's point of view.
+func (s *rollUpState) useStored(queryAt *time.Time) bool {
+	return queryAt == nil && s.votes == nil
+}
+
+// Derives the truth of a multi-premise Claim from the truth of its premises.
+// With PREMISE_RULE_ALL, all premises must be true (product).
+// With PREMISE_RULE_ANY, at least one premise must be true (noisy-or).
+// With PREMISE_RULE_ANY_TWO, at least two premises must be true (second-highest).
+func PremiseRuleScore(rule int, scores []float32) float32 {
+	if len(scores) == 0 {
+		return DEFAULT_CLAIM_SCORE
+	}
+
+	switch rule {
+	case PREMISE_RULE_ALL:
+		var score float32 = 1.0
+		for _, s := range scores {
+			score *= s
+		}
+		return score
+	case PREMISE_RULE_ANY:
+		var none float32 = 1.0
+		for _, s := range scores {
+			none *= 1.0 - s
+		}
+		return 1.0 - none
+	case PREMISE_RULE_ANY_TWO:
+		if len(scores) < 2 {
+			return 0.0
+		}
+		sorted := make([]float32, len(scores))
+		copy(sorted, scores)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+		return sorted[1]
+	}
+
+	return DEFAULT_CLAIM_SCORE
+}
+
+// Keeps track of the items being rescored while a score change cascades up through the debate,
+// so that cycles are broken. Since each item's roll-up score is calculated from the scores stored
+// on the items below it, an item reached again along another path is rescored again.
+type scoreCascade map[string]bool
+
+// Returns true if the item is already being rescored further down the cascade,
+// and marks it as being rescored otherwise
+func (sc scoreCascade) visit(obj ArangoObject) bool {
+	id := obj.ArangoID()
+	if sc[id] {
+		return true
+	}
+	sc[id] = true
+	return false
+}
+
+// Marks the item as no longer being rescored
+func (sc scoreCascade) leave(obj ArangoObject) {
+	delete(sc, obj.ArangoID())
+}
@@ -18,3 +18,29 @@ func TestIsScorer(t *testing.T) {
 	assert.False(t, IsScorer(reflect.TypeOf(&ContextEdge{})))
 	assert.False(t, IsScorer(reflect.TypeOf(&UserScore{})))
 }
+
+func TestRollUpScore(t *testing.T) {
+	assert.Equal(t, float32(0.3), RollUpScore(0.3, []float32{}, []float32{}))
+	assert.Equal(t, float32(0.3), RollUpScore(0.3, []float32{0.0}, []float32{0.0}))
+
+	// A single argument with full strength carries as much weight as the flat score
+	assert.InDelta(t, 0.75, RollUpScore(0.5, []float32{1.0}, []float32{}), 0.0001)
+	assert.InDelta(t, 0.25, RollUpScore(0.5, []float32{}, []float32{1.0}), 0.0001)
+	assert.InDelta(t, 0.5, RollUpScore(0.5, []float32{0.5}, []float32{0.5}), 0.0001)
+
+	// More argument strength outweighs the flat score
+	assert.InDelta(t, 0.2667, RollUpScore(0.8, []float32{}, []float32{1.0, 1.0}), 0.0001)
+	assert.InDelta(t, 0.8857, RollUpScore(0.6, []float32{0.5, 0.5, 0.5, 0.5, 0.5}, []float32{0.0}), 0.0001)
+}
+
+func TestPremiseRuleScore(t *testing.T) {
+	scores := []float32{0.5, 0.8, 0.9}
+
+	assert.InDelta(t, 0.36, PremiseRuleScore(PREMISE_RULE_ALL, scores), 0.0001)
+	assert.InDelta(t, 0.99, PremiseRuleScore(PREMISE_RULE_ANY, scores), 0.0001)
+	assert.Equal(t, float32(0.8), PremiseRuleScore(PREMISE_RULE_ANY_TWO, scores))
+	assert.Equal(t, float32(0.0), PremiseRuleScore(PREMISE_RULE_ANY_TWO, []float32{0.9}))
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, PremiseRuleScore(PREMISE_RULE_NONE, scores))
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, PremiseRuleScore(PREMISE_RULE_ALL, []float32{}))
+	assert.Equal(t, []float32{0.5, 0.8, 0.9}, scores)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/GruffDebate/server/support"
+	arango "github.com/arangodb/go-driver"
 )
 
 type ServerContext struct {
@@ -37,6 +38,87 @@ func (ctx *ServerContext) RequestTime() time.Time {
 	return *ctx.RequestAt
 }
 
-func (ctx ServerContext) Rollback() Error {
-	return ctx.Arango.Rollback()
+// Collections that are written to when a Claim or Argument is versioned or moved
+var VERSIONING_COLLECTIONS = []string{
+	Claim{}.CollectionName(),
+	Argument{}.CollectionName(),
+	Inference{}.CollectionName(),
+	BaseClaimEdge{}.CollectionName(),
+	PremiseEdge{}.CollectionName(),
+	ContextEdge{}.CollectionName(),
+	UserScore{}.CollectionName(),
+	Notification{}.CollectionName(),
+	Follow{}.CollectionName(),
+}
+
+// Begins a stream transaction that all subsequent database calls made with this context will join.
+// Every collection that may be written to must be declared up front.
+// If a transaction is already in progress, it is joined instead, and the collections are ignored.
+func (ctx *ServerContext) StartTransaction(collections ...string) Error {
+	if ctx.Arango.Transaction != nil {
+		ctx.Arango.Transaction.depth++
+		return nil
+	}
+
+	// Reads from undeclared collections are still allowed
+	cols := arango.TransactionCollections{Write: collections}
+	opts := arango.BeginTransactionOptions{AllowImplicit: true}
+	tid, err := ctx.Arango.DB.BeginTransaction(ctx.Context, cols, &opts)
+	if err != nil {
+		return NewServerError(err.Error())
+	}
+
+	ctx.Arango.Transaction = &ArangoTransaction{
+		ID:     tid,
+		Parent: ctx.Context,
+		depth:  1,
+	}
+	ctx.Context = arango.WithTransactionID(ctx.Context, tid)
+	return nil
+}
+
+// Commits the current transaction, unless it is nested inside another one
+func (ctx *ServerContext) CommitTransaction() Error {
+	tx := ctx.Arango.Transaction
+	if tx == nil {
+		return nil
+	}
+
+	tx.depth--
+	if tx.depth > 0 {
+		return nil
+	}
+
+	ctx.Arango.Transaction = nil
+	ctx.Context = tx.Parent
+	if err := ctx.Arango.DB.CommitTransaction(ctx.Context, tx.ID, nil); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+// Aborts the current transaction, including any transactions it is nested inside.
+// It is safe to call when there is no transaction in progress.
+func (ctx *ServerContext) Rollback() Error {
+	tx := ctx.Arango.Transaction
+	if tx == nil {
+		return nil
+	}
+
+	ctx.Arango.Transaction = nil
+	ctx.Context = tx.Parent
+	if err := ctx.Arango.DB.AbortTransaction(ctx.Context, tx.ID, nil); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+// Ends the current transaction, rolling it back if err is not nil or committing it otherwise.
+// Meant to be deferred right after StartTransaction by functions with a named Error result.
+func (ctx *ServerContext) EndTransaction(err Error) Error {
+	if err != nil {
+		ctx.Rollback()
+		return err
+	}
+	return ctx.CommitTransaction()
 }
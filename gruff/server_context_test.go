@@ -0,0 +1,95 @@
+package gruff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionCommit(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	err := CTX.StartTransaction(VERSIONING_COLLECTIONS...)
+	assert.NoError(t, err)
+	assert.NotNil(t, CTX.Arango.Transaction)
+
+	claim := Claim{Title: "Committed claims are kept"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Nested transactions join the outer one
+	err = CTX.StartTransaction(VERSIONING_COLLECTIONS...)
+	assert.NoError(t, err)
+	err = CTX.CommitTransaction()
+	assert.NoError(t, err)
+	assert.NotNil(t, CTX.Arango.Transaction)
+
+	err = CTX.CommitTransaction()
+	assert.NoError(t, err)
+	assert.Nil(t, CTX.Arango.Transaction)
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, claim.ArangoKey(), saved.ArangoKey())
+}
+
+func TestTransactionRollback(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	err := CTX.StartTransaction(VERSIONING_COLLECTIONS...)
+	assert.NoError(t, err)
+	err = CTX.StartTransaction(VERSIONING_COLLECTIONS...)
+	assert.NoError(t, err)
+
+	claim := Claim{Title: "Rolled back claims are forgotten"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// Rolling back a nested transaction aborts the outer one, too
+	err = CTX.Rollback()
+	assert.NoError(t, err)
+	assert.Nil(t, CTX.Arango.Transaction)
+	err = CTX.CommitTransaction()
+	assert.NoError(t, err)
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.Error(t, err)
+
+	err = CTX.Rollback()
+	assert.NoError(t, err)
+}
+
+func TestClaimVersionRollsBackOnFailure(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	claim := Claim{Title: "Half-made versions leave no trace"}
+	err := claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	originalKey := claim.ArangoKey()
+
+	// An unknown context makes versioning fail after the old version was deleted
+	unknown := Context{}
+	unknown.Key = "not-a-real-context"
+	err = claim.Update(CTX, Updates{"title": "This should never be saved", "contexts": []Context{unknown}})
+	assert.Error(t, err)
+	CTX.RequestAt = nil
+	assert.Nil(t, CTX.Arango.Transaction)
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, originalKey, saved.ArangoKey())
+	assert.Equal(t, "Half-made versions leave no trace", saved.Title)
+	assert.Nil(t, saved.DeletedAt)
+}
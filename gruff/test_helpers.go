@@ -53,6 +53,20 @@ func cleanData(db arango.Database) {
 		&Claim{},
 		&UserScore{},
 		&User{},
+		&ScoreJob{},
+		&LoginThrottle{},
+		&RefreshToken{},
+		&RevokedToken{},
+		&PasswordReset{},
+		&Role{},
+		&ExternalIdentity{},
+		&ExternalLogin{},
+		&LoginCode{},
+		&ApiKey{},
+		&Client{},
+		&RateLimitBucket{},
+		&Notification{},
+		&Follow{},
 	}
 
 	for _, m := range models {
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/GruffDebate/server/support"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,10 +17,13 @@ type User struct {
 	Password        string     `json:"password,omitempty" sql:"-" valid:"length(5|64)"`
 	HashedPassword  string     `json:"hashed_password"` // TODO: don't return this value via the API
 	Image           string     `json:"img,omitempty"`
-	Curator         bool       `json:"curator"`
-	Admin           bool       `json:"admin"`
+	Curator         bool       `json:"curator" settable:"false"`
+	Admin           bool       `json:"admin" settable:"false"`
+	Roles           []string   `json:"roles,omitempty" settable:"false"`
 	URL             string     `json:"url,omitempty"`
-	EmailVerifiedAt *time.Time `json:"-" settable:"false"`
+	EmailVerifiedAt *time.Time `json:"verified,omitempty" settable:"false"`
+	TokensRevokedAt *time.Time `json:"tokensRevokedAt,omitempty" settable:"false"`
+	BannedAt        *time.Time `json:"bannedAt,omitempty" settable:"false"`
 }
 
 // ArangoObject interface
@@ -75,7 +79,29 @@ func (u *User) Create(ctx *ServerContext) Error {
 
 // TODO: Test
 func (u *User) Update(ctx *ServerContext, updates Updates) Error {
-	return UpdateArangoObject(ctx, u, updates)
+	// Email addresses can only be verified by following the link sent to them,
+	// roles can only be changed with GrantRole and RevokeRole, and bans with Ban and Unban
+	for _, field := range []string{"verified", "roles", "curator", "admin", "hashed_password", "tokensRevokedAt", "bannedAt"} {
+		if _, ok := updates[field]; ok {
+			return NewPermissionError("field is unsettable", map[string]interface{}{"field": field})
+		}
+	}
+
+	email, ok := updates["email"].(string)
+	emailChanged := ok && !strings.EqualFold(email, u.Email)
+	if emailChanged {
+		updates["verified"] = nil
+	}
+
+	if err := UpdateArangoObject(ctx, u, updates); err != nil {
+		return err
+	}
+
+	if emailChanged {
+		u.Email = email
+		u.EmailVerifiedAt = nil
+	}
+	return nil
 }
 
 // TODO: Test
@@ -88,10 +114,10 @@ func (u *User) Delete(ctx *ServerContext) Error {
 // TODO: Call in CRUD and other methods
 func (u User) UserCanView(ctx *ServerContext) (bool, Error) {
 	user := ctx.UserContext
-	if user.Curator {
+	if user.ArangoKey() != "" && u.ArangoKey() == user.ArangoKey() {
 		return true, nil
 	}
-	return u.ArangoKey() == user.ArangoKey(), nil
+	return user.Can(ctx, PERMISSION_USER_VIEW_ANY)
 }
 
 func (u User) UserCanCreate(ctx *ServerContext) (bool, Error) {
@@ -99,12 +125,15 @@ func (u User) UserCanCreate(ctx *ServerContext) (bool, Error) {
 }
 
 func (u User) UserCanUpdate(ctx *ServerContext, updates Updates) (bool, Error) {
-	return u.UserCanView(ctx)
+	user := ctx.UserContext
+	if user.ArangoKey() != "" && u.ArangoKey() == user.ArangoKey() {
+		return true, nil
+	}
+	return user.Can(ctx, PERMISSION_USER_EDIT_ANY)
 }
 
 func (u User) UserCanDelete(ctx *ServerContext) (bool, Error) {
-	user := ctx.UserContext
-	return user.Curator, nil
+	return ctx.UserContext.Can(ctx, PERMISSION_USER_DELETE)
 }
 
 // Validator
@@ -219,6 +248,11 @@ func (u *User) LoadFull(ctx *ServerContext) Error {
 // Business methods
 
 func (u *User) VerifyPassword(ctx *ServerContext, password string) (bool, Error) {
+	if u.HashedPassword == "" {
+		// Users who only sign in through an identity provider take as long to turn away as anyone else
+		VerifyDummyPassword(password)
+		return false, NewBusinessError("This account has no password")
+	}
 	err := bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(password))
 	if err != nil {
 		return false, NewBusinessError(err.Error())
@@ -226,15 +260,80 @@ func (u *User) VerifyPassword(ctx *ServerContext, password string) (bool, Error)
 	return true, nil
 }
 
-func (u *User) ChangePassword(ctx *ServerContext, oldPassword string) Error {
+// Banned users can't sign in or use any of their tokens or API keys
+func (u User) Banned() bool {
+	return u.BannedAt != nil
+}
+
+// Bans the user and signs them out everywhere
+func (u *User) Ban(ctx *ServerContext) Error {
 	col, err := ctx.Arango.CollectionFor(u)
 	if err != nil {
 		return err
 	}
 
+	u.BannedAt = support.TimePtr(ctx.RequestTime())
+	if _, err := col.UpdateDocument(ctx.Context, u.ArangoKey(), Updates{"bannedAt": u.BannedAt}); err != nil {
+		return NewServerError(err.Error())
+	}
+
+	return u.RevokeTokens(ctx)
+}
+
+// Lets a banned user sign in again
+func (u *User) Unban(ctx *ServerContext) Error {
+	u.BannedAt = nil
+	query := fmt.Sprintf(`FOR obj IN %[1]s
+                               FILTER obj._key == @key
+                               UPDATE obj WITH { bannedAt: null } IN %[1]s
+                               OPTIONS { keepNull: false }`,
+		u.CollectionName())
+	if _, err := ctx.Arango.DB.Query(ctx.Context, query, BindVars{"key": u.ArangoKey()}); err != nil {
+		return NewServerError(err.Error())
+	}
+	return nil
+}
+
+// Returns an error if the user has been banned
+func (u User) CheckBanned() Error {
+	if u.Banned() {
+		return NewPermissionError("This account has been banned", ERROR_SUBCODE_USER_BANNED)
+	}
+	return nil
+}
+
+// A hash of a password nobody has, made with bcrypt.DefaultCost
+const DUMMY_PASSWORD_HASH string = "$2a$10$f3qAm6FF5H4Z7Q5hlB/t/uiKoqzgxZ2/cZg0A4X3CBW6OHg9s74sG"
+
+// Checks the password against a hash that will never match, so that turning away someone
+// who gave an unknown email takes as long as turning away a wrong password
+func VerifyDummyPassword(password string) {
+	bcrypt.CompareHashAndPassword([]byte(DUMMY_PASSWORD_HASH), []byte(password))
+}
+
+// Replaces the user's password with the one in u.Password, as long as oldPassword is their current one
+func (u *User) ChangePassword(ctx *ServerContext, oldPassword string) Error {
 	if u.Password == "" {
 		return NewBusinessError("New Password: non zero value required;")
 	}
+	if ok, _ := u.VerifyPassword(ctx, oldPassword); !ok {
+		u.Password = ""
+		return NewBusinessError("Old Password: is incorrect;", ERROR_SUBCODE_CREDENTIALS_INVALID)
+	}
+	if err := u.ValidateField("Password"); err != nil {
+		u.Password = ""
+		return err
+	}
+	return u.setPassword(ctx)
+}
+
+// Hashes and saves the new password in u.Password
+func (u *User) setPassword(ctx *ServerContext) Error {
+	col, err := ctx.Arango.CollectionFor(u)
+	if err != nil {
+		return err
+	}
+
 	newPassword := u.Password
 	u.Password = ""
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
@@ -254,6 +353,10 @@ func (u *User) ChangePassword(ctx *ServerContext, oldPassword string) Error {
 // Scoring
 
 func (u User) Score(ctx *ServerContext, target ArangoObject, score float32) Error {
+	if err := u.CanVote(); err != nil {
+		return err
+	}
+
 	oldScore, err := u.ScoreFor(ctx, target)
 	if err != nil {
 		return err
@@ -278,12 +381,28 @@ func (u User) Score(ctx *ServerContext, target ArangoObject, score float32) Erro
 		return err
 	}
 
-	//go target.UpdateScores(ctx)
-	if scorer, ok := target.(Scorer); ok {
-		scorer.UpdateScore(ctx)
+	if err := u.Follow(ctx, target); err != nil {
+		return err
 	}
 
-	return nil
+	return ScheduleScoreUpdate(ctx, target)
+}
+
+// Withdraws the user's current vote on the target, so that they no longer have an opinion on it
+func (u User) RetractScore(ctx *ServerContext, target ArangoObject) Error {
+	oldScore, err := u.ScoreFor(ctx, target)
+	if err != nil {
+		return err
+	}
+	if oldScore == nil {
+		return NewNotFoundError("You have not scored this item")
+	}
+
+	if err := oldScore.Delete(ctx); err != nil {
+		return err
+	}
+
+	return ScheduleScoreUpdate(ctx, target)
 }
 
 func (u *User) ScoreFor(ctx *ServerContext, target ArangoObject) (*UserScore, Error) {
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/GruffDebate/server/support"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,6 +50,96 @@ func TestCreateUser(t *testing.T) {
 	assert.Equal(t, user.URL, saved.URL)
 }
 
+func TestUserBan(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Name: "Troublemaker", Username: "Troublemaker", Email: "trouble@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.NoError(t, u.CheckBanned())
+
+	err = u.Ban(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	saved := User{}
+	saved.Key = u.Key
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.True(t, saved.Banned())
+	err = saved.CheckBanned()
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_USER_BANNED, err.Subcode())
+
+	// Bans can't be lifted by editing the user
+	err = saved.Update(CTX, Updates{"bannedAt": nil})
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_PERMISSION_ERROR, err.Code())
+	CTX.RequestAt = nil
+
+	// Nothing issued after the ban can be used either
+	CTX.RequestAt = support.TimePtr(time.Now().Add(time.Minute))
+	refresh, err := IssueRefreshToken(CTX, saved, "")
+	assert.NoError(t, err)
+	_, _, err = UseRefreshToken(CTX, refresh)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_USER_BANNED, err.Subcode())
+
+	_, apiKey, err := saved.IssueApiKey(CTX, "Bot", API_KEY_SCOPE_READ)
+	assert.NoError(t, err)
+	_, _, err = UseApiKey(CTX, apiKey)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_USER_BANNED, err.Subcode())
+	CTX.RequestAt = nil
+
+	err = saved.Unban(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	saved = User{}
+	saved.Key = u.Key
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.False(t, saved.Banned())
+	assert.NoError(t, saved.CheckBanned())
+}
+
+func TestUserChangePassword(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{Name: "Changer", Username: "Changer", Email: "changer@gruff.org", Password: "123456"}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	u.Password = "654321"
+	err = u.ChangePassword(CTX, "wrong1")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_CREDENTIALS_INVALID, err.Subcode())
+
+	u.Password = "123"
+	err = u.ChangePassword(CTX, "123456")
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_SUBCODE_PASSWORD_LENGTH, err.Subcode())
+
+	u.Password = "654321"
+	err = u.ChangePassword(CTX, "123456")
+	assert.NoError(t, err)
+	assert.Equal(t, "", u.Password)
+
+	saved := User{}
+	saved.Key = u.Key
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	ok, _ := saved.VerifyPassword(CTX, "654321")
+	assert.True(t, ok)
+	ok, _ = saved.VerifyPassword(CTX, "123456")
+	assert.False(t, ok)
+}
+
 func TestUserScoreFor(t *testing.T) {
 	setupDB()
 	defer teardownDB()
@@ -289,3 +380,46 @@ func TestUserScoreFor(t *testing.T) {
 // TODO: test update
 // TODO: test change password
 // TODO: test validations
+
+func TestUserRetractScore(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	u := User{
+		Username: "TheChangedMind",
+	}
+	err := u.Create(CTX)
+	assert.NoError(t, err)
+
+	claim := Claim{
+		Title: "I'm not so sure anymore",
+	}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = u.RetractScore(CTX, &claim)
+	assert.Error(t, err)
+	assert.Equal(t, ERROR_CODE_NOT_FOUND, err.Code())
+	CTX.RequestAt = nil
+
+	err = u.Score(CTX, &claim, 0.10)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, float32(0.10), claim.Truth)
+
+	err = u.RetractScore(CTX, &claim)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, claim.Truth)
+
+	score, err := u.ScoreFor(CTX, &claim)
+	assert.NoError(t, err)
+	assert.Nil(t, score)
+
+	saved := Claim{}
+	saved.ID = claim.ID
+	err = saved.Load(CTX)
+	assert.NoError(t, err)
+	assert.Equal(t, DEFAULT_CLAIM_SCORE, saved.Truth)
+}
@@ -0,0 +1,146 @@
+package gruff
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+ * A UserView scores a debate from the point of view of a single user:
+ * wherever the user has voted, their own vote replaces the community's score
+ * before everything is rolled up, and the community's scores fill in the rest.
+ *
+ * Comparing a user's vote on a Claim with the truth implied by their own ratings
+ * of its arguments shows where their beliefs are inconsistent with each other.
+ */
+
+type UserView struct {
+	// The user's own vote, if they have cast one
+	Score *float32 `json:"score,omitempty"`
+	// The roll-up truth (for a Claim) or strength (for an Argument), calculated from the user's point of view
+	RollUp float32 `json:"rollUp"`
+	// The truth implied by the user's view of the arguments about a Claim, if they carry any strength
+	Implied *float32 `json:"implied,omitempty"`
+	// How far the user's own vote is from the truth implied by the arguments, from 0 to 1
+	Inconsistency *float32 `json:"inconsistency,omitempty"`
+}
+
+// How many levels of arguments and premises below a Claim the user's own votes are taken into account
+const USER_VIEW_MAX_DEPTH int = 50
+
+// Returns the user's current votes on the Claim and on everything its roll-up truth is derived from
+// (its premises, its arguments, their claims and so on), by the ID of the Claim or Argument they were cast on
+func (u User) VotesUnder(ctx *ServerContext, c Claim) (map[string]float32, Error) {
+	votes := map[string]float32{}
+
+	bindVars := BindVars{
+		"user":  u.ArangoID(),
+		"claim": c.ArangoID(),
+		"depth": USER_VIEW_MAX_DEPTH,
+	}
+	query := fmt.Sprintf(`FOR t, e, p IN 0..@depth OUTBOUND @claim %s, %s, %s
+                                 OPTIONS { bfs: true, uniqueVertices: "global" }
+                                 FILTER p.edges[*].end ALL == null
+                                 FOR obj IN %s
+                                   FILTER obj._from == @user
+                                      AND obj._to == t._id
+                                      AND obj.end == null
+                                   RETURN { id: t.id, score: obj.score }`,
+		Inference{}.CollectionName(),
+		BaseClaimEdge{}.CollectionName(),
+		PremiseEdge{}.CollectionName(),
+		UserScore{}.CollectionName())
+
+	db := ctx.Arango.DB
+	cursor, err := db.Query(ctx.Context, query, bindVars)
+	defer CloseCursor(cursor)
+	if err != nil {
+		return votes, NewServerError(err.Error())
+	}
+	for cursor.HasMore() {
+		vote := struct {
+			ID    string  `json:"id"`
+			Score float32 `json:"score"`
+		}{}
+		if _, err := cursor.ReadDocument(ctx.Context, &vote); err != nil {
+			return votes, NewServerError(err.Error())
+		}
+		votes[vote.ID] = vote.Score
+	}
+	return votes, nil
+}
+
+// Loads the full Claim, then annotates it, its premises, its arguments and their claims
+// with the given user's view of them
+func (c *Claim) LoadView(ctx *ServerContext, u User) Error {
+	if err := c.LoadFull(ctx); err != nil {
+		return err
+	}
+
+	votes, err := u.VotesUnder(ctx, *c)
+	if err != nil {
+		return err
+	}
+	state := newRollUpState()
+	state.votes = votes
+
+	if err := c.loadView(ctx, state); err != nil {
+		return err
+	}
+	for i := range c.PremiseClaims {
+		if err := c.PremiseClaims[i].loadView(ctx, state); err != nil {
+			return err
+		}
+	}
+	for _, args := range [][]Argument{c.ProArgs, c.ConArgs} {
+		for i := range args {
+			if err := args[i].loadView(ctx, state); err != nil {
+				return err
+			}
+			if args[i].Claim != nil {
+				if err := args[i].Claim.loadView(ctx, state); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Claim) loadView(ctx *ServerContext, state *rollUpState) Error {
+	rollUp, err := c.rollUpAt(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	view := UserView{RollUp: rollUp}
+	if vote, ok := state.votes[c.ID]; ok {
+		view.Score = &vote
+	}
+	if implied, ok := state.implied[c.ID]; ok {
+		view.Implied = &implied
+		if view.Score != nil {
+			inconsistency := float32(math.Abs(float64(*view.Score - implied)))
+			view.Inconsistency = &inconsistency
+		}
+	}
+
+	c.View = &view
+	return nil
+}
+
+func (a *Argument) loadView(ctx *ServerContext, state *rollUpState) Error {
+	rollUp, err := a.rollUpAt(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	view := UserView{RollUp: rollUp}
+	if vote, ok := state.votes[a.ID]; ok {
+		view.Score = &vote
+	}
+
+	a.View = &view
+	return nil
+}
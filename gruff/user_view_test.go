@@ -0,0 +1,164 @@
+package gruff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimLoadView(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	alice := User{Username: "ViewingAlice"}
+	err := alice.Create(CTX)
+	assert.NoError(t, err)
+	bob := User{Username: "ViewingBob"}
+	err = bob.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	claim := Claim{Title: "Everyone sees it differently"}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	arg := Argument{
+		TargetClaimID: &claim.ID,
+		Title:         "Depending on what you believe",
+		Pro:           true,
+	}
+	err = arg.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	baseClaim := Claim{}
+	baseClaim.ID = arg.ClaimID
+	err = baseClaim.Load(CTX)
+	assert.NoError(t, err)
+
+	// Alice doesn't believe the claim, even though she believes the argument for it
+	err = alice.Score(CTX, &claim, 0.10)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = alice.Score(CTX, &baseClaim, 1.00)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	err = bob.Score(CTX, &claim, 0.90)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	err = bob.Score(CTX, &baseClaim, 0.00)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	view := Claim{}
+	view.ID = claim.ID
+	err = view.LoadView(CTX, alice)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// The community's scores are left alone
+	assert.InDelta(t, 0.50, view.Truth, 0.0001)
+
+	assert.NotNil(t, view.View)
+	assert.InDelta(t, 0.10, *view.View.Score, 0.0001)
+	assert.InDelta(t, 0.55, view.View.RollUp, 0.0001)
+	assert.InDelta(t, 1.00, *view.View.Implied, 0.0001)
+	assert.InDelta(t, 0.90, *view.View.Inconsistency, 0.0001)
+
+	assert.Equal(t, 1, len(view.ProArgs))
+	argView := view.ProArgs[0]
+	assert.NotNil(t, argView.View)
+	assert.Nil(t, argView.View.Score)
+	assert.InDelta(t, 1.00, argView.View.RollUp, 0.0001)
+	assert.InDelta(t, 1.00, *argView.Claim.View.Score, 0.0001)
+	assert.Nil(t, argView.Claim.View.Implied)
+
+	// Without any votes of their own, a user sees the community's view
+	carol := User{Username: "ViewingCarol"}
+	err = carol.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	view = Claim{}
+	view.ID = claim.ID
+	err = view.LoadView(CTX, carol)
+	assert.NoError(t, err)
+	assert.Nil(t, view.View.Score)
+	assert.Nil(t, view.View.Inconsistency)
+	assert.InDelta(t, view.TruthRU, view.View.RollUp, 0.0001)
+}
+
+func TestMultiPremiseClaimLoadView(t *testing.T) {
+	setupDB()
+	defer teardownDB()
+
+	alice := User{Username: "PremisedAlice"}
+	err := alice.Create(CTX)
+	assert.NoError(t, err)
+	bob := User{Username: "PremisedBob"}
+	err = bob.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	claim := Claim{
+		Title:        "Both of these have to be true",
+		MultiPremise: true,
+		PremiseRule:  PREMISE_RULE_ALL,
+	}
+	err = claim.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	premise1 := Claim{Title: "The first thing is true"}
+	err = claim.AddPremise(CTX, &premise1)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+	premise2 := Claim{Title: "The second thing is true"}
+	err = claim.AddPremise(CTX, &premise2)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	elsewhere := Claim{Title: "Something else entirely"}
+	err = elsewhere.Create(CTX)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	for _, vote := range []struct {
+		user  User
+		claim *Claim
+		score float32
+	}{
+		{alice, &premise1, 0.50},
+		{alice, &premise2, 0.40},
+		{alice, &elsewhere, 0.90},
+		{bob, &premise1, 1.00},
+		{bob, &premise2, 1.00},
+	} {
+		err = vote.user.Score(CTX, vote.claim, vote.score)
+		assert.NoError(t, err)
+		CTX.RequestAt = nil
+	}
+
+	view := Claim{}
+	view.ID = claim.ID
+	err = view.LoadView(CTX, alice)
+	assert.NoError(t, err)
+	CTX.RequestAt = nil
+
+	// The community's truth comes from everyone's votes on the premises
+	assert.InDelta(t, 0.525, view.Truth, 0.0001)
+
+	// Alice's comes from her own
+	assert.Nil(t, view.View.Score)
+	assert.InDelta(t, 0.20, view.View.RollUp, 0.0001)
+	assert.Equal(t, 2, len(view.PremiseClaims))
+	assert.InDelta(t, 0.50, *view.PremiseClaims[0].View.Score, 0.0001)
+	assert.InDelta(t, 0.40, *view.PremiseClaims[1].View.Score, 0.0001)
+
+	// Only the votes that matter to the view are loaded
+	votes, err := alice.VotesUnder(CTX, view)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float32{premise1.ID: 0.50, premise2.ID: 0.40}, votes)
+}
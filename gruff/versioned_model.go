@@ -3,6 +3,7 @@ package gruff
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/GruffDebate/server/support"
@@ -104,6 +105,190 @@ func SetID(item interface{}, id string) Error {
 	return nil
 }
 
+// A Version summarizes a single stored version of a VersionedModel,
+// including the list of fields that were changed relative to the previous version
+type Version struct {
+	Key         string     `json:"_key"`
+	CreatedAt   time.Time  `json:"start"`
+	DeletedAt   *time.Time `json:"end"`
+	UpdatedByID string     `json:"editor,omitempty"`
+	Changed     []string   `json:"changed"`
+}
+
+// VersionsQuery returns a query for every version of the item with the ID given in @id,
+// from oldest to newest
+func VersionsQuery(obj ArangoObject) string {
+	return fmt.Sprintf(`FOR obj IN %s
+                              FILTER obj.id == @id
+                              SORT obj.start ASC
+                              RETURN obj`,
+		obj.CollectionName())
+}
+
+// BuildVersionHistory summarizes a slice of versions (sorted from oldest to newest),
+// comparing each version with the previous one using the given list of fields
+func BuildVersionHistory(versions interface{}, fields []string) ([]Version, Error) {
+	history := []Version{}
+
+	v := reflect.Indirect(reflect.ValueOf(versions))
+	if v.Kind() != reflect.Slice {
+		return history, NewServerError("Versions must be a slice of VersionedModels")
+	}
+
+	var prev interface{}
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i).Interface()
+		vm, err := GetVersionedModel(item)
+		if err != nil {
+			return history, err
+		}
+
+		changed := []string{}
+		if prev != nil {
+			changed = ChangedFields(prev, item, fields)
+		}
+
+		history = append(history, Version{
+			Key:         vm.Key,
+			CreatedAt:   vm.CreatedAt,
+			DeletedAt:   vm.DeletedAt,
+			UpdatedByID: vm.UpdatedByID,
+			Changed:     changed,
+		})
+		prev = item
+	}
+
+	return history, nil
+}
+
+// ChangedFields returns the subset of fields (by JSON name) whose values differ between the two items
+func ChangedFields(old, new interface{}, fields []string) []string {
+	changed := []string{}
+
+	oldMap := ModelToJsonMap(old)
+	newMap := ModelToJsonMap(new)
+	for _, f := range fields {
+		if !reflect.DeepEqual(oldMap[f], newMap[f]) {
+			changed = append(changed, f)
+		}
+	}
+
+	return changed
+}
+
+// A VersionDiff describes the differences between two versions of the same item
+type VersionDiff struct {
+	FromKey    string        `json:"from"`
+	ToKey      string        `json:"to"`
+	Fields     []FieldChange `json:"fields"`
+	Contexts   *EdgeDiff     `json:"contexts,omitempty"`
+	Premises   *EdgeDiff     `json:"premises,omitempty"`
+	Inferences *EdgeDiff     `json:"inferences,omitempty"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// An EdgeDiff lists the IDs of the items at the other end of the edges
+// that were added or removed between two versions
+type EdgeDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// FieldChanges returns the old and new values of each of the fields (by JSON name) that differ between the two items
+func FieldChanges(old, new interface{}, fields []string) []FieldChange {
+	changes := []FieldChange{}
+
+	oldMap := ModelToJsonMap(old)
+	newMap := ModelToJsonMap(new)
+	for _, f := range ChangedFields(old, new, fields) {
+		changes = append(changes, FieldChange{
+			Field: f,
+			From:  oldMap[f],
+			To:    newMap[f],
+		})
+	}
+
+	return changes
+}
+
+func DiffIDs(old, new []string) *EdgeDiff {
+	diff := EdgeDiff{
+		Added:   []string{},
+		Removed: []string{},
+	}
+
+	oldSet := map[string]bool{}
+	for _, id := range old {
+		oldSet[id] = true
+	}
+	newSet := map[string]bool{}
+	for _, id := range new {
+		newSet[id] = true
+		if !oldSet[id] {
+			diff.Added = append(diff.Added, id)
+		}
+	}
+	for _, id := range old {
+		if !newSet[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return &diff
+}
+
+// A VersionDiffer can compare two of its versions, identified by their keys
+type VersionDiffer interface {
+	DiffVersions(ctx *ServerContext, fromKey, toKey string) (VersionDiff, Error)
+}
+
+func IsVersionDiffer(t reflect.Type) bool {
+	modelType := reflect.TypeOf((*VersionDiffer)(nil)).Elem()
+	return t.Implements(modelType)
+}
+
+// A Reverter can create a new version of itself that restores the values of an earlier version
+type Reverter interface {
+	Revert(ctx *ServerContext, key string) Error
+}
+
+func IsReverter(t reflect.Type) bool {
+	modelType := reflect.TypeOf((*Reverter)(nil)).Elem()
+	return t.Implements(modelType)
+}
+
+// A VersionHistorian can list all of the versions that have been stored for an item
+type VersionHistorian interface {
+	VersionHistory(*ServerContext) ([]Version, Error)
+}
+
+func IsVersionHistorian(t reflect.Type) bool {
+	modelType := reflect.TypeOf((*VersionHistorian)(nil)).Elem()
+	return t.Implements(modelType)
+}
+
+func SetQueryAt(item interface{}, queryAt *time.Time) Error {
+	v := reflect.ValueOf(item)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return NewServerError("Cannot set value on nil item")
+		}
+		v = reflect.ValueOf(item).Elem()
+	}
+	if !IsVersionedModel(v.Type()) {
+		return NewServerError("Item is not a VersionedModel")
+	}
+	v.FieldByName("QueryAt").Set(reflect.ValueOf(queryAt))
+	return nil
+}
+
 type Versioner interface {
 	version(*ServerContext, Updates) Error
 }
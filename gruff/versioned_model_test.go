@@ -1,9 +1,12 @@
 package gruff
 
 import (
-	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/GruffDebate/server/support"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestIsVersionedModel(t *testing.T) {
@@ -40,3 +43,116 @@ func TestIsVersioner(t *testing.T) {
 	assert.True(t, IsVersioner(reflect.TypeOf(&Argument{})))
 	assert.False(t, IsVersioner(reflect.TypeOf(&Link{})))
 }
+
+func TestSetQueryAt(t *testing.T) {
+	queryAt := time.Now()
+
+	c := Claim{}
+	err := SetQueryAt(&c, &queryAt)
+	assert.NoError(t, err)
+	assert.Equal(t, &queryAt, c.QueryAt)
+
+	err = SetQueryAt(&c, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, c.QueryAt)
+
+	err = SetQueryAt(&Context{}, &queryAt)
+	assert.Equal(t, "Item is not a VersionedModel", err.Error())
+}
+
+func TestChangedFields(t *testing.T) {
+	c1 := Claim{Title: "The original title", Negation: "Not the original title"}
+	c2 := c1
+	c2.Title = "The new title"
+	c2.Truth = 0.25
+
+	assert.Equal(t, []string{"title"}, ChangedFields(c1, c2, CLAIM_VERSIONED_FIELDS))
+	assert.Equal(t, []string{}, ChangedFields(c1, c1, CLAIM_VERSIONED_FIELDS))
+
+	c2.MultiPremise = true
+	c2.PremiseRule = PREMISE_RULE_ALL
+	assert.Equal(t, []string{"title", "mp", "mprule"}, ChangedFields(c1, c2, CLAIM_VERSIONED_FIELDS))
+
+	a1 := Argument{Title: "An argument", TargetClaimID: support.StringPtr("c1")}
+	a2 := a1
+	a2.TargetClaimID = nil
+	a2.TargetArgumentID = support.StringPtr("a1")
+	assert.Equal(t, []string{"targetClaimId", "targetArgId"}, ChangedFields(a1, a2, ARGUMENT_VERSIONED_FIELDS))
+}
+
+func TestBuildVersionHistory(t *testing.T) {
+	editor := "users/editor"
+	c1 := Claim{Title: "The original title"}
+	c1.Key = "k1"
+	c1.CreatedAt = time.Now().Add(-2 * time.Hour)
+	c1.DeletedAt = support.TimePtr(time.Now().Add(-1 * time.Hour))
+	c2 := c1
+	c2.Key = "k2"
+	c2.Description = "Now with a description"
+	c2.CreatedAt = *c1.DeletedAt
+	c2.DeletedAt = nil
+	c2.UpdatedByID = editor
+
+	history, err := BuildVersionHistory([]Claim{c1, c2}, CLAIM_VERSIONED_FIELDS)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, "k1", history[0].Key)
+	assert.Equal(t, c1.DeletedAt, history[0].DeletedAt)
+	assert.Equal(t, []string{}, history[0].Changed)
+	assert.Equal(t, "k2", history[1].Key)
+	assert.Equal(t, editor, history[1].UpdatedByID)
+	assert.Nil(t, history[1].DeletedAt)
+	assert.Equal(t, []string{"desc"}, history[1].Changed)
+
+	_, err = BuildVersionHistory(c1, CLAIM_VERSIONED_FIELDS)
+	assert.Error(t, err)
+}
+
+func TestIsVersionHistorian(t *testing.T) {
+	assert.False(t, IsVersionHistorian(reflect.TypeOf(&User{})))
+	assert.False(t, IsVersionHistorian(reflect.TypeOf(&Context{})))
+	assert.True(t, IsVersionHistorian(reflect.TypeOf(&Claim{})))
+	assert.True(t, IsVersionHistorian(reflect.TypeOf(&Argument{})))
+	assert.False(t, IsVersionHistorian(reflect.TypeOf(&Link{})))
+}
+
+func TestFieldChanges(t *testing.T) {
+	c1 := Claim{Title: "The original title", Description: "Same"}
+	c2 := c1
+	c2.Title = "A new title"
+	c2.PremiseRule = PREMISE_RULE_ALL
+
+	changes := FieldChanges(c1, c2, CLAIM_VERSIONED_FIELDS)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, "title", changes[0].Field)
+	assert.Equal(t, "The original title", changes[0].From)
+	assert.Equal(t, "A new title", changes[0].To)
+	assert.Equal(t, "mprule", changes[1].Field)
+
+	assert.Equal(t, []FieldChange{}, FieldChanges(c1, c1, CLAIM_VERSIONED_FIELDS))
+}
+
+func TestDiffIDs(t *testing.T) {
+	diff := DiffIDs([]string{"a", "b", "c"}, []string{"d", "c", "a"})
+	assert.Equal(t, []string{"d"}, diff.Added)
+	assert.Equal(t, []string{"b"}, diff.Removed)
+
+	diff = DiffIDs([]string{}, []string{})
+	assert.Equal(t, []string{}, diff.Added)
+	assert.Equal(t, []string{}, diff.Removed)
+}
+
+func TestIsVersionDiffer(t *testing.T) {
+	assert.False(t, IsVersionDiffer(reflect.TypeOf(&User{})))
+	assert.False(t, IsVersionDiffer(reflect.TypeOf(&Context{})))
+	assert.True(t, IsVersionDiffer(reflect.TypeOf(&Claim{})))
+	assert.True(t, IsVersionDiffer(reflect.TypeOf(&Argument{})))
+}
+
+func TestIsReverter(t *testing.T) {
+	assert.False(t, IsReverter(reflect.TypeOf(&User{})))
+	assert.False(t, IsReverter(reflect.TypeOf(&Context{})))
+	assert.True(t, IsReverter(reflect.TypeOf(&Claim{})))
+	assert.True(t, IsReverter(reflect.TypeOf(&Argument{})))
+	assert.False(t, IsReverter(reflect.TypeOf(Claim{})))
+}
@@ -13,6 +13,16 @@ import (
 func main() {
 	config.Init()
 	api.ARANGODB_POOL = config.InitDB()
+	config.InitScoreAggregator()
+	config.InitMailer()
+	config.InitIdentityProviders()
+	config.InitClients()
+	config.InitRateLimits()
+	api.TRUSTED_PROXIES = config.InitTrustedProxies()
+
+	stopWorker := make(chan struct{})
+	worker := config.InitScoreWorker(api.ARANGODB_POOL)
+	go worker.Run(stopWorker)
 
 	root := api.SetUpRouter(api.ProductionMiddlewareConfigurer{})
 	addr := ":" + os.Getenv("PORT")
@@ -26,6 +36,7 @@ func main() {
 	quit := make(chan os.Signal)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
+	close(stopWorker)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := root.Shutdown(ctx); err != nil {